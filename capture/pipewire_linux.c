@@ -82,12 +82,22 @@ static const struct pw_core_events pipewire_core_events = {
     PW_VERSION_CORE_EVENTS,
 };
 
-int pipewire_run_loop(uint32_t fd, uint32_t node_id, uint32_t framerate)
+int pipewire_run_loop(uint32_t fd, uint32_t node_id, uint32_t framerate, uint32_t width,
+		      uint32_t height)
 {
 	struct pipewire_data *data = calloc(1, sizeof(struct pipewire_data));
 	const struct spa_pod *params[1];
 	uint8_t params_buffer[1024];
 	struct spa_pod_builder pod_builder;
+	// Without a size, let the node pick one. With one, ask for exactly it,
+	// which sets the size of a virtual monitor.
+	struct spa_rectangle size = SPA_RECTANGLE(320, 240);
+	struct spa_rectangle min_size = SPA_RECTANGLE(1, 1);
+	struct spa_rectangle max_size = SPA_RECTANGLE(4096, 4096);
+
+	if (width > 0 && height > 0) {
+		size = min_size = max_size = SPA_RECTANGLE(width, height);
+	}
 
 	data->fd = fd;
 	data->node_id = node_id;
@@ -129,8 +139,7 @@ int pipewire_run_loop(uint32_t fd, uint32_t node_id, uint32_t framerate)
 				   SPA_VIDEO_FORMAT_I420),
 
 	    SPA_FORMAT_VIDEO_size,
-	    SPA_POD_CHOICE_RANGE_Rectangle(&SPA_RECTANGLE(320, 240), &SPA_RECTANGLE(1, 1),
-					   &SPA_RECTANGLE(4096, 4096)),
+	    SPA_POD_CHOICE_RANGE_Rectangle(&size, &min_size, &max_size),
 
 	    SPA_FORMAT_VIDEO_framerate,
 	    SPA_POD_CHOICE_RANGE_Fraction(&SPA_FRACTION(framerate, 1), &SPA_FRACTION(0, 1),
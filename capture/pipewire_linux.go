@@ -7,7 +7,7 @@ package capture
 #include <spa/param/video/format-utils.h>
 #include <spa/param/video/type-info.h>
 
-int pipewire_run_loop(uint32_t, uint32_t, uint32_t);
+int pipewire_run_loop(uint32_t, uint32_t, uint32_t, uint32_t, uint32_t);
 */
 import "C"
 import (
@@ -16,6 +16,9 @@ import (
 	"fmt"
 	"image"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
@@ -30,8 +33,18 @@ import (
 
 // PipewireStream uses the org.freedesktop.portal.ScreenCast portal granted by
 // xdg-desktop-portal to generate a pipewire stream for screen capture.
+// Alternatively, it can consume a known pipewire node directly, see
+// NewPipewireNode.
 type PipewireStream struct {
-	dbusConn      *dbus.Conn
+	dbusConn *dbus.Conn
+	// direct is set for streams of NewPipewireNode, whose streamFD is a
+	// connection to the daemon that pipewire takes ownership of in Start.
+	direct  bool
+	started bool
+	// width, height and refresh are the video format asked of the node of a
+	// direct stream, zero to let the node pick.
+	width, height, refresh uint32
+
 	sessionHandle dbus.ObjectPath
 	restoreToken  string
 	streams       []struct {
@@ -80,30 +93,76 @@ func NewPipewire() (ret *PipewireStream, err error) {
 	return ret, nil
 }
 
+// NewPipewireNode consumes the given pipewire node over a direct connection to
+// the pipewire daemon, bypassing the portal. This is meant for nodes we created
+// ourselves, e.g. a Mutter virtual monitor, which takes the size and refresh
+// rate asked for by the stream.
+func NewPipewireNode(nodeID, width, height, refresh uint32) (*PipewireStream, error) {
+	runtimeDir := os.Getenv("PIPEWIRE_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = os.Getenv("XDG_RUNTIME_DIR")
+	}
+	remote := os.Getenv("PIPEWIRE_REMOTE")
+	if remote == "" {
+		remote = "pipewire-0"
+	}
+	conn, err := net.Dial("unix", filepath.Join(runtimeDir, remote))
+	if err != nil {
+		return nil, fmt.Errorf("pipewire: %w", err)
+	}
+	defer conn.Close()
+	// pw_context_connect_fd() takes ownership of the fd, so hand it a dup
+	// that nothing on the Go side closes.
+	f, err := conn.(*net.UnixConn).File()
+	if err != nil {
+		return nil, fmt.Errorf("pipewire: %w", err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("pipewire: dup: %w", err)
+	}
+	syscall.CloseOnExec(fd)
+	log.Printf("[pipewire] connected to %s for node %d", remote, nodeID)
+
+	ret := &PipewireStream{
+		endCh:   make(chan struct{}),
+		direct:  true,
+		width:   width,
+		height:  height,
+		refresh: refresh,
+	}
+	ret.streams = append(ret.streams, struct {
+		NodeID     uint32
+		Properties vardict
+	}{NodeID: nodeID})
+	ret.streamFD = dbus.UnixFDIndex(fd)
+	return ret, nil
+}
+
 func (p *PipewireStream) Close() error {
 	// TODO: more needs to be done here
 	// close pipewire thread and stream
 	// close dbus session
 	// unregister pipewire stream from global channel map
+	if p.direct && !p.started {
+		// Pipewire never took the connection over.
+		syscall.Close(int(p.streamFD))
+		p.direct = false
+	}
+	if p.dbusConn == nil {
+		return nil
+	}
 	return p.dbusConn.Close()
 }
 
 func (p *PipewireStream) Start(framerate uint32, _ image.Rectangle, cb func(image.Image)) (err error) {
-	if err := p.createSession(); err != nil {
-		return fmt.Errorf("createSession: %w", err)
-	}
-	if err := p.selectSources(); err != nil {
-		return fmt.Errorf("selectSources: %w", err)
-	}
-	log.Printf("[pipewire] created dbus screencast session")
-	if err := p.startSession(); err != nil {
-		return fmt.Errorf("startSession: %w", err)
-	}
-	log.Printf("[pipewire] cast id is %d", p.streams[0].NodeID)
-	if err := p.getStreamFD(); err != nil {
-		return fmt.Errorf("getStreamFD: %w", err)
+	if !p.direct {
+		if err := p.startPortal(); err != nil {
+			return err
+		}
 	}
-	log.Printf("[pipewire] cast fd is %d", p.streamFD)
+	p.started = true
 
 	// TODO: we probably need to lock this
 	pipewireReceiverMap[p.streams[0].NodeID] = p
@@ -111,9 +170,14 @@ func (p *PipewireStream) Start(framerate uint32, _ image.Rectangle, cb func(imag
 	// For now we are only concerned with the first stream node ID.
 	// Can have multiple, but we did not set that up in selectSources()
 	p.cb = cb
+	// The node can't produce frames faster than its refresh rate.
+	if p.refresh > 0 && (framerate == 0 || framerate > p.refresh) {
+		framerate = p.refresh
+	}
 	go func() {
 		runtime.LockOSThread()
-		ret := C.pipewire_run_loop(C.uint(p.streamFD), C.uint(p.streams[0].NodeID), C.uint(framerate))
+		ret := C.pipewire_run_loop(C.uint(p.streamFD), C.uint(p.streams[0].NodeID), C.uint(framerate),
+			C.uint(p.width), C.uint(p.height))
 		// todo: cleaner exit handling
 		panic(fmt.Errorf("[pipewire] pipewire_init exit status %d", ret))
 	}()
@@ -123,6 +187,27 @@ func (p *PipewireStream) Start(framerate uint32, _ image.Rectangle, cb func(imag
 	return nil
 }
 
+// startPortal negotiates a screen cast with xdg-desktop-portal, which prompts
+// the user to pick a source.
+func (p *PipewireStream) startPortal() error {
+	if err := p.createSession(); err != nil {
+		return fmt.Errorf("createSession: %w", err)
+	}
+	if err := p.selectSources(); err != nil {
+		return fmt.Errorf("selectSources: %w", err)
+	}
+	log.Printf("[pipewire] created dbus screencast session")
+	if err := p.startSession(); err != nil {
+		return fmt.Errorf("startSession: %w", err)
+	}
+	log.Printf("[pipewire] cast id is %d", p.streams[0].NodeID)
+	if err := p.getStreamFD(); err != nil {
+		return fmt.Errorf("getStreamFD: %w", err)
+	}
+	log.Printf("[pipewire] cast fd is %d", p.streamFD)
+	return nil
+}
+
 func (p *PipewireStream) createSession() error {
 	sessionHandleToken, handleToken := genToken(16), genToken(16)
 	return p.dbusRequest(&dbusRequest{
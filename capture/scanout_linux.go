@@ -0,0 +1,217 @@
+package capture
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inahga/vdisplay/internal/convert"
+	"github.com/inahga/vdisplay/internal/drm"
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+// Scanout reads the framebuffer scanned out by the primary plane of an active
+// CRTC, for cards without writeback connectors. Cursor and overlay planes are
+// not composed in, so it only matches the output of the CRTC when the primary
+// plane is the only one in use.
+//
+// The kernel only hands out the buffers of a framebuffer to the DRM master of
+// the card and to CAP_SYS_ADMIN. The framebuffer must be linear, in a format
+// that can be converted, and mappable as a dumb buffer, as on vkms.
+type Scanout struct {
+	card      *drm.Card
+	plane     uint32
+	crtcIndex int
+	refresh   uint32
+	width     int
+	height    int
+
+	// timestamp is the CLOCK_MONOTONIC time in nanoseconds of the vblank that
+	// the last captured frame was read at.
+	timestamp int64
+
+	endCh chan struct{}
+	wg    sync.WaitGroup
+}
+
+// CheckScanout reports whether the card can support a scanout capture, which
+// maps framebuffers as dumb buffers. The card is only queried.
+func CheckScanout(card *drm.Card) error {
+	if err := checkDumbBuffers(card); err != nil {
+		return fmt.Errorf("scanout: %w", err)
+	}
+	return nil
+}
+
+// NewScanout sets up the capture of the first active CRTC with a primary plane.
+// The universal planes client capability is set on the card.
+func NewScanout(card *drm.Card) (*Scanout, error) {
+	if err := CheckScanout(card); err != nil {
+		return nil, err
+	}
+	if err := card.SetClientCap(drm.ClientCapUniversalPlanes, 1); err != nil {
+		return nil, fmt.Errorf("scanout: universal planes cap: %w", err)
+	}
+	resources, err := card.ModeGetResources()
+	if err != nil {
+		return nil, fmt.Errorf("scanout: %w", err)
+	}
+	for i, id := range resources.Crtcs {
+		crtc, err := card.ModeGetCrtc(id)
+		if err != nil {
+			return nil, fmt.Errorf("scanout: %w", err)
+		}
+		if crtc.Mode == nil {
+			continue
+		}
+		plane, err := card.PrimaryPlane(i)
+		if err != nil {
+			return nil, fmt.Errorf("scanout: %w", err)
+		}
+		if plane == 0 {
+			log.Printf("[scanout] CRTC %d has no primary plane", id)
+			continue
+		}
+		return &Scanout{
+			card:      card,
+			plane:     plane,
+			crtcIndex: i,
+			refresh:   crtc.Mode.VRefresh,
+			width:     int(crtc.Mode.HDisplay),
+			height:    int(crtc.Mode.VDisplay),
+			endCh:     make(chan struct{}),
+		}, nil
+	}
+	return nil, fmt.Errorf("scanout: couldn't find an active CRTC with a primary plane")
+}
+
+func (s *Scanout) Close() error {
+	select {
+	case <-s.endCh:
+		return nil
+	default:
+	}
+	close(s.endCh)
+	s.wg.Wait()
+	return nil
+}
+
+// Start reads the framebuffer of the primary plane on the vblanks of the CRTC
+// closest to framerate, and passes rect of it to cb. The whole CRTC is
+// captured if rect is empty.
+func (s *Scanout) Start(framerate uint32, rect image.Rectangle, cb func(image.Image)) error {
+	bounds := image.Rect(0, 0, s.width, s.height)
+	if rect.Empty() {
+		rect = bounds
+	}
+	rect = rect.Intersect(bounds)
+	if rect.Empty() {
+		return fmt.Errorf("scanout: capture area outside of the %dx%d CRTC", s.width, s.height)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		interval := uint32(1)
+		if framerate > 0 && s.refresh > framerate {
+			interval = (s.refresh + framerate/2) / framerate
+		}
+		vbl, err := s.card.WaitVblank(s.crtcIndex, drm.WaitVblankRelative, 0, 0)
+		if err != nil {
+			log.Printf("[scanout] vblank: %s", err)
+			return
+		}
+		for {
+			select {
+			case <-s.endCh:
+				return
+			default:
+			}
+
+			vbl, err = s.card.WaitVblank(s.crtcIndex, drm.WaitVblankAbsolute|drm.WaitVblankNextOnMiss,
+				vbl.Sequence+interval, 0)
+			if err != nil {
+				log.Printf("[scanout] vblank: %s", err)
+				return
+			}
+			img, err := s.read(rect)
+			if err != nil {
+				log.Printf("[scanout] %s", err)
+				continue
+			}
+			atomic.StoreInt64(&s.timestamp, int64(vbl.Timestamp))
+			cb(img)
+		}
+	}()
+	return nil
+}
+
+// Timestamp returns the CLOCK_MONOTONIC time of the vblank that the frame last
+// passed to the callback was read at, for measuring capture latency.
+func (s *Scanout) Timestamp() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.timestamp))
+}
+
+// read converts rect of the framebuffer currently on the primary plane. The
+// framebuffer is looked up every time, as clients flip between several.
+func (s *Scanout) read(rect image.Rectangle) (*image.RGBA, error) {
+	plane, err := s.card.ModeGetPlane(s.plane)
+	if err != nil {
+		return nil, err
+	}
+	if plane.FBID == 0 {
+		return nil, fmt.Errorf("no framebuffer on plane %d", s.plane)
+	}
+	fb, err := s.card.GetFB2(plane.FBID)
+	if err != nil {
+		return nil, fmt.Errorf("framebuffer %d: %w", plane.FBID, err)
+	}
+	defer func() {
+		closed := make(map[uint32]bool)
+		for _, handle := range fb.Handles {
+			if handle != 0 && !closed[handle] {
+				s.card.GemClose(handle)
+				closed[handle] = true
+			}
+		}
+	}()
+	if fb.Handles[0] == 0 {
+		return nil, fmt.Errorf("framebuffer %d: %w", plane.FBID, drm.ErrNotMaster)
+	}
+	if fb.Flags&drm.FBModifiers != 0 && fb.Modifiers[0] != fourcc.ModLinear {
+		return nil, fmt.Errorf("framebuffer %d: unsupported modifier %s", plane.FBID, fourcc.ModifierName(fb.Modifiers[0]))
+	}
+	if !convert.Supported(fb.PixelFormat) {
+		return nil, fmt.Errorf("framebuffer %d: unsupported format %s", plane.FBID, fourcc.Name(fb.PixelFormat))
+	}
+	if !rect.In(image.Rect(0, 0, int(fb.Width), int(fb.Height))) {
+		return nil, fmt.Errorf("framebuffer %d: %dx%d is smaller than the capture area", plane.FBID, fb.Width, fb.Height)
+	}
+
+	info, _ := fourcc.Lookup(fb.PixelFormat)
+	mapping, err := s.card.MapDumb(&drm.DumbBuffer{
+		Handle: fb.Handles[0],
+		Width:  fb.Width,
+		Height: fb.Height,
+		BPP:    uint32(info.BPP()),
+		Pitch:  fb.Pitches[0],
+		Size:   uint64(fb.Offsets[0]) + uint64(fb.Pitches[0])*uint64(fb.Height),
+	}, fb.PixelFormat)
+	if err != nil {
+		return nil, fmt.Errorf("framebuffer %d: %w", plane.FBID, err)
+	}
+	defer mapping.Unmap()
+
+	// As with writeback, the BGRx frame is passed on as RGBA.
+	img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	offset := int(fb.Offsets[0]) + rect.Min.Y*mapping.Stride + rect.Min.X*info.CPP[0]
+	if err := convert.ToBGRx(img.Pix, img.Stride, mapping.Pix[offset:], mapping.Stride,
+		rect.Dx(), rect.Dy(), fb.PixelFormat); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
@@ -0,0 +1,73 @@
+package capture
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/inahga/vdisplay/internal/drm"
+)
+
+func TestCheckScanout(t *testing.T) {
+	if err := CheckScanout(drm.NewWithTransport(drm.NewFakeVKMS(testMode))); err != nil {
+		t.Errorf("vkms: %s", err)
+	}
+	noDumb := drm.NewFakeVKMS(testMode)
+	noDumb.SetCap(drm.CapDumbBuffer, 0)
+	if err := CheckScanout(drm.NewWithTransport(noDumb)); err == nil {
+		t.Errorf("no dumb buffers: got no error")
+	}
+}
+
+func TestScanout(t *testing.T) {
+	card := drm.NewWithTransport(drm.NewFakeVKMS(testMode))
+	s, err := NewScanout(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.width != int(testMode.HDisplay) || s.height != int(testMode.VDisplay) {
+		t.Errorf("got a %dx%d scanout", s.width, s.height)
+	}
+	// The primary plane has no framebuffer yet.
+	if _, err := s.read(image.Rect(0, 0, 10, 10)); err == nil {
+		t.Errorf("no framebuffer: got no error")
+	}
+	if err := s.Start(0, image.Rect(2000, 0, 2010, 10), func(image.Image) {}); err == nil {
+		t.Errorf("area outside of the CRTC: got no error")
+	}
+
+	pixel := [4]byte{0x10, 0x20, 0x30, 0xff}
+	scanoutFill(t, card, pixel)
+	frames := make(chan image.Image, 1)
+	err = s.Start(30, image.Rect(10, 20, 30, 30), func(img image.Image) {
+		select {
+		case frames <- img:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame image.Image
+	select {
+	case frame = <-frames:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame captured")
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+
+	rgba := frame.(*image.RGBA)
+	if rgba.Rect != image.Rect(0, 0, 20, 10) {
+		t.Errorf("got a frame of %s, want 20x10", rgba.Rect)
+	}
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if [3]byte{rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]} != [3]byte{pixel[0], pixel[1], pixel[2]} {
+			t.Fatalf("got pixel % x at %d, want % x", rgba.Pix[i:i+4], i/4, pixel)
+		}
+	}
+	if s.Timestamp() == 0 {
+		t.Errorf("no vblank timestamp")
+	}
+}
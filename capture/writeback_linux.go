@@ -1,7 +1,18 @@
 package capture
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inahga/vdisplay/internal/convert"
 	"github.com/inahga/vdisplay/internal/drm"
+	"github.com/inahga/vdisplay/internal/fourcc"
 )
 
 // Writeback uses DRM writeback connectors for output.
@@ -9,37 +20,301 @@ import (
 // See https://gitlab.freedesktop.org/wayland/weston/-/merge_requests/458
 // for example implementation.
 //
-// This capture backend is not possible without more widespread support for DRM
-// leasing. The required mode and prop setting is gated by being the DRM master,
-// which won't reasonably be us.
+// Queueing a writeback job is an atomic commit, which requires being DRM
+// master of the card, or holding a lease of the writeback connector and the
+// CRTC to capture, see drm.LeaseWriteback. The CRTC must already be active.
 type Writeback struct {
-	// card      *drm.Card
-	// connector *drm.ModeConnector
+	card      *drm.Card
+	connector uint32
+	crtc      uint32
+	crtcIndex int
+	refresh   uint32
+	format    uint32
+	width     int
+	height    int
+
+	// timestamp is the CLOCK_MONOTONIC time in nanoseconds of the vblank that
+	// the last captured frame was queued at.
+	timestamp int64
+
+	propCrtcID   uint32
+	propFBID     uint32
+	propOutFence uint32
+
+	endCh chan struct{}
+	wg    sync.WaitGroup
 }
 
+// writebackFenceTimeout is how long a writeback job may take, which is at least
+// a frame of the CRTC.
+const writebackFenceTimeout = time.Second
+
+// CheckWriteback reports whether the card can support a writeback capture: it
+// must have dumb buffers and a writeback connector. The card is only queried,
+// the client capabilities are left for NewWriteback to set. As the kernel hides
+// writeback connectors from clients that haven't set them, they are looked up
+// among the connectors that sysfs lists for the card, dev.
+func CheckWriteback(card *drm.Card, dev *drm.SysfsDevice) error {
+	if err := checkDumbBuffers(card); err != nil {
+		return fmt.Errorf("writeback: %w", err)
+	}
+	writeback := drm.ConnectorTypeName(drm.ModeConnectorWriteback)
+	for _, connector := range dev.Connectors {
+		if connector.TypeName() == writeback {
+			return nil
+		}
+	}
+	return fmt.Errorf("writeback: %s has no writeback connector", dev.Name)
+}
+
+// NewWriteback sets up the capture of an active CRTC through a writeback
+// connector. The atomic and writeback connector client capabilities are set
+// on the card.
 func NewWriteback(card *drm.Card) (*Writeback, error) {
-	panic("not supported")
-	// ret := &Writeback{card: card}
-
-	// resources, err := card.ModeGetResources()
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// for _, id := range resources.ConnectorIDs {
-	// 	candidate, err := card.ModeGetConnector(id)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	if candidate.Type == drm.ModeConnectorWriteback {
-	// 		ret.connector = candidate
-	// 		break
-	// 	}
-	// }
-	// if ret.connector == nil {
-	// 	return nil, fmt.Errorf("couldn't find writeback connector")
-	// }
-
-	// fmt.Println(card.ModeGetProperty(37))
-
-	// return ret, nil
+	if err := checkDumbBuffers(card); err != nil {
+		return nil, fmt.Errorf("writeback: %w", err)
+	}
+	if err := card.SetClientCap(drm.ClientCapAtomic, 1); err != nil {
+		return nil, fmt.Errorf("writeback: atomic cap: %w", err)
+	}
+	if err := card.SetClientCap(drm.ClientCapWritebackConnectors, 1); err != nil {
+		return nil, fmt.Errorf("writeback: writeback cap: %w", err)
+	}
+	ret := &Writeback{card: card, endCh: make(chan struct{})}
+
+	resources, err := card.ModeGetResources()
+	if err != nil {
+		return nil, fmt.Errorf("writeback: %w", err)
+	}
+	for _, id := range resources.Connectors {
+		candidate, err := card.ModeGetConnector(id)
+		if err != nil {
+			return nil, fmt.Errorf("writeback: %w", err)
+		}
+		if candidate.Type != drm.ModeConnectorWriteback {
+			continue
+		}
+		if err := ret.findCrtc(resources, candidate); err != nil {
+			log.Printf("[writeback] connector %d: %s", id, err)
+			continue
+		}
+		ret.connector = id
+		break
+	}
+	if ret.connector == 0 {
+		return nil, fmt.Errorf("writeback: couldn't find writeback connector for an active CRTC")
+	}
+	if err := ret.readProperties(); err != nil {
+		return nil, fmt.Errorf("writeback: %w", err)
+	}
+	return ret, nil
+}
+
+func checkDumbBuffers(card *drm.Card) error {
+	if dumb, err := card.GetCap(drm.CapDumbBuffer); err != nil || dumb == 0 {
+		return errors.New("card has no dumb buffers")
+	}
+	return nil
+}
+
+// findCrtc picks the first active CRTC that the connector can be attached to.
+func (w *Writeback) findCrtc(resources *drm.ModeResources, connector *drm.ModeConnector) error {
+	var possibleCrtcs uint32
+	for _, id := range connector.Encoders {
+		encoder, err := w.card.ModeGetEncoder(id)
+		if err != nil {
+			return err
+		}
+		possibleCrtcs |= encoder.PossibleCrtcs
+	}
+	for i, id := range resources.Crtcs {
+		if possibleCrtcs&(1<<i) == 0 {
+			continue
+		}
+		crtc, err := w.card.ModeGetCrtc(id)
+		if err != nil {
+			return err
+		}
+		if crtc.Mode != nil {
+			w.crtc, w.crtcIndex, w.refresh = id, i, crtc.Mode.VRefresh
+			w.width, w.height = int(crtc.Mode.HDisplay), int(crtc.Mode.VDisplay)
+			return nil
+		}
+	}
+	return fmt.Errorf("no active CRTC")
+}
+
+// readProperties looks up the writeback properties of the connector, and picks
+// an output format.
+func (w *Writeback) readProperties() error {
+	props, err := w.card.ObjectGetProperties(w.connector, drm.ModeObjectConnector)
+	if err != nil {
+		return err
+	}
+	for name, id := range map[string]*uint32{
+		"CRTC_ID":                 &w.propCrtcID,
+		"WRITEBACK_FB_ID":         &w.propFBID,
+		"WRITEBACK_OUT_FENCE_PTR": &w.propOutFence,
+	} {
+		prop, ok := props.Lookup(name)
+		if !ok {
+			return fmt.Errorf("connector %d has no %s property", w.connector, name)
+		}
+		*id = prop.ID
+	}
+
+	prop, ok := props.Lookup("WRITEBACK_PIXEL_FORMATS")
+	if !ok {
+		return fmt.Errorf("connector %d has no WRITEBACK_PIXEL_FORMATS property", w.connector)
+	}
+	blob, err := w.card.ModeGetPropBlob(prop.BlobID())
+	if err != nil {
+		return fmt.Errorf("WRITEBACK_PIXEL_FORMATS: %w", err)
+	}
+	formats := make([]uint32, len(blob)/4)
+	for i := range formats {
+		formats[i] = binary.LittleEndian.Uint32(blob[i*4:])
+	}
+	// Formats laid out as BGRx, as passed on by the other backends, need no
+	// conversion, so they are preferred.
+	for _, preferred := range [][]uint32{{fourcc.XRGB8888, fourcc.ARGB8888}, formats} {
+		for _, format := range preferred {
+			if hasFormat(formats, format) && convert.Supported(format) {
+				w.format = format
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("connector %d supports no convertible writeback format", w.connector)
+}
+
+func hasFormat(formats []uint32, format uint32) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Writeback) Close() error {
+	select {
+	case <-w.endCh:
+		return nil
+	default:
+	}
+	close(w.endCh)
+	w.wg.Wait()
+
+	// Detach the connector, so that it can be attached to another CRTC.
+	req := w.card.NewAtomicRequest()
+	req.Set(w.connector, w.propCrtcID, 0)
+	if err := req.Commit(drm.AtomicAllowModeset); err != nil {
+		return fmt.Errorf("writeback: %w", err)
+	}
+	return nil
+}
+
+// Start queues a writeback job on the vblanks of the CRTC closest to framerate,
+// and passes rect of each written back frame to cb. The whole CRTC is captured
+// if rect is empty.
+func (w *Writeback) Start(framerate uint32, rect image.Rectangle, cb func(image.Image)) error {
+	bounds := image.Rect(0, 0, w.width, w.height)
+	if rect.Empty() {
+		rect = bounds
+	}
+	rect = rect.Intersect(bounds)
+	if rect.Empty() {
+		return fmt.Errorf("writeback: capture area outside of the %dx%d CRTC", w.width, w.height)
+	}
+
+	info, _ := fourcc.Lookup(w.format)
+	dumb, err := w.card.CreateDumb(uint32(w.width), uint32(w.height), uint32(info.BPP()))
+	if err != nil {
+		return fmt.Errorf("writeback: %w", err)
+	}
+	mapping, err := w.card.MapDumb(dumb, w.format)
+	if err != nil {
+		w.card.DestroyDumb(dumb.Handle)
+		return fmt.Errorf("writeback: %w", err)
+	}
+	fb, err := w.card.AddDumbFB(dumb, w.format)
+	if err != nil {
+		mapping.Unmap()
+		w.card.DestroyDumb(dumb.Handle)
+		return fmt.Errorf("writeback: %w", err)
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() {
+			w.card.RmFB(fb)
+			mapping.Unmap()
+			w.card.DestroyDumb(dumb.Handle)
+		}()
+
+		// Frames are taken every interval vblanks, skipping the ones
+		// missed while a job was in flight.
+		interval := uint32(1)
+		if framerate > 0 && w.refresh > framerate {
+			interval = (w.refresh + framerate/2) / framerate
+		}
+		vbl, err := w.card.WaitVblank(w.crtcIndex, drm.WaitVblankRelative, 0, 0)
+		if err != nil {
+			log.Printf("[writeback] vblank: %s", err)
+			return
+		}
+		for {
+			select {
+			case <-w.endCh:
+				return
+			default:
+			}
+
+			vbl, err = w.card.WaitVblank(w.crtcIndex, drm.WaitVblankAbsolute|drm.WaitVblankNextOnMiss,
+				vbl.Sequence+interval, 0)
+			if err != nil {
+				log.Printf("[writeback] vblank: %s", err)
+				return
+			}
+			if err := w.writeback(fb); err != nil {
+				log.Printf("[writeback] %s", err)
+				continue
+			}
+
+			// As with pipewire, we will lie and pretend that the BGRx
+			// frame is RGBA.
+			img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+			offset := rect.Min.Y*mapping.Stride + rect.Min.X*info.CPP[0]
+			if err := convert.ToBGRx(img.Pix, img.Stride, mapping.Pix[offset:], mapping.Stride,
+				rect.Dx(), rect.Dy(), w.format); err != nil {
+				log.Printf("[writeback] %s", err)
+				continue
+			}
+			atomic.StoreInt64(&w.timestamp, int64(vbl.Timestamp))
+			cb(img)
+		}
+	}()
+	return nil
+}
+
+// Timestamp returns the CLOCK_MONOTONIC time of the vblank that the frame last
+// passed to the callback was queued at, for measuring capture latency.
+func (w *Writeback) Timestamp() time.Duration {
+	return time.Duration(atomic.LoadInt64(&w.timestamp))
+}
+
+// writeback queues a job writing the next frame of the CRTC into fb, and waits
+// for it to complete.
+func (w *Writeback) writeback(fb uint32) error {
+	req := w.card.NewAtomicRequest()
+	req.Set(w.connector, w.propCrtcID, uint64(w.crtc))
+	req.Set(w.connector, w.propFBID, uint64(fb))
+	fence := req.OutFence(w.connector, w.propOutFence)
+	defer fence.Close()
+	if err := req.Commit(drm.AtomicAllowModeset); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return fence.Wait(writebackFenceTimeout)
 }
@@ -0,0 +1,162 @@
+package capture
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/inahga/vdisplay/internal/drm"
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+var testMode = func() drm.ModeInfo {
+	ret := drm.ModeInfo{
+		Clock: 56000, HDisplay: 1024, HSyncStart: 1072, HSyncEnd: 1104, HTotal: 1184,
+		VDisplay: 768, VSyncStart: 771, VSyncEnd: 775, VTotal: 791,
+		VRefresh: 60, Flags: drm.ModeFlagPHSync | drm.ModeFlagNVSync, Type: drm.ModeTypeDriver,
+	}
+	ret.SetName("1024x768")
+	return ret
+}()
+
+// scanoutFill shows a framebuffer filled with a BGRx pixel on the primary
+// plane of a fake vkms card.
+func scanoutFill(t *testing.T, card *drm.Card, pixel [4]byte) {
+	t.Helper()
+	if err := card.SetClientCap(drm.ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	plane, err := card.PrimaryPlane(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumb, err := card.CreateDumb(uint32(testMode.HDisplay), uint32(testMode.VDisplay), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := card.MapDumb(dumb, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Unmap()
+	for i := 0; i < len(mapping.Pix); i += 4 {
+		copy(mapping.Pix[i:], pixel[:])
+	}
+	fb, err := card.AddDumbFB(dumb, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := card.ObjectGetProperties(plane, drm.ModeObjectPlane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := card.NewAtomicRequest()
+	if err := req.SetByName(plane, props, "FB_ID", uint64(fb)); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Commit(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testSysfs is how sysfs lists a vkms card with writeback enabled.
+var testSysfs = drm.SysfsDevice{
+	Name: "card1",
+	Connectors: []drm.SysfsConnector{
+		{Name: "card1-Virtual-1", Status: "connected"},
+		{Name: "card1-Writeback-1", Status: "unknown"},
+	},
+}
+
+func TestCheckWriteback(t *testing.T) {
+	dev := drm.NewFakeVKMS(testMode)
+	if err := CheckWriteback(drm.NewWithTransport(dev), &testSysfs); err != nil {
+		t.Errorf("vkms: %s", err)
+	}
+	// The check must not change the client capabilities of the card.
+	for _, cc := range []uint64{drm.ClientCapAtomic, drm.ClientCapWritebackConnectors} {
+		if dev.ClientCap(cc) != 0 {
+			t.Errorf("client cap %d set by the check", cc)
+		}
+	}
+
+	noDumb := drm.NewFakeVKMS(testMode)
+	noDumb.SetCap(drm.CapDumbBuffer, 0)
+	if err := CheckWriteback(drm.NewWithTransport(noDumb), &testSysfs); err == nil {
+		t.Errorf("no dumb buffers: got no error")
+	}
+
+	noWriteback := drm.SysfsDevice{Name: "card1", Connectors: testSysfs.Connectors[:1]}
+	if err := CheckWriteback(drm.NewWithTransport(drm.NewFakeVKMS(testMode)), &noWriteback); err == nil {
+		t.Errorf("no writeback connector: got no error")
+	}
+}
+
+func TestNewWritebackInactive(t *testing.T) {
+	dev := drm.NewFakeDevice("vkms")
+	dev.AddCrtc(nil)
+	encoder := dev.AddEncoder(drm.ModeEncoderVirtual, 1)
+	dev.AddConnector(drm.ModeConnectorWriteback, drm.ModeUnknownConnection, []uint32{encoder}, nil)
+	if _, err := NewWriteback(drm.NewWithTransport(dev)); err == nil {
+		t.Errorf("got no error without an active CRTC")
+	}
+}
+
+func TestWriteback(t *testing.T) {
+	dev := drm.NewFakeVKMS(testMode)
+	card := drm.NewWithTransport(dev)
+	pixel := [4]byte{0x10, 0x20, 0x30, 0xff}
+	scanoutFill(t, card, pixel)
+
+	w, err := NewWriteback(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cc := range []uint64{drm.ClientCapAtomic, drm.ClientCapWritebackConnectors} {
+		if dev.ClientCap(cc) != 1 {
+			t.Errorf("client cap %d not set", cc)
+		}
+	}
+	if w.width != int(testMode.HDisplay) || w.height != int(testMode.VDisplay) || w.format != fourcc.XRGB8888 {
+		t.Errorf("got a %dx%d %s writeback", w.width, w.height, fourcc.Name(w.format))
+	}
+	if err := w.Start(0, image.Rect(2000, 0, 2010, 10), func(image.Image) {}); err == nil {
+		t.Errorf("area outside of the CRTC: got no error")
+	}
+
+	frames := make(chan image.Image, 1)
+	err = w.Start(30, image.Rect(10, 20, 30, 30), func(img image.Image) {
+		select {
+		case frames <- img:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame image.Image
+	select {
+	case frame = <-frames:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame captured")
+	}
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	rgba := frame.(*image.RGBA)
+	if rgba.Rect != image.Rect(0, 0, 20, 10) {
+		t.Errorf("got a frame of %s, want 20x10", rgba.Rect)
+	}
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if [3]byte{rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]} != [3]byte{pixel[0], pixel[1], pixel[2]} {
+			t.Fatalf("got pixel % x at %d, want % x", rgba.Pix[i:i+4], i/4, pixel)
+		}
+	}
+	if w.Timestamp() == 0 {
+		t.Errorf("no vblank timestamp")
+	}
+	if v, _ := dev.Property(w.connector, "CRTC_ID"); v != 0 {
+		t.Errorf("writeback connector still on CRTC %d after Close", v)
+	}
+}
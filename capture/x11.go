@@ -4,6 +4,8 @@ package capture
 
 import (
 	"image"
+	"log"
+	"time"
 
 	"github.com/jezek/xgb"
 	"github.com/jezek/xgb/xfixes"
@@ -18,10 +20,16 @@ type X11 struct {
 	conn  *xgb.Conn
 	setup *xproto.SetupInfo
 	root  xproto.Window
+
+	endCh chan struct{}
 }
 
+// x11DefaultFramerate is used when Start is given no framerate, as X offers
+// no vblank to pace captures on.
+const x11DefaultFramerate = 60
+
 func NewX11() (ret *X11, err error) {
-	ret = &X11{}
+	ret = &X11{endCh: make(chan struct{})}
 
 	ret.conn, err = xgb.NewConn()
 	if err != nil {
@@ -40,12 +48,51 @@ func NewX11() (ret *X11, err error) {
 	return ret, nil
 }
 
-func (x *X11) Close() {
+func (x *X11) Close() error {
+	select {
+	case <-x.endCh:
+		return nil
+	default:
+	}
+	close(x.endCh)
 	x.conn.Close()
+	return nil
 }
 
-func (x *X11) Start(framerate uint32, rect image.Rectangle, cb func(image.Image)) {
+// Start captures rect of the root window, or the whole screen if rect is empty.
+// A zero framerate captures at x11DefaultFramerate.
+func (x *X11) Start(framerate uint32, rect image.Rectangle, cb func(image.Image)) error {
+	if framerate == 0 {
+		framerate = x11DefaultFramerate
+	}
+	if rect.Empty() {
+		screen := x.setup.DefaultScreen(x.conn)
+		rect = image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels))
+	}
+
 	go func() {
-		_ = x.setup.DefaultScreen(x.conn)
+		ticker := time.NewTicker(time.Second / time.Duration(framerate))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-x.endCh:
+				return
+			case <-ticker.C:
+			}
+
+			reply, err := xproto.GetImage(x.conn, xproto.ImageFormatZPixmap, xproto.Drawable(x.root),
+				int16(rect.Min.X), int16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy()), ^uint32(0)).Reply()
+			if err != nil {
+				log.Printf("[x11] GetImage: %s", err)
+				continue
+			}
+
+			// As with pipewire, we will lie and pretend that the BGRx ZPixmap
+			// is RGBA.
+			img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+			img.Pix = reply.Data
+			cb(img)
+		}
 	}()
+	return nil
 }
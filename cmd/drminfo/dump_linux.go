@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/inahga/vdisplay/internal/drm"
+	"github.com/inahga/vdisplay/internal/edid"
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+var (
+	caps = []struct {
+		name string
+		cap  uint64
+	}{
+		{"DUMB_BUFFER", drm.CapDumbBuffer},
+		{"VBLANK_HIGH_CRTC", drm.CapVblankHighCrtc},
+		{"DUMB_PREFERRED_DEPTH", drm.CapDumbPreferredDepth},
+		{"DUMB_PREFER_SHADOW", drm.CapDumbPreferShadow},
+		{"PRIME", drm.CapPrime},
+		{"TIMESTAMP_MONOTONIC", drm.CapTimestampMonotonic},
+		{"ASYNC_PAGE_FLIP", drm.CapAsyncPageFlip},
+		{"CURSOR_WIDTH", drm.CapCursorWidth},
+		{"CURSOR_HEIGHT", drm.CapCursorHeight},
+		{"ADDFB2_MODIFIERS", drm.CapAddFB2Modifiers},
+		{"PAGE_FLIP_TARGET", drm.CapPageFlipTarget},
+		{"CRTC_IN_VBLANK_EVENT", drm.CapCrtcInVblankEvent},
+		{"SYNCOBJ", drm.CapSyncObj},
+		{"SYNCOBJ_TIMELINE", drm.CapSyncObjTimeline},
+		{"ATOMIC_ASYNC_PAGE_FLIP", drm.CapAtomicAsyncPageFlip},
+	}
+
+	// clientCaps are set in order, as atomic needs universal planes and
+	// writeback connectors need atomic.
+	clientCaps = []struct {
+		name string
+		cap  uint64
+	}{
+		{"UNIVERSAL_PLANES", drm.ClientCapUniversalPlanes},
+		{"ATOMIC", drm.ClientCapAtomic},
+		{"WRITEBACK_CONNECTORS", drm.ClientCapWritebackConnectors},
+	}
+
+	connectionNames = map[uint32]string{
+		drm.ModeConnected:         "connected",
+		drm.ModeDisconnected:      "disconnected",
+		drm.ModeUnknownConnection: "unknown",
+	}
+)
+
+// dump reads the state of the cards at paths, or of every card listed in
+// sysfs if there are none.
+func dump(paths []string) ([]card, error) {
+	devices, err := drm.SysfsDevices(drm.SysfsDir)
+	if err != nil && len(paths) == 0 {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		for _, dev := range devices {
+			paths = append(paths, dev.PrimaryNode)
+		}
+	}
+
+	ret := make([]card, len(paths))
+	for i, path := range paths {
+		ret[i] = readCard(path)
+		for _, dev := range devices {
+			if dev.PrimaryNode == path {
+				ret[i].Driver, ret[i].RenderNode = dev.Driver, dev.RenderNode
+			}
+		}
+	}
+	return ret, nil
+}
+
+func readCard(path string) card {
+	ret := card{Path: path, Caps: make(map[string]uint64), ClientCaps: make(map[string]bool)}
+	c, err := drm.Open(path)
+	if err != nil {
+		ret.addError("open", err)
+		return ret
+	}
+	defer c.Close()
+	readState(&ret, c)
+	return ret
+}
+
+// readState fills in ret with the state of c.
+func readState(ret *card, c *drm.Card) {
+	if v, err := c.Version(); err != nil {
+		ret.addError("version", err)
+	} else {
+		ret.Version = &version{
+			Major: v.Major, Minor: v.Minor, PatchLevel: v.PatchLevel,
+			Name: v.Name, Date: v.Date, Desc: v.Desc,
+		}
+	}
+	for _, cc := range caps {
+		// Caps unknown to the kernel are left out.
+		if value, err := c.GetCap(cc.cap); err == nil {
+			ret.Caps[cc.name] = value
+		}
+	}
+	for _, cc := range clientCaps {
+		ret.ClientCaps[cc.name] = c.SetClientCap(cc.cap, 1) == nil
+	}
+
+	res, err := c.ModeGetResources()
+	if err != nil {
+		ret.addError("resources", err)
+		return
+	}
+	for _, id := range res.Connectors {
+		conn, err := readConnector(c, id)
+		if err != nil {
+			ret.addError(fmt.Sprintf("connector %d", id), err)
+			continue
+		}
+		ret.Connectors = append(ret.Connectors, *conn)
+	}
+	for _, id := range res.Encoders {
+		enc, err := c.ModeGetEncoder(id)
+		if err != nil {
+			ret.addError(fmt.Sprintf("encoder %d", id), err)
+			continue
+		}
+		ret.Encoders = append(ret.Encoders, encoder{
+			ID:             enc.ID,
+			Type:           drm.EncoderTypeName(enc.Type),
+			CrtcID:         enc.CrtcID,
+			PossibleCrtcs:  enc.PossibleCrtcs,
+			PossibleClones: enc.PossibleClones,
+		})
+	}
+	for _, id := range res.Crtcs {
+		crtc, err := readCrtc(c, id)
+		if err != nil {
+			ret.addError(fmt.Sprintf("CRTC %d", id), err)
+			continue
+		}
+		ret.Crtcs = append(ret.Crtcs, *crtc)
+	}
+
+	planes, err := c.ModeGetPlaneResources()
+	if err != nil {
+		ret.addError("planes", err)
+		return
+	}
+	for _, id := range planes {
+		p, err := readPlane(c, id)
+		if err != nil {
+			ret.addError(fmt.Sprintf("plane %d", id), err)
+			continue
+		}
+		ret.Planes = append(ret.Planes, *p)
+	}
+}
+
+func readConnector(c *drm.Card, id uint32) (*connector, error) {
+	conn, err := c.ModeGetConnector(id)
+	if err != nil {
+		return nil, err
+	}
+	ret := &connector{
+		ID:        conn.ID,
+		Name:      conn.Name(),
+		Status:    connectionNames[conn.Connection],
+		MMWidth:   conn.MMWidth,
+		MMHeight:  conn.MMHeight,
+		EncoderID: conn.EncoderID,
+		Encoders:  conn.Encoders,
+	}
+	for i := range conn.Modes {
+		ret.Modes = append(ret.Modes, newMode(&conn.Modes[i]))
+	}
+	props, err := c.ObjectGetProperties(id, drm.ModeObjectConnector)
+	if err != nil {
+		return nil, err
+	}
+	ret.Properties = newProperties(props)
+	if prop, ok := props.Lookup("EDID"); ok && prop.BlobID() != 0 {
+		if ret.EDID, err = c.ModeGetPropBlob(prop.BlobID()); err != nil {
+			return nil, fmt.Errorf("EDID: %w", err)
+		}
+		// Undecodable EDIDs are still dumped raw.
+		ret.ParsedEDID, _ = edid.Parse(ret.EDID)
+	}
+	return ret, nil
+}
+
+func readCrtc(c *drm.Card, id uint32) (*crtc, error) {
+	cr, err := c.ModeGetCrtc(id)
+	if err != nil {
+		return nil, err
+	}
+	ret := &crtc{ID: cr.ID, FBID: cr.FBID, X: cr.X, Y: cr.Y, GammaSize: cr.GammaSize}
+	if cr.Mode != nil {
+		m := newMode(cr.Mode)
+		ret.Mode = &m
+	}
+	props, err := c.ObjectGetProperties(id, drm.ModeObjectCrtc)
+	if err != nil {
+		return nil, err
+	}
+	ret.Properties = newProperties(props)
+	return ret, nil
+}
+
+func readPlane(c *drm.Card, id uint32) (*plane, error) {
+	p, err := c.ModeGetPlane(id)
+	if err != nil {
+		return nil, err
+	}
+	ret := &plane{ID: p.ID, CrtcID: p.CrtcID, FBID: p.FBID, PossibleCrtcs: p.PossibleCrtcs}
+	formats, err := c.PlaneFormats(id)
+	if err != nil {
+		return nil, err
+	}
+	ret.Formats = make(map[string][]string, len(formats))
+	for format, modifiers := range formats {
+		names := make([]string, len(modifiers))
+		for i, m := range modifiers {
+			names[i] = fourcc.ModifierName(m)
+		}
+		ret.Formats[fourcc.Name(format)] = names
+	}
+	props, err := c.ObjectGetProperties(id, drm.ModeObjectPlane)
+	if err != nil {
+		return nil, err
+	}
+	ret.Properties = newProperties(props)
+	return ret, nil
+}
+
+func newMode(m *drm.ModeInfo) mode {
+	return mode{
+		Name:       m.ModeName(),
+		Clock:      m.Clock,
+		HDisplay:   m.HDisplay,
+		HSyncStart: m.HSyncStart,
+		HSyncEnd:   m.HSyncEnd,
+		HTotal:     m.HTotal,
+		VDisplay:   m.VDisplay,
+		VSyncStart: m.VSyncStart,
+		VSyncEnd:   m.VSyncEnd,
+		VTotal:     m.VTotal,
+		VRefresh:   m.VRefresh,
+		Flags:      m.Flags,
+		Type:       m.Type,
+	}
+}
+
+func newProperties(props drm.ObjectProperties) []property {
+	ret := make([]property, len(props))
+	for i, p := range props {
+		value, err := p.Decode()
+		if err != nil {
+			value = p.Value
+		}
+		ret[i] = property{
+			ID:        p.ID,
+			Name:      p.Name,
+			Type:      p.Type().String(),
+			Immutable: p.Immutable(),
+			Atomic:    p.Atomic(),
+			Value:     value,
+			Raw:       p.Value,
+		}
+	}
+	return ret
+}
+
+// addError records an error, with advice on how to fix it if there is any.
+func (c *card) addError(what string, err error) {
+	msg := fmt.Sprintf("%s: %s", what, err)
+	if advice := drm.Advice(err); advice != "" {
+		msg += " (" + advice + ")"
+	}
+	c.Errors = append(c.Errors, msg)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func dump([]string) ([]card, error) {
+	return nil, fmt.Errorf("DRM is only available on Linux")
+}
@@ -0,0 +1,227 @@
+// Command drminfo dumps the state of DRM cards, to be attached to bug reports
+// about virtual displays.
+//
+// Usage:
+//
+//	drminfo [-json] [card...]
+//
+// All cards listed in sysfs are dumped if none are given.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/inahga/vdisplay/internal/edid"
+)
+
+type (
+	card struct {
+		Path       string            `json:"path"`
+		Driver     string            `json:"driver,omitempty"`
+		RenderNode string            `json:"render_node,omitempty"`
+		Version    *version          `json:"version,omitempty"`
+		Caps       map[string]uint64 `json:"caps"`
+		// ClientCaps are whether each client capability could be set. They
+		// are set before reading the objects, so that all are listed.
+		ClientCaps map[string]bool `json:"client_caps"`
+		Connectors []connector     `json:"connectors"`
+		Encoders   []encoder       `json:"encoders"`
+		Crtcs      []crtc          `json:"crtcs"`
+		Planes     []plane         `json:"planes"`
+		// Errors are why parts of the state couldn't be read.
+		Errors []string `json:"errors,omitempty"`
+	}
+
+	version struct {
+		Major      int32  `json:"major"`
+		Minor      int32  `json:"minor"`
+		PatchLevel int32  `json:"patch_level"`
+		Name       string `json:"name"`
+		Date       string `json:"date"`
+		Desc       string `json:"desc"`
+	}
+
+	connector struct {
+		ID         uint32     `json:"id"`
+		Name       string     `json:"name"`
+		Status     string     `json:"status"`
+		MMWidth    uint32     `json:"mm_width"`
+		MMHeight   uint32     `json:"mm_height"`
+		EncoderID  uint32     `json:"encoder_id"`
+		Encoders   []uint32   `json:"encoders"`
+		Modes      []mode     `json:"modes"`
+		EDID       []byte     `json:"edid,omitempty"`
+		ParsedEDID *edid.EDID `json:"parsed_edid,omitempty"`
+		Properties []property `json:"properties"`
+	}
+
+	encoder struct {
+		ID             uint32 `json:"id"`
+		Type           string `json:"type"`
+		CrtcID         uint32 `json:"crtc_id"`
+		PossibleCrtcs  uint32 `json:"possible_crtcs"`
+		PossibleClones uint32 `json:"possible_clones"`
+	}
+
+	crtc struct {
+		ID         uint32     `json:"id"`
+		FBID       uint32     `json:"fb_id"`
+		X          uint32     `json:"x"`
+		Y          uint32     `json:"y"`
+		Mode       *mode      `json:"mode"`
+		GammaSize  uint32     `json:"gamma_size"`
+		Properties []property `json:"properties"`
+	}
+
+	plane struct {
+		ID            uint32 `json:"id"`
+		CrtcID        uint32 `json:"crtc_id"`
+		FBID          uint32 `json:"fb_id"`
+		PossibleCrtcs uint32 `json:"possible_crtcs"`
+		// Formats are the names of the modifiers of each format.
+		Formats    map[string][]string `json:"formats"`
+		Properties []property          `json:"properties"`
+	}
+
+	mode struct {
+		Name       string `json:"name"`
+		Clock      uint32 `json:"clock"`
+		HDisplay   uint16 `json:"hdisplay"`
+		HSyncStart uint16 `json:"hsync_start"`
+		HSyncEnd   uint16 `json:"hsync_end"`
+		HTotal     uint16 `json:"htotal"`
+		VDisplay   uint16 `json:"vdisplay"`
+		VSyncStart uint16 `json:"vsync_start"`
+		VSyncEnd   uint16 `json:"vsync_end"`
+		VTotal     uint16 `json:"vtotal"`
+		VRefresh   uint32 `json:"vrefresh"`
+		Flags      uint32 `json:"flags"`
+		Type       uint32 `json:"type"`
+	}
+
+	property struct {
+		ID        uint32 `json:"id"`
+		Name      string `json:"name"`
+		Type      string `json:"type"`
+		Immutable bool   `json:"immutable"`
+		Atomic    bool   `json:"atomic"`
+		// Value is decoded according to the type, Raw is as reported.
+		Value interface{} `json:"value"`
+		Raw   uint64      `json:"raw"`
+	}
+)
+
+func main() {
+	jsonOutput := flag.Bool("json", false, "print JSON instead of text")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-json] [card...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cards, err := dump(flag.Args())
+	if err != nil {
+		log.Fatalf("drminfo: %s", err)
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cards); err != nil {
+			log.Fatalf("drminfo: %s", err)
+		}
+		return
+	}
+	for i := range cards {
+		cards[i].print(os.Stdout)
+	}
+}
+
+func (c *card) print(w io.Writer) {
+	fmt.Fprintf(w, "%s", c.Path)
+	if c.Driver != "" {
+		fmt.Fprintf(w, " (%s)", c.Driver)
+	}
+	fmt.Fprintln(w)
+	if c.RenderNode != "" {
+		fmt.Fprintf(w, "  Render node: %s\n", c.RenderNode)
+	}
+	if v := c.Version; v != nil {
+		fmt.Fprintf(w, "  Version: %s %d.%d.%d (%s) %s\n", v.Name, v.Major, v.Minor, v.PatchLevel, v.Date, v.Desc)
+	}
+	caps := make([]string, 0, len(c.Caps))
+	for name, value := range c.Caps {
+		caps = append(caps, fmt.Sprintf("%s=%d", name, value))
+	}
+	sort.Strings(caps)
+	fmt.Fprintf(w, "  Capabilities: %s\n", strings.Join(caps, " "))
+	clientCaps := make([]string, 0, len(c.ClientCaps))
+	for name, ok := range c.ClientCaps {
+		clientCaps = append(clientCaps, fmt.Sprintf("%s=%t", name, ok))
+	}
+	sort.Strings(clientCaps)
+	fmt.Fprintf(w, "  Client capabilities: %s\n", strings.Join(clientCaps, " "))
+
+	for _, conn := range c.Connectors {
+		fmt.Fprintf(w, "  Connector %d %s: %s, %dx%d mm, encoder %d, encoders %v\n",
+			conn.ID, conn.Name, conn.Status, conn.MMWidth, conn.MMHeight, conn.EncoderID, conn.Encoders)
+		for _, m := range conn.Modes {
+			fmt.Fprintf(w, "    Mode: %s\n", m)
+		}
+		if e := conn.ParsedEDID; e != nil {
+			fmt.Fprintf(w, "    EDID: %d bytes, %s %04x %q, %dx%d cm, version %d.%d\n",
+				len(conn.EDID), e.Manufacturer, e.ProductCode, e.Name, e.WidthCM, e.HeightCM, e.Version, e.Revision)
+		} else if len(conn.EDID) > 0 {
+			fmt.Fprintf(w, "    EDID: %d bytes, undecodable\n", len(conn.EDID))
+		}
+		printProperties(w, conn.Properties)
+	}
+	for _, enc := range c.Encoders {
+		fmt.Fprintf(w, "  Encoder %d %s: CRTC %d, possible CRTCs %#x, possible clones %#x\n",
+			enc.ID, enc.Type, enc.CrtcID, enc.PossibleCrtcs, enc.PossibleClones)
+	}
+	for _, crtc := range c.Crtcs {
+		fmt.Fprintf(w, "  CRTC %d: FB %d at %d,%d, gamma size %d\n", crtc.ID, crtc.FBID, crtc.X, crtc.Y, crtc.GammaSize)
+		if crtc.Mode != nil {
+			fmt.Fprintf(w, "    Mode: %s\n", crtc.Mode)
+		}
+		printProperties(w, crtc.Properties)
+	}
+	for _, p := range c.Planes {
+		fmt.Fprintf(w, "  Plane %d: CRTC %d, FB %d, possible CRTCs %#x\n", p.ID, p.CrtcID, p.FBID, p.PossibleCrtcs)
+		formats := make([]string, 0, len(p.Formats))
+		for format, modifiers := range p.Formats {
+			formats = append(formats, fmt.Sprintf("%s(%s)", format, strings.Join(modifiers, ",")))
+		}
+		sort.Strings(formats)
+		fmt.Fprintf(w, "    Formats: %s\n", strings.Join(formats, " "))
+		printProperties(w, p.Properties)
+	}
+	for _, err := range c.Errors {
+		fmt.Fprintf(w, "  Error: %s\n", err)
+	}
+}
+
+func printProperties(w io.Writer, props []property) {
+	for _, p := range props {
+		var flags []string
+		if p.Immutable {
+			flags = append(flags, "immutable")
+		}
+		if p.Atomic {
+			flags = append(flags, "atomic")
+		}
+		fmt.Fprintf(w, "    Property %d %s (%s): %v\n", p.ID, p.Name, strings.Join(append([]string{p.Type}, flags...), ", "), p.Value)
+	}
+}
+
+func (m mode) String() string {
+	return fmt.Sprintf("%s@%d %d %d %d %d %d %d %d %d %d flags %#x type %#x", m.Name, m.VRefresh, m.Clock,
+		m.HDisplay, m.HSyncStart, m.HSyncEnd, m.HTotal, m.VDisplay, m.VSyncStart, m.VSyncEnd, m.VTotal, m.Flags, m.Type)
+}
@@ -3,7 +3,8 @@ module github.com/inahga/vdisplay
 go 1.18
 
 require (
-	github.com/gen2brain/shm v0.0.0-20210511105953-083dbc7d9d83 // indirect
-	github.com/godbus/dbus/v5 v5.1.0 // indirect
-	github.com/jezek/xgb v1.0.1 // indirect
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/jezek/xgb v1.0.1
 )
+
+require github.com/gen2brain/shm v0.0.0-20210511105953-083dbc7d9d83 // indirect
@@ -1,5 +1,12 @@
 package convert
 
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
 func BGRxToRGBA(pix []byte) {
 	if len(pix)%4 != 0 {
 		panic("invalid pixel buffer")
@@ -8,3 +15,48 @@ func BGRxToRGBA(pix []byte) {
 		pix[i], pix[i+2] = pix[i+2], pix[i]
 	}
 }
+
+// Supported reports whether ToBGRx can convert from format, a fourcc code.
+func Supported(format uint32) bool {
+	switch format {
+	case fourcc.XRGB8888, fourcc.ARGB8888, fourcc.XBGR8888, fourcc.ABGR8888,
+		fourcc.RGB565, fourcc.XRGB2101010, fourcc.ARGB2101010:
+		return true
+	}
+	return false
+}
+
+// ToBGRx converts width by height pixels of a packed RGB format into BGRx,
+// which captures pass on as RGBA. Strides are the lengths of rows in bytes.
+func ToBGRx(dst []byte, dstStride int, src []byte, srcStride int, width, height int, format uint32) error {
+	if !Supported(format) {
+		return fmt.Errorf("unsupported format %s", fourcc.Name(format))
+	}
+	info, _ := fourcc.Lookup(format)
+	if len(src) < (height-1)*srcStride+width*info.CPP[0] || len(dst) < (height-1)*dstStride+width*4 {
+		return fmt.Errorf("buffer too small for %dx%d %s", width, height, fourcc.Name(format))
+	}
+	for y := 0; y < height; y++ {
+		s, d := src[y*srcStride:], dst[y*dstStride:]
+		if format == fourcc.XRGB8888 || format == fourcc.ARGB8888 {
+			copy(d[:width*4], s)
+			continue
+		}
+		for x := 0; x < width; x++ {
+			p, q := s[x*info.CPP[0]:], d[x*4:x*4+4]
+			switch format {
+			case fourcc.XBGR8888, fourcc.ABGR8888:
+				q[0], q[1], q[2], q[3] = p[2], p[1], p[0], p[3]
+			case fourcc.RGB565:
+				v := uint16(p[0]) | uint16(p[1])<<8
+				r, g, b := byte(v>>11), byte(v>>5&0x3f), byte(v&0x1f)
+				q[0], q[1], q[2], q[3] = b<<3|b>>2, g<<2|g>>4, r<<3|r>>2, 0xff
+			case fourcc.XRGB2101010, fourcc.ARGB2101010:
+				v := binary.LittleEndian.Uint32(p)
+				a := byte(v >> 30)
+				q[0], q[1], q[2], q[3] = byte(v>>2), byte(v>>12), byte(v>>22), a<<6|a<<4|a<<2|a
+			}
+		}
+	}
+	return nil
+}
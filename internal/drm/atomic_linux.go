@@ -0,0 +1,229 @@
+package drm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+type (
+	// AtomicRequest collects property changes on any number of KMS objects, to
+	// be applied together by Commit. The card must have ClientCapAtomic set.
+	AtomicRequest struct {
+		card    *Card
+		objects []atomicObject
+		fences  []*Fence
+		// UserData is returned in the page flip events of the commit, if
+		// requested with AtomicPageFlipEvent.
+		UserData uint64
+	}
+
+	atomicObject struct {
+		id     uint32
+		props  []uint32
+		values []uint64
+	}
+
+	// Fence is a sync file the kernel signals once a commit has completed,
+	// such as the scanout of a CRTC or a writeback job.
+	Fence struct {
+		fd int32
+	}
+
+	cModeAtomic struct {
+		flags         uint32
+		countObjs     uint32
+		objsPtr       uint64
+		countPropsPtr uint64
+		propsPtr      uint64
+		propValuesPtr uint64
+		reserved      uint64
+		userData      uint64
+	}
+
+	cModeCreateBlob struct {
+		data   uint64
+		length uint32
+		blobID uint32
+	}
+
+	cModeDestroyBlob struct {
+		blobID uint32
+	}
+
+	cPollFD struct {
+		fd      int32
+		events  int16
+		revents int16
+	}
+)
+
+// Flags of an atomic commit.
+const (
+	AtomicPageFlipEvent  uint32 = 0x0001
+	AtomicPageFlipAsync  uint32 = 0x0002
+	AtomicTestOnly       uint32 = 0x0100
+	AtomicNonblock       uint32 = 0x0200
+	AtomicAllowModeset   uint32 = 0x0400
+	atomicFlagsSupported        = AtomicPageFlipEvent | AtomicPageFlipAsync | AtomicTestOnly | AtomicNonblock | AtomicAllowModeset
+
+	pollIn = 0x1
+)
+
+var (
+	ioctlModeAtomic          = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeAtomic{})), ioctlBase, 0xbc)
+	ioctlModeCreatePropBlob  = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCreateBlob{})), ioctlBase, 0xbd)
+	ioctlModeDestroyPropBlob = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeDestroyBlob{})), ioctlBase, 0xbe)
+)
+
+func (c *Card) NewAtomicRequest() *AtomicRequest {
+	return &AtomicRequest{card: c}
+}
+
+// Set sets a property of an object. Setting the same property twice keeps the
+// last value.
+func (r *AtomicRequest) Set(objID, propID uint32, value uint64) {
+	obj := r.object(objID)
+	for i, p := range obj.props {
+		if p == propID {
+			obj.values[i] = value
+			return
+		}
+	}
+	obj.props = append(obj.props, propID)
+	obj.values = append(obj.values, value)
+}
+
+// SetByName sets the property of an object with the given name, as listed by
+// ObjectGetProperties for the object.
+func (r *AtomicRequest) SetByName(objID uint32, props ObjectProperties, name string, value uint64) error {
+	prop, ok := props.Lookup(name)
+	if !ok {
+		return fmt.Errorf("object %d has no property %s", objID, name)
+	}
+	r.Set(objID, prop.ID, value)
+	return nil
+}
+
+// OutFence requests a fence through a fence pointer property: OUT_FENCE_PTR of
+// a CRTC, or WRITEBACK_OUT_FENCE_PTR of a writeback connector. The fence is
+// available once Commit returns, and is not produced by test-only commits.
+func (r *AtomicRequest) OutFence(objID, propID uint32) *Fence {
+	f := &Fence{fd: -1}
+	r.fences = append(r.fences, f)
+	r.Set(objID, propID, uint64(uintptr(unsafe.Pointer(&f.fd))))
+	return f
+}
+
+func (r *AtomicRequest) object(id uint32) *atomicObject {
+	for i := range r.objects {
+		if r.objects[i].id == id {
+			return &r.objects[i]
+		}
+	}
+	r.objects = append(r.objects, atomicObject{id: id})
+	return &r.objects[len(r.objects)-1]
+}
+
+// Commit applies the request. With AtomicTestOnly, the request is only checked.
+// Without AtomicNonblock, Commit returns once the previous commit on the
+// affected CRTCs is done and the new state is programmed.
+func (r *AtomicRequest) Commit(flags uint32) error {
+	if flags&^atomicFlagsSupported != 0 {
+		return fmt.Errorf("atomic: unsupported flags %#x", flags)
+	}
+	var (
+		objs       = make([]uint32, len(r.objects))
+		countProps = make([]uint32, len(r.objects))
+		props      []uint32
+		values     []uint64
+	)
+	for i, obj := range r.objects {
+		objs[i], countProps[i] = obj.id, uint32(len(obj.props))
+		props = append(props, obj.props...)
+		values = append(values, obj.values...)
+	}
+	req := cModeAtomic{
+		flags:         flags,
+		countObjs:     uint32(len(objs)),
+		objsPtr:       slicePtr(objs),
+		countPropsPtr: slicePtr(countProps),
+		propsPtr:      slicePtr(props),
+		propValuesPtr: slicePtr(values),
+		userData:      r.UserData,
+	}
+	if err := r.card.ioctl(ioctlModeAtomic, unsafe.Pointer(&req)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
+
+// File returns the sync file of the fence, which becomes readable once the
+// fence is signalled. The caller owns the file.
+func (f *Fence) File() (*os.File, error) {
+	if f.fd < 0 {
+		return nil, fmt.Errorf("atomic: no fence was produced")
+	}
+	ret := os.NewFile(uintptr(f.fd), "sync_file")
+	f.fd = -1
+	return ret, nil
+}
+
+// Wait blocks until the fence is signalled, or the timeout expires.
+func (f *Fence) Wait(timeout time.Duration) error {
+	if f.fd < 0 {
+		return fmt.Errorf("atomic: no fence was produced")
+	}
+	pfd := cPollFD{fd: f.fd, events: pollIn}
+	ts := syscall.NsecToTimespec(int64(timeout))
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1,
+			uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+		switch {
+		case errno == syscall.EINTR:
+			continue
+		case errno != 0:
+			return fmt.Errorf("ppoll: %w", errno)
+		case n == 0:
+			return fmt.Errorf("atomic: fence not signalled after %s", timeout)
+		}
+		return nil
+	}
+}
+
+// Close releases the fence if it was not taken with File.
+func (f *Fence) Close() error {
+	if f.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(int(f.fd))
+	f.fd = -1
+	return err
+}
+
+// ModeCreatePropBlob creates a blob holding data, e.g. a ModeInfo for the
+// MODE_ID property of a CRTC. The blob is destroyed with the file descriptor if
+// it is not destroyed before.
+func (c *Card) ModeCreatePropBlob(data []byte) (uint32, error) {
+	blob := cModeCreateBlob{data: slicePtr(data), length: uint32(len(data))}
+	if err := c.ioctl(ioctlModeCreatePropBlob, unsafe.Pointer(&blob)); err != nil {
+		return 0, fmt.Errorf("ioctl: %w", err)
+	}
+	return blob.blobID, nil
+}
+
+// ModeCreateModeBlob creates a blob holding mode, for the MODE_ID property of
+// a CRTC.
+func (c *Card) ModeCreateModeBlob(mode *ModeInfo) (uint32, error) {
+	return c.ModeCreatePropBlob(modeBytes(mode))
+}
+
+func (c *Card) ModeDestroyPropBlob(id uint32) error {
+	blob := cModeDestroyBlob{blobID: id}
+	if err := c.ioctl(ioctlModeDestroyPropBlob, unsafe.Pointer(&blob)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
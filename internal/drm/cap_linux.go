@@ -0,0 +1,90 @@
+package drm
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+type (
+	cGetCap struct {
+		capability uint64
+		value      uint64
+	}
+
+	cSetClientCap struct {
+		capability uint64
+		value      uint64
+	}
+)
+
+// Capabilities of the driver, queried with GetCap.
+const (
+	// CapDumbBuffer reports support for dumb buffers.
+	CapDumbBuffer uint64 = iota + 1
+	// CapVblankHighCrtc reports support for waiting on vblanks of CRTCs past
+	// the second.
+	CapVblankHighCrtc
+	CapDumbPreferredDepth
+	CapDumbPreferShadow
+	// CapPrime is a bitmask of CapPrimeImport and CapPrimeExport.
+	CapPrime
+	// CapTimestampMonotonic reports that event timestamps are on
+	// CLOCK_MONOTONIC rather than CLOCK_REALTIME.
+	CapTimestampMonotonic
+	CapAsyncPageFlip
+	// CapCursorWidth and CapCursorHeight are the size of cursor buffers that
+	// is sure to work.
+	CapCursorWidth
+	CapCursorHeight
+
+	CapAddFB2Modifiers uint64 = iota + 7
+	CapPageFlipTarget
+	// CapCrtcInVblankEvent reports that vblank events carry the CRTC ID.
+	CapCrtcInVblankEvent
+	CapSyncObj
+	CapSyncObjTimeline
+	CapAtomicAsyncPageFlip
+
+	CapPrimeImport uint64 = 0x1
+	CapPrimeExport uint64 = 0x2
+)
+
+// Client capabilities, which opt in to features the kernel hides from legacy
+// clients.
+const (
+	ClientCapStereo3D uint64 = iota + 1
+	// ClientCapUniversalPlanes exposes primary and cursor planes.
+	ClientCapUniversalPlanes
+	// ClientCapAtomic enables atomic modesetting. It implies universal planes.
+	ClientCapAtomic
+	ClientCapAspectRatio
+	// ClientCapWritebackConnectors exposes writeback connectors. It requires
+	// atomic modesetting.
+	ClientCapWritebackConnectors
+	ClientCapCursorPlaneHotspot
+)
+
+var (
+	ioctlGetCap       = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cGetCap{})), ioctlBase, 0x0c)
+	ioctlSetClientCap = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(cSetClientCap{})), ioctlBase, 0x0d)
+)
+
+// GetCap returns the value of a driver capability. Capabilities unknown to the
+// kernel fail with EINVAL.
+func (c *Card) GetCap(capability uint64) (uint64, error) {
+	gc := cGetCap{capability: capability}
+	if err := c.ioctl(ioctlGetCap, unsafe.Pointer(&gc)); err != nil {
+		return 0, fmt.Errorf("ioctl: %w", err)
+	}
+	return gc.value, nil
+}
+
+// SetClientCap enables or configures a client capability for this file
+// descriptor.
+func (c *Card) SetClientCap(capability, value uint64) error {
+	cc := cSetClientCap{capability: capability, value: value}
+	if err := c.ioctl(ioctlSetClientCap, unsafe.Pointer(&cc)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
@@ -9,7 +9,7 @@ import (
 
 type (
 	Card struct {
-		fd *os.File
+		t Transport
 	}
 
 	Version struct {
@@ -42,21 +42,26 @@ var (
 )
 
 func New(fd *os.File) *Card {
-	return &Card{fd: fd}
+	return &Card{t: &fileTransport{f: fd}}
 }
 
+// NewWithTransport returns a card whose requests are carried by t, e.g. a
+// FakeDevice.
+func NewWithTransport(t Transport) *Card {
+	return &Card{t: t}
+}
+
+// Open opens a card read-write, which is needed to map buffers.
 func Open(path string) (*Card, error) {
-	f, err := os.Open(path)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
 		return nil, err
 	}
-	return &Card{
-		fd: f,
-	}, nil
+	return New(f), nil
 }
 
 func (c *Card) Close() error {
-	return c.fd.Close()
+	return c.t.Close()
 }
 
 func cToGoString(b []byte) string {
@@ -65,7 +70,7 @@ func cToGoString(b []byte) string {
 
 func (c *Card) Version() (*Version, error) {
 	var ver cVersion
-	if err := ioctl(c.fd, ioctlVersion, uintptr(unsafe.Pointer(&ver))); err != nil {
+	if err := c.ioctl(ioctlVersion, unsafe.Pointer(&ver)); err != nil {
 		return nil, fmt.Errorf("ioctl: %w", err)
 	}
 
@@ -83,7 +88,7 @@ func (c *Card) Version() (*Version, error) {
 		ver.desc = uint64(uintptr(unsafe.Pointer(&desc[0])))
 	}
 
-	if err := ioctl(c.fd, ioctlVersion, uintptr(unsafe.Pointer(&ver))); err != nil {
+	if err := c.ioctl(ioctlVersion, unsafe.Pointer(&ver)); err != nil {
 		return nil, fmt.Errorf("ioctl: %w", err)
 	}
 	return &Version{
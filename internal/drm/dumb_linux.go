@@ -0,0 +1,284 @@
+package drm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"unsafe"
+
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+type (
+	// DumbBuffer is a linear buffer allocated by the driver, which can be
+	// mapped and drawn into by the CPU.
+	DumbBuffer struct {
+		Handle uint32
+		Width  uint32
+		Height uint32
+		BPP    uint32
+		// Pitch is the length of a row in bytes.
+		Pitch uint32
+		Size  uint64
+	}
+
+	// Mapping is a dumb buffer mapped into memory. It is an image in the pixel
+	// format of the buffer, and writes are seen by the device.
+	Mapping struct {
+		Pix    []byte
+		Stride int
+		Rect   image.Rectangle
+		Format uint32
+
+		unmap func([]byte) error
+	}
+
+	// FB2 describes a framebuffer made of up to four planes of buffer objects.
+	FB2 struct {
+		Width       uint32
+		Height      uint32
+		PixelFormat uint32
+		// Flags is a combination of FBInterlaced and FBModifiers.
+		Flags   uint32
+		Handles [4]uint32
+		Pitches [4]uint32
+		Offsets [4]uint32
+		// Modifiers are used when FBModifiers is set.
+		Modifiers [4]uint64
+	}
+
+	cModeCreateDumb struct {
+		height uint32
+		width  uint32
+		bpp    uint32
+		flags  uint32
+		handle uint32
+		pitch  uint32
+		size   uint64
+	}
+
+	cModeMapDumb struct {
+		handle uint32
+		pad    uint32
+		offset uint64
+	}
+
+	cModeDestroyDumb struct {
+		handle uint32
+	}
+
+	cModeFBCmd2 struct {
+		fbID        uint32
+		width       uint32
+		height      uint32
+		pixelFormat uint32
+		flags       uint32
+		handles     [4]uint32
+		pitches     [4]uint32
+		offsets     [4]uint32
+		modifier    [4]uint64
+	}
+)
+
+const (
+	FBInterlaced uint32 = 1 << 0
+	FBModifiers  uint32 = 1 << 1
+)
+
+var (
+	ioctlModeRmFB        = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(uint32(0))), ioctlBase, 0xaf)
+	ioctlModeCreateDumb  = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCreateDumb{})), ioctlBase, 0xb2)
+	ioctlModeMapDumb     = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeMapDumb{})), ioctlBase, 0xb3)
+	ioctlModeDestroyDumb = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeDestroyDumb{})), ioctlBase, 0xb4)
+	ioctlModeAddFB2      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeFBCmd2{})), ioctlBase, 0xb8)
+	ioctlModeGetFB2      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeFBCmd2{})), ioctlBase, 0xce)
+)
+
+func (c *Card) CreateDumb(width, height, bpp uint32) (*DumbBuffer, error) {
+	dumb := cModeCreateDumb{width: width, height: height, bpp: bpp}
+	if err := c.ioctl(ioctlModeCreateDumb, unsafe.Pointer(&dumb)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	return &DumbBuffer{
+		Handle: dumb.handle,
+		Width:  width,
+		Height: height,
+		BPP:    bpp,
+		Pitch:  dumb.pitch,
+		Size:   dumb.size,
+	}, nil
+}
+
+// MapDumb maps a dumb buffer for access in the given pixel format, which must
+// match the bits per pixel of the buffer.
+func (c *Card) MapDumb(b *DumbBuffer, format uint32) (*Mapping, error) {
+	bpp, err := formatBPP(format)
+	if err != nil {
+		return nil, err
+	}
+	if bpp != b.BPP {
+		return nil, fmt.Errorf("format %s does not match a %d bpp buffer", fourcc.Name(format), b.BPP)
+	}
+	m := cModeMapDumb{handle: b.Handle}
+	if err := c.ioctl(ioctlModeMapDumb, unsafe.Pointer(&m)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	pix, err := c.t.Mmap(int64(m.offset), int(b.Size))
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	return &Mapping{
+		Pix:    pix,
+		Stride: int(b.Pitch),
+		Rect:   image.Rect(0, 0, int(b.Width), int(b.Height)),
+		Format: format,
+		unmap:  c.t.Munmap,
+	}, nil
+}
+
+// DestroyDumb frees a dumb buffer. Its memory stays valid while it is mapped
+// or used by a framebuffer.
+func (c *Card) DestroyDumb(handle uint32) error {
+	dumb := cModeDestroyDumb{handle: handle}
+	if err := c.ioctl(ioctlModeDestroyDumb, unsafe.Pointer(&dumb)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
+
+// AddFB2 creates a framebuffer from buffer objects, returning its ID.
+func (c *Card) AddFB2(fb *FB2) (uint32, error) {
+	cmd := cModeFBCmd2{
+		width:       fb.Width,
+		height:      fb.Height,
+		pixelFormat: fb.PixelFormat,
+		flags:       fb.Flags,
+		handles:     fb.Handles,
+		pitches:     fb.Pitches,
+		offsets:     fb.Offsets,
+	}
+	if fb.Flags&FBModifiers != 0 {
+		cmd.modifier = fb.Modifiers
+	}
+	if err := c.ioctl(ioctlModeAddFB2, unsafe.Pointer(&cmd)); err != nil {
+		return 0, fmt.Errorf("ioctl: %w", err)
+	}
+	return cmd.fbID, nil
+}
+
+// GetFB2 returns a framebuffer, with new handles to its buffer objects that
+// must be released with GemClose. The kernel only hands out the handles to the
+// DRM master and CAP_SYS_ADMIN; for other clients they are zero.
+func (c *Card) GetFB2(id uint32) (*FB2, error) {
+	cmd := cModeFBCmd2{fbID: id}
+	if err := c.ioctl(ioctlModeGetFB2, unsafe.Pointer(&cmd)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	return &FB2{
+		Width:       cmd.width,
+		Height:      cmd.height,
+		PixelFormat: cmd.pixelFormat,
+		Flags:       cmd.flags,
+		Handles:     cmd.handles,
+		Pitches:     cmd.pitches,
+		Offsets:     cmd.offsets,
+		Modifiers:   cmd.modifier,
+	}, nil
+}
+
+// AddDumbFB creates a single plane framebuffer for a dumb buffer.
+func (c *Card) AddDumbFB(b *DumbBuffer, format uint32) (uint32, error) {
+	return c.AddFB2(&FB2{
+		Width:       b.Width,
+		Height:      b.Height,
+		PixelFormat: format,
+		Handles:     [4]uint32{b.Handle},
+		Pitches:     [4]uint32{b.Pitch},
+	})
+}
+
+// RmFB removes a framebuffer. If it is being scanned out, the CRTCs using it are
+// disabled.
+func (c *Card) RmFB(id uint32) error {
+	if err := c.ioctl(ioctlModeRmFB, unsafe.Pointer(&id)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
+
+// Unmap releases the mapping. The image must not be used afterwards.
+func (m *Mapping) Unmap() error {
+	if m.Pix == nil {
+		return nil
+	}
+	err := m.unmap(m.Pix)
+	m.Pix = nil
+	return err
+}
+
+func (m *Mapping) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (m *Mapping) Bounds() image.Rectangle {
+	return m.Rect
+}
+
+func (m *Mapping) pixOffset(x, y int) int {
+	bpp, _ := formatBPP(m.Format)
+	return (y-m.Rect.Min.Y)*m.Stride + (x-m.Rect.Min.X)*int(bpp/8)
+}
+
+func (m *Mapping) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.RGBA{}
+	}
+	p := m.Pix[m.pixOffset(x, y):]
+	switch m.Format {
+	case fourcc.XRGB8888:
+		return color.RGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+	case fourcc.ARGB8888:
+		return color.RGBA{R: p[2], G: p[1], B: p[0], A: p[3]}
+	case fourcc.XBGR8888:
+		return color.RGBA{R: p[0], G: p[1], B: p[2], A: 0xff}
+	case fourcc.ABGR8888:
+		return color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+	case fourcc.RGB565:
+		v := uint16(p[0]) | uint16(p[1])<<8
+		r, g, b := byte(v>>11), byte(v>>5&0x3f), byte(v&0x1f)
+		return color.RGBA{R: r<<3 | r>>2, G: g<<2 | g>>4, B: b<<3 | b>>2, A: 0xff}
+	case fourcc.XRGB2101010, fourcc.ARGB2101010:
+		v := binary.LittleEndian.Uint32(p)
+		ret := color.RGBA{R: byte(v >> 22), G: byte(v >> 12), B: byte(v >> 2), A: 0xff}
+		if m.Format == fourcc.ARGB2101010 {
+			a := byte(v >> 30)
+			ret.A = a<<6 | a<<4 | a<<2 | a
+		}
+		return ret
+	}
+	return color.RGBA{}
+}
+
+// Set implements draw.Image. Alpha is dropped for formats without it.
+func (m *Mapping) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return
+	}
+	p := m.Pix[m.pixOffset(x, y):]
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	switch m.Format {
+	case fourcc.XRGB8888, fourcc.ARGB8888:
+		p[0], p[1], p[2], p[3] = rgba.B, rgba.G, rgba.R, rgba.A
+	case fourcc.XBGR8888, fourcc.ABGR8888:
+		p[0], p[1], p[2], p[3] = rgba.R, rgba.G, rgba.B, rgba.A
+	case fourcc.RGB565:
+		v := uint16(rgba.R>>3)<<11 | uint16(rgba.G>>2)<<5 | uint16(rgba.B>>3)
+		p[0], p[1] = byte(v), byte(v>>8)
+	case fourcc.XRGB2101010, fourcc.ARGB2101010:
+		// 8 bit components are widened by repeating their top bits.
+		widen := func(c uint8) uint32 { return uint32(c)<<2 | uint32(c)>>6 }
+		v := uint32(rgba.A>>6)<<30 | widen(rgba.R)<<20 | widen(rgba.G)<<10 | widen(rgba.B)
+		binary.LittleEndian.PutUint32(p, v)
+	}
+}
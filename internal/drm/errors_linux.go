@@ -0,0 +1,139 @@
+package drm
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// IoctlError is a failed ioctl. It matches one of ErrNotMaster, ErrUnsupported,
+// ErrDeviceGone or ErrPermission with errors.Is, according to the errno and
+// the ioctl, and unwraps to the errno.
+type IoctlError struct {
+	// Ioctl is the name of the ioctl without the DRM_IOCTL_ prefix, e.g.
+	// MODE_ATOMIC.
+	Ioctl string
+	Errno syscall.Errno
+	kind  error
+}
+
+var (
+	ErrNotMaster   = errors.New("not DRM master")
+	ErrUnsupported = errors.New("not supported by the driver")
+	ErrDeviceGone  = errors.New("device gone")
+	ErrPermission  = errors.New("permission denied")
+)
+
+var (
+	ioctlNames = map[uint32]string{
+		ioctlVersion:               "VERSION",
+		ioctlGemClose:              "GEM_CLOSE",
+		ioctlGetCap:                "GET_CAP",
+		ioctlSetClientCap:          "SET_CLIENT_CAP",
+		ioctlPrimeHandleToFD:       "PRIME_HANDLE_TO_FD",
+		ioctlPrimeFDToHandle:       "PRIME_FD_TO_HANDLE",
+		ioctlWaitVblank:            "WAIT_VBLANK",
+		ioctlCrtcGetSequence:       "CRTC_GET_SEQUENCE",
+		ioctlCrtcQueueSequence:     "CRTC_QUEUE_SEQUENCE",
+		ioctlModeGetResources:      "MODE_GETRESOURCES",
+		ioctlModeGetCrtc:           "MODE_GETCRTC",
+		ioctlModeGetEncoder:        "MODE_GETENCODER",
+		ioctlModeGetConnector:      "MODE_GETCONNECTOR",
+		ioctlModeGetProperty:       "MODE_GETPROPERTY",
+		ioctlModeGetPropBlob:       "MODE_GETPROPBLOB",
+		ioctlModeRmFB:              "MODE_RMFB",
+		ioctlModeCreateDumb:        "MODE_CREATE_DUMB",
+		ioctlModeMapDumb:           "MODE_MAP_DUMB",
+		ioctlModeDestroyDumb:       "MODE_DESTROY_DUMB",
+		ioctlModeGetPlaneResources: "MODE_GETPLANERESOURCES",
+		ioctlModeGetPlane:          "MODE_GETPLANE",
+		ioctlModeAddFB2:            "MODE_ADDFB2",
+		ioctlModeGetFB2:            "MODE_GETFB2",
+		ioctlModeObjGetProperties:  "MODE_OBJ_GETPROPERTIES",
+		ioctlModeAtomic:            "MODE_ATOMIC",
+		ioctlModeCreatePropBlob:    "MODE_CREATEPROPBLOB",
+		ioctlModeDestroyPropBlob:   "MODE_DESTROYPROPBLOB",
+		ioctlModeCreateLease:       "MODE_CREATE_LEASE",
+		ioctlModeListLessees:       "MODE_LIST_LESSEES",
+		ioctlModeGetLease:          "MODE_GET_LEASE",
+		ioctlModeRevokeLease:       "MODE_REVOKE_LEASE",
+		ioctlDmaBufSync:            "DMA_BUF_IOCTL_SYNC",
+	}
+
+	// masterIoctls are only allowed for the DRM master, or a lessee for its
+	// leased objects. The kernel fails them with EACCES otherwise.
+	masterIoctls = map[uint32]bool{
+		ioctlModeAtomic:      true,
+		ioctlModeCreateLease: true,
+		ioctlModeListLessees: true,
+		ioctlModeGetLease:    true,
+		ioctlModeRevokeLease: true,
+	}
+
+	// featureIoctls fail with EINVAL when asked about a feature the kernel or
+	// driver lacks, rather than for bad arguments.
+	featureIoctls = map[uint32]bool{
+		ioctlGetCap:       true,
+		ioctlSetClientCap: true,
+	}
+)
+
+// ioctlError types an error returned by an ioctl. Errors other than errnos are
+// returned as is.
+func ioctlError(request uint32, err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	name, ok := ioctlNames[request]
+	if !ok {
+		name = fmt.Sprintf("%#x", request)
+	}
+	ret := &IoctlError{Ioctl: name, Errno: errno}
+	switch {
+	case errno == syscall.EACCES && masterIoctls[request]:
+		ret.kind = ErrNotMaster
+	case errno == syscall.EACCES || errno == syscall.EPERM:
+		ret.kind = ErrPermission
+	case errno == syscall.EOPNOTSUPP || errno == syscall.ENOTTY ||
+		(errno == syscall.EINVAL && featureIoctls[request]):
+		ret.kind = ErrUnsupported
+	case errno == syscall.ENODEV:
+		ret.kind = ErrDeviceGone
+	}
+	return ret
+}
+
+func (e *IoctlError) Error() string {
+	if e.kind != nil {
+		return fmt.Sprintf("%s: %s (%s)", e.Ioctl, e.kind, e.Errno)
+	}
+	return fmt.Sprintf("%s: %s", e.Ioctl, e.Errno)
+}
+
+func (e *IoctlError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+func (e *IoctlError) Unwrap() error {
+	return e.Errno
+}
+
+// Advice suggests how to fix an error returned by a card, for display to the
+// user. It is empty for errors without known remedies.
+func Advice(err error) string {
+	var ioctlErr *IoctlError
+	switch {
+	case errors.Is(err, ErrNotMaster):
+		return "run as DRM master, e.g. from a VT without a display server, or use a lease of the needed objects"
+	case errors.Is(err, ErrPermission), errors.Is(err, syscall.EACCES):
+		return "run as root, or as a user in the video group"
+	case errors.Is(err, ErrDeviceGone):
+		return "the card was removed; if it was a vkms card, load vkms again"
+	case errors.As(err, &ioctlErr) && ioctlErr.kind == ErrUnsupported && ioctlErr.Errno == syscall.EINVAL:
+		return "the kernel or driver lacks the capability; try a newer kernel, or vkms for a virtual card with writeback"
+	case errors.As(err, &ioctlErr) && ioctlErr.kind == ErrUnsupported:
+		return fmt.Sprintf("the driver does not support %s; load vkms for a virtual card with writeback", ioctlErr.Ioctl)
+	}
+	return ""
+}
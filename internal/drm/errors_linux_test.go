@@ -0,0 +1,36 @@
+package drm
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestIoctlError(t *testing.T) {
+	for _, tt := range []struct {
+		request uint32
+		errno   syscall.Errno
+		want    error
+	}{
+		{ioctlModeAtomic, syscall.EACCES, ErrNotMaster},
+		{ioctlModeCreateLease, syscall.EACCES, ErrNotMaster},
+		{ioctlModeRevokeLease, syscall.EACCES, ErrNotMaster},
+		// All lease ioctls are master-only, queries included.
+		{ioctlModeListLessees, syscall.EACCES, ErrNotMaster},
+		{ioctlModeGetLease, syscall.EACCES, ErrNotMaster},
+		{ioctlModeGetResources, syscall.EPERM, ErrPermission},
+		{ioctlGetCap, syscall.EINVAL, ErrUnsupported},
+		{ioctlModeGetResources, syscall.ENOTTY, ErrUnsupported},
+		{ioctlModeAtomic, syscall.ENODEV, ErrDeviceGone},
+	} {
+		err := ioctlError(tt.request, tt.errno)
+		if !errors.Is(err, tt.want) || !errors.Is(err, tt.errno) {
+			t.Errorf("%s %s: got %v, want %v", ioctlNames[tt.request], tt.errno, err, tt.want)
+		}
+	}
+
+	// EINVAL is only a missing feature for capability queries.
+	if err := ioctlError(ioctlModeAtomic, syscall.EINVAL); errors.Is(err, ErrUnsupported) {
+		t.Errorf("MODE_ATOMIC EINVAL: got %v", err)
+	}
+}
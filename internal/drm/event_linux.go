@@ -0,0 +1,197 @@
+package drm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+type (
+	// Event is an event read from the card, either a *VblankEvent or a
+	// *CrtcSequenceEvent.
+	Event interface {
+		event()
+	}
+
+	// VblankEvent is sent for a vblank requested with WaitVblank and
+	// WaitVblankEvent, or on completion of a commit with AtomicPageFlipEvent.
+	VblankEvent struct {
+		// FlipComplete is set for page flip completions.
+		FlipComplete bool
+		UserData     uint64
+		// Timestamp is the time of the vblank on CLOCK_MONOTONIC.
+		Timestamp time.Duration
+		Sequence  uint32
+		// CrtcID is only set by kernels that support it, zero otherwise.
+		CrtcID uint32
+	}
+
+	// CrtcSequenceEvent is sent for a sequence queued with CrtcQueueSequence.
+	CrtcSequenceEvent struct {
+		UserData  uint64
+		Timestamp time.Duration
+		Sequence  uint64
+	}
+
+	// VblankReply is the vblank that a blocking WaitVblank returned on.
+	VblankReply struct {
+		Sequence  uint32
+		Timestamp time.Duration
+	}
+
+	// cWaitVblank is union drm_wait_vblank. The request has the signal in
+	// place of the reply seconds.
+	cWaitVblank struct {
+		typ      uint32
+		sequence uint32
+		tvalSec  int64
+		tvalUsec int64
+	}
+
+	cCrtcGetSequence struct {
+		crtcID     uint32
+		active     uint32
+		sequence   uint64
+		sequenceNS int64
+	}
+
+	cCrtcQueueSequence struct {
+		crtcID   uint32
+		flags    uint32
+		sequence uint64
+		userData uint64
+	}
+)
+
+const (
+	eventVblank       = 0x01
+	eventFlipComplete = 0x02
+	eventCrtcSequence = 0x03
+
+	eventHeaderSize = 8
+	eventBufferSize = 4096
+)
+
+// Types of a WaitVblank request. WaitVblankAbsolute and WaitVblankRelative are
+// combined with the other flags.
+const (
+	WaitVblankAbsolute   uint32 = 0x00000000
+	WaitVblankRelative   uint32 = 0x00000001
+	WaitVblankEvent      uint32 = 0x04000000
+	WaitVblankNextOnMiss uint32 = 0x10000000
+
+	vblankSecondary      uint32 = 0x20000000
+	vblankHighCrtcMask   uint32 = 0x0000003e
+	vblankHighCrtcShift         = 1
+	vblankTypesSupported        = WaitVblankRelative | WaitVblankEvent | WaitVblankNextOnMiss
+)
+
+// Flags of CrtcQueueSequence.
+const (
+	CrtcSequenceRelative   uint32 = 0x00000001
+	CrtcSequenceNextOnMiss uint32 = 0x00000002
+)
+
+var (
+	ioctlWaitVblank        = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cWaitVblank{})), ioctlBase, 0x3a)
+	ioctlCrtcGetSequence   = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cCrtcGetSequence{})), ioctlBase, 0x3b)
+	ioctlCrtcQueueSequence = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cCrtcQueueSequence{})), ioctlBase, 0x3c)
+)
+
+func (*VblankEvent) event()       {}
+func (*CrtcSequenceEvent) event() {}
+
+// ReadEvents blocks until events are available on the card, and decodes them.
+// Events of unknown types are skipped.
+func (c *Card) ReadEvents() ([]Event, error) {
+	buf := make([]byte, eventBufferSize)
+	n, err := c.t.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return parseEvents(buf[:n])
+}
+
+func parseEvents(b []byte) ([]Event, error) {
+	var ret []Event
+	for len(b) >= eventHeaderSize {
+		typ, length := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+		if length < eventHeaderSize || int(length) > len(b) {
+			return ret, fmt.Errorf("event of type %d has bad length %d", typ, length)
+		}
+		e := b[eventHeaderSize:length]
+		switch typ {
+		case eventVblank, eventFlipComplete:
+			if len(e) < 24 {
+				return ret, fmt.Errorf("short vblank event")
+			}
+			sec, usec := binary.LittleEndian.Uint32(e[8:]), binary.LittleEndian.Uint32(e[12:])
+			ret = append(ret, &VblankEvent{
+				FlipComplete: typ == eventFlipComplete,
+				UserData:     binary.LittleEndian.Uint64(e),
+				Timestamp:    time.Duration(sec)*time.Second + time.Duration(usec)*time.Microsecond,
+				Sequence:     binary.LittleEndian.Uint32(e[16:]),
+				CrtcID:       binary.LittleEndian.Uint32(e[20:]),
+			})
+		case eventCrtcSequence:
+			if len(e) < 24 {
+				return ret, fmt.Errorf("short CRTC sequence event")
+			}
+			ret = append(ret, &CrtcSequenceEvent{
+				UserData:  binary.LittleEndian.Uint64(e),
+				Timestamp: time.Duration(int64(binary.LittleEndian.Uint64(e[8:]))),
+				Sequence:  binary.LittleEndian.Uint64(e[16:]),
+			})
+		}
+		b = b[length:]
+	}
+	return ret, nil
+}
+
+// WaitVblank waits for a vblank of the CRTC at crtcIndex in ModeResources.
+// typ is WaitVblankAbsolute or WaitVblankRelative, with optional flags. With
+// WaitVblankEvent, WaitVblank returns immediately and a VblankEvent carrying
+// userData is sent instead, so the reply is nil.
+func (c *Card) WaitVblank(crtcIndex int, typ, sequence uint32, userData uint64) (*VblankReply, error) {
+	if typ&^vblankTypesSupported != 0 {
+		return nil, fmt.Errorf("wait vblank: unsupported type %#x", typ)
+	}
+	switch {
+	case crtcIndex == 1:
+		typ |= vblankSecondary
+	case crtcIndex > 1:
+		typ |= uint32(crtcIndex<<vblankHighCrtcShift) & vblankHighCrtcMask
+	}
+	vbl := cWaitVblank{typ: typ, sequence: sequence, tvalSec: int64(userData)}
+	if err := c.ioctl(ioctlWaitVblank, unsafe.Pointer(&vbl)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	if typ&WaitVblankEvent != 0 {
+		return nil, nil
+	}
+	return &VblankReply{
+		Sequence:  vbl.sequence,
+		Timestamp: time.Duration(vbl.tvalSec)*time.Second + time.Duration(vbl.tvalUsec)*time.Microsecond,
+	}, nil
+}
+
+// CrtcGetSequence returns the current vblank sequence of a CRTC and the time
+// it started, and whether the CRTC is active.
+func (c *Card) CrtcGetSequence(crtcID uint32) (sequence uint64, timestamp time.Duration, active bool, err error) {
+	seq := cCrtcGetSequence{crtcID: crtcID}
+	if err := c.ioctl(ioctlCrtcGetSequence, unsafe.Pointer(&seq)); err != nil {
+		return 0, 0, false, fmt.Errorf("ioctl: %w", err)
+	}
+	return seq.sequence, time.Duration(seq.sequenceNS), seq.active != 0, nil
+}
+
+// CrtcQueueSequence requests a CrtcSequenceEvent when a CRTC reaches sequence,
+// returning the sequence actually queued.
+func (c *Card) CrtcQueueSequence(crtcID, flags uint32, sequence, userData uint64) (uint64, error) {
+	seq := cCrtcQueueSequence{crtcID: crtcID, flags: flags, sequence: sequence, userData: userData}
+	if err := c.ioctl(ioctlCrtcQueueSequence, unsafe.Pointer(&seq)); err != nil {
+		return 0, fmt.Errorf("ioctl: %w", err)
+	}
+	return seq.sequence, nil
+}
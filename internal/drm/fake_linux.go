@@ -0,0 +1,893 @@
+package drm
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+type (
+	// FakeDevice is an in-memory DRM device, to test code using a Card without
+	// hardware or vkms. Use it through NewWithTransport.
+	//
+	// It implements the version, mode object, property and blob, capability
+	// and client capability, dumb buffer and framebuffer, atomic and vblank requests.
+	// GetFB2 returns handles sharing the memory of the framebuffer, as for the
+	// DRM master.
+	// Atomic commits apply their property values and are recorded. Commits
+	// setting WRITEBACK_FB_ID copy the framebuffer of the first plane on the
+	// CRTC into the writeback framebuffer, and out fences are signalled
+	// immediately. Other requests fail with ENOTTY.
+	FakeDevice struct {
+		lock    sync.Mutex
+		cond    *sync.Cond
+		closed  bool
+		version Version
+		nextID  uint32
+		// caps are the driver capabilities, clientCaps those set by the
+		// client.
+		caps       map[uint64]uint64
+		clientCaps map[uint64]uint64
+
+		crtcs      []*ModeCrtc
+		encoders   []*ModeEncoder
+		connectors []*ModeConnector
+		planes     []*ModePlane
+		objTypes   map[uint32]uint32
+		objProps   map[uint32][]fakePropValue
+		props      map[uint32]*ModeProperty
+		blobs      map[uint32][]byte
+		dumbs      map[uint32][]byte
+		fbs        map[uint32]FB2
+		vblanks    []uint32
+		events     []byte
+		commits    []FakeCommit
+	}
+
+	// FakeCommit is an atomic commit received by a FakeDevice.
+	FakeCommit struct {
+		Flags uint32
+		// Values are the property values set on each object ID, by property
+		// name.
+		Values map[uint32]map[string]uint64
+	}
+
+	fakePropValue struct {
+		id    uint32
+		value uint64
+	}
+)
+
+const (
+	// fakeRefresh is the refresh rate of CRTCs without a mode.
+	fakeRefresh = 60
+
+	fakeMapOffsetShift = 12
+)
+
+// NewFakeDevice returns a device without any objects, whose driver has the
+// given name. It supports dumb buffers, atomic modesetting and monotonic
+// timestamps.
+func NewFakeDevice(driver string) *FakeDevice {
+	d := &FakeDevice{
+		version: Version{Major: 1, Name: driver, Date: "0", Desc: "fake " + driver},
+		caps: map[uint64]uint64{
+			CapDumbBuffer:         1,
+			CapVblankHighCrtc:     1,
+			CapTimestampMonotonic: 1,
+			CapCursorWidth:        64,
+			CapCursorHeight:       64,
+			CapCrtcInVblankEvent:  1,
+		},
+		clientCaps: make(map[uint64]uint64),
+		objTypes:   make(map[uint32]uint32),
+		objProps:   make(map[uint32][]fakePropValue),
+		props:      make(map[uint32]*ModeProperty),
+		blobs:      make(map[uint32][]byte),
+		dumbs:      make(map[uint32][]byte),
+		fbs:        make(map[uint32]FB2),
+	}
+	d.cond = sync.NewCond(&d.lock)
+	return d
+}
+
+// NewFakeVKMS returns a device laid out like a vkms card with writeback
+// enabled: a primary plane on a CRTC active with mode, driving a virtual
+// connector, and a writeback connector for the CRTC.
+func NewFakeVKMS(mode ModeInfo) *FakeDevice {
+	d := NewFakeDevice("vkms")
+	d.version.Desc = "Virtual Kernel Mode Setting"
+	d.caps[CapPrime] = CapPrimeImport | CapPrimeExport
+
+	crtc := d.AddCrtc(&mode)
+	d.AddProperty(crtc, "ACTIVE", PropAtomic|uint32(PropRange), 1)
+	d.AddProperty(crtc, "MODE_ID", PropAtomic|uint32(PropBlob), uint64(d.AddBlob(modeBytes(&mode))))
+	d.AddProperty(crtc, "OUT_FENCE_PTR", PropAtomic|uint32(PropSignedRange), 0)
+
+	planeFormats := []uint32{fourcc.XRGB8888, fourcc.ARGB8888}
+	plane := d.AddPlane(1, planeFormats)
+	d.AddProperty(plane, "type", PropImmutable|uint32(PropEnum), 1).Enums = []PropertyEnum{
+		{Value: 0, Name: "Overlay"}, {Value: 1, Name: "Primary"}, {Value: 2, Name: "Cursor"},
+	}
+	d.AddProperty(plane, "IN_FORMATS", PropImmutable|uint32(PropBlob),
+		uint64(d.AddBlob(fakeFormatBlob(planeFormats, fourcc.ModLinear))))
+	d.AddProperty(plane, "CRTC_ID", PropAtomic|uint32(PropObject), uint64(crtc))
+	d.AddProperty(plane, "FB_ID", PropAtomic|uint32(PropObject), 0)
+	// Source coordinates are 16.16 fixed point.
+	w, h := uint64(mode.HDisplay), uint64(mode.VDisplay)
+	for _, p := range []struct {
+		name  string
+		value uint64
+	}{
+		{"SRC_X", 0}, {"SRC_Y", 0}, {"SRC_W", w << 16}, {"SRC_H", h << 16},
+		{"CRTC_X", 0}, {"CRTC_Y", 0}, {"CRTC_W", w}, {"CRTC_H", h},
+	} {
+		d.AddProperty(plane, p.name, PropAtomic|uint32(PropRange), p.value)
+	}
+
+	encoder := d.AddEncoder(ModeEncoderVirtual, 1)
+	virtual := d.AddConnector(ModeConnectorVirtual, ModeConnected, []uint32{encoder}, []ModeInfo{mode})
+	d.AddProperty(virtual, "CRTC_ID", PropAtomic|uint32(PropObject), uint64(crtc))
+	d.AddProperty(virtual, "EDID", PropImmutable|uint32(PropBlob), 0)
+
+	formats := make([]byte, 8)
+	binary.LittleEndian.PutUint32(formats, fourcc.XRGB8888)
+	binary.LittleEndian.PutUint32(formats[4:], fourcc.ARGB8888)
+	wbEncoder := d.AddEncoder(ModeEncoderVirtual, 1)
+	writeback := d.AddConnector(ModeConnectorWriteback, ModeUnknownConnection, []uint32{wbEncoder}, nil)
+	d.AddProperty(writeback, "CRTC_ID", PropAtomic|uint32(PropObject), 0)
+	d.AddProperty(writeback, "WRITEBACK_FB_ID", PropAtomic|uint32(PropObject), 0)
+	d.AddProperty(writeback, "WRITEBACK_OUT_FENCE_PTR", PropAtomic|uint32(PropSignedRange), 0)
+	d.AddProperty(writeback, "WRITEBACK_PIXEL_FORMATS", PropImmutable|uint32(PropBlob), uint64(d.AddBlob(formats)))
+	return d
+}
+
+func (d *FakeDevice) newID(objType uint32) uint32 {
+	d.nextID++
+	d.objTypes[d.nextID] = objType
+	return d.nextID
+}
+
+// AddCrtc adds a CRTC, active if mode is not nil, and returns its ID.
+func (d *FakeDevice) AddCrtc(mode *ModeInfo) uint32 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	crtc := &ModeCrtc{ID: d.newID(ModeObjectCrtc), Mode: mode}
+	d.crtcs = append(d.crtcs, crtc)
+	d.vblanks = append(d.vblanks, 0)
+	return crtc.ID
+}
+
+// AddEncoder adds an encoder for a bitmask of CRTC indices, and returns its ID.
+func (d *FakeDevice) AddEncoder(typ, possibleCrtcs uint32) uint32 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	encoder := &ModeEncoder{ID: d.newID(ModeObjectEncoder), Type: typ, PossibleCrtcs: possibleCrtcs}
+	d.encoders = append(d.encoders, encoder)
+	return encoder.ID
+}
+
+// AddConnector adds a connector and returns its ID. Its type ID is one more
+// than the number of connectors of the same type.
+func (d *FakeDevice) AddConnector(typ, connection uint32, encoders []uint32, modes []ModeInfo) uint32 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	connector := &ModeConnector{
+		ID:         d.newID(ModeObjectConnector),
+		Type:       typ,
+		TypeID:     1,
+		Connection: connection,
+		Modes:      modes,
+		Encoders:   encoders,
+	}
+	for _, c := range d.connectors {
+		if c.Type == typ {
+			connector.TypeID++
+		}
+	}
+	d.connectors = append(d.connectors, connector)
+	return connector.ID
+}
+
+// AddPlane adds a plane for a bitmask of CRTC indices, and returns its ID.
+func (d *FakeDevice) AddPlane(possibleCrtcs uint32, formats []uint32) uint32 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	plane := &ModePlane{ID: d.newID(ModeObjectPlane), PossibleCrtcs: possibleCrtcs, Formats: formats}
+	d.planes = append(d.planes, plane)
+	return plane.ID
+}
+
+// AddProperty attaches a property to an object with an initial value. The
+// property is created on first use of its name, and shared by the objects
+// that have it, as with the kernel. The property is returned so that range
+// values and enums can be filled in.
+func (d *FakeDevice) AddProperty(objID uint32, name string, flags uint32, value uint64) *ModeProperty {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var prop *ModeProperty
+	for _, p := range d.props {
+		if p.Name == name && p.Flags == flags {
+			prop = p
+			break
+		}
+	}
+	if prop == nil {
+		prop = &ModeProperty{ID: d.newID(ModeObjectProperty), Name: name, Flags: flags}
+		d.props[prop.ID] = prop
+	}
+	d.objProps[objID] = append(d.objProps[objID], fakePropValue{id: prop.ID, value: value})
+	d.setProperty(objID, name, value)
+	return prop
+}
+
+// AddBlob adds a blob, e.g. an EDID, and returns its ID.
+func (d *FakeDevice) AddBlob(data []byte) uint32 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	id := d.newID(ModeObjectBlob)
+	d.blobs[id] = append([]byte(nil), data...)
+	return id
+}
+
+// Property returns the current value of the named property of an object.
+func (d *FakeDevice) Property(objID uint32, name string) (uint64, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.propertyValue(objID, name)
+}
+
+// SetCap sets the value of a driver capability. Zero removes it, so that
+// querying it fails as with older kernels.
+func (d *FakeDevice) SetCap(capability, value uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if value == 0 {
+		delete(d.caps, capability)
+		return
+	}
+	d.caps[capability] = value
+}
+
+// ClientCap returns the value a client capability was set to.
+func (d *FakeDevice) ClientCap(capability uint64) uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.clientCaps[capability]
+}
+
+// Commits returns the atomic commits received so far, test-only commits
+// included.
+func (d *FakeDevice) Commits() []FakeCommit {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]FakeCommit(nil), d.commits...)
+}
+
+// Framebuffer returns the memory of the first buffer of a framebuffer.
+func (d *FakeDevice) Framebuffer(id uint32) ([]byte, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	fb, ok := d.fbs[id]
+	if !ok {
+		return nil, false
+	}
+	return d.dumbs[fb.Handles[0]], true
+}
+
+func (d *FakeDevice) Ioctl(request uint32, arg unsafe.Pointer) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return syscall.EBADF
+	}
+	switch request {
+	case ioctlVersion:
+		return d.getVersion((*cVersion)(arg))
+	case ioctlGetCap:
+		gc := (*cGetCap)(arg)
+		value, ok := d.caps[gc.capability]
+		if !ok {
+			return syscall.EINVAL
+		}
+		gc.value = value
+		return nil
+	case ioctlSetClientCap:
+		return d.setClientCap((*cSetClientCap)(arg))
+	case ioctlModeGetResources:
+		return d.getResources((*cModeCardRes)(arg))
+	case ioctlModeGetCrtc:
+		return d.getCrtc((*cModeCrtc)(arg))
+	case ioctlModeGetEncoder:
+		return d.getEncoder((*cModeGetEncoder)(arg))
+	case ioctlModeGetConnector:
+		return d.getConnector((*cModeGetConnector)(arg))
+	case ioctlModeGetPlaneResources:
+		res := (*cModeGetPlaneRes)(arg)
+		ids := make([]uint32, len(d.planes))
+		for i, p := range d.planes {
+			ids[i] = p.ID
+		}
+		fakeCopyOut(res.planeIDPtr, &res.countPlanes, ids)
+		return nil
+	case ioctlModeGetPlane:
+		return d.getPlane((*cModeGetPlane)(arg))
+	case ioctlModeGetProperty:
+		return d.getProperty((*cModeGetProperty)(arg))
+	case ioctlModeGetPropBlob:
+		blob := (*cModeGetBlob)(arg)
+		data, ok := d.blobs[blob.blobID]
+		if !ok {
+			return syscall.ENOENT
+		}
+		fakeCopyOut(blob.data, &blob.length, data)
+		return nil
+	case ioctlModeObjGetProperties:
+		return d.getObjectProperties((*cModeObjGetProperties)(arg))
+	case ioctlModeCreatePropBlob:
+		blob := (*cModeCreateBlob)(arg)
+		blob.blobID = d.newID(ModeObjectBlob)
+		d.blobs[blob.blobID] = append([]byte(nil), fakeSlice[byte](blob.data, blob.length)...)
+		return nil
+	case ioctlModeDestroyPropBlob:
+		blob := (*cModeDestroyBlob)(arg)
+		if _, ok := d.blobs[blob.blobID]; !ok {
+			return syscall.ENOENT
+		}
+		delete(d.blobs, blob.blobID)
+		return nil
+	case ioctlModeCreateDumb:
+		return d.createDumb((*cModeCreateDumb)(arg))
+	case ioctlModeMapDumb:
+		m := (*cModeMapDumb)(arg)
+		if _, ok := d.dumbs[m.handle]; !ok {
+			return syscall.ENOENT
+		}
+		m.offset = uint64(m.handle) << fakeMapOffsetShift
+		return nil
+	case ioctlModeDestroyDumb:
+		dumb := (*cModeDestroyDumb)(arg)
+		if _, ok := d.dumbs[dumb.handle]; !ok {
+			return syscall.ENOENT
+		}
+		delete(d.dumbs, dumb.handle)
+		return nil
+	case ioctlModeAddFB2:
+		return d.addFB((*cModeFBCmd2)(arg))
+	case ioctlModeGetFB2:
+		return d.getFB((*cModeFBCmd2)(arg))
+	case ioctlGemClose:
+		gem := (*cGemClose)(arg)
+		if _, ok := d.dumbs[gem.handle]; !ok {
+			return syscall.EINVAL
+		}
+		delete(d.dumbs, gem.handle)
+		return nil
+	case ioctlModeRmFB:
+		id := *(*uint32)(arg)
+		if _, ok := d.fbs[id]; !ok {
+			return syscall.ENOENT
+		}
+		delete(d.fbs, id)
+		return nil
+	case ioctlModeAtomic:
+		return d.atomic((*cModeAtomic)(arg))
+	case ioctlWaitVblank:
+		return d.waitVblank((*cWaitVblank)(arg))
+	}
+	return syscall.ENOTTY
+}
+
+// Read returns pending events, blocking until there are some.
+func (d *FakeDevice) Read(b []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for len(d.events) == 0 && !d.closed {
+		d.cond.Wait()
+	}
+	if d.closed {
+		return 0, os.ErrClosed
+	}
+	// Events are never split across reads.
+	n := 0
+	for n < len(d.events) {
+		length := int(binary.LittleEndian.Uint32(d.events[n+4:]))
+		if n+length > len(b) {
+			break
+		}
+		n += length
+	}
+	if n == 0 {
+		return 0, syscall.EINVAL
+	}
+	copy(b, d.events[:n])
+	d.events = d.events[n:]
+	return n, nil
+}
+
+// Mmap returns the memory of the dumb buffer at offset, which is shared with
+// the device.
+func (d *FakeDevice) Mmap(offset int64, length int) ([]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	buf, ok := d.dumbs[uint32(offset>>fakeMapOffsetShift)]
+	if !ok || length > len(buf) {
+		return nil, syscall.EINVAL
+	}
+	return buf[:length], nil
+}
+
+func (d *FakeDevice) Munmap([]byte) error {
+	return nil
+}
+
+func (d *FakeDevice) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return os.ErrClosed
+	}
+	d.closed = true
+	d.cond.Broadcast()
+	return nil
+}
+
+func (d *FakeDevice) getVersion(ver *cVersion) error {
+	ver.major, ver.minor, ver.patchlevel = d.version.Major, d.version.Minor, d.version.PatchLevel
+	for _, s := range []struct {
+		ptr *uint64
+		len *kernelSize
+		str string
+	}{
+		{&ver.name, &ver.namelen, d.version.Name},
+		{&ver.date, &ver.datelen, d.version.Date},
+		{&ver.desc, &ver.desclen, d.version.Desc},
+	} {
+		if *s.ptr != 0 {
+			n := *s.len
+			if n > kernelSize(len(s.str)) {
+				n = kernelSize(len(s.str))
+			}
+			copy(fakeSlice[byte](*s.ptr, uint32(n)), s.str)
+		}
+		*s.len = kernelSize(len(s.str))
+	}
+	return nil
+}
+
+func (d *FakeDevice) setClientCap(cc *cSetClientCap) error {
+	switch cc.capability {
+	case ClientCapUniversalPlanes, ClientCapAtomic, ClientCapAspectRatio:
+	case ClientCapWritebackConnectors:
+		// As with the kernel, writeback connectors need atomic modesetting.
+		if d.clientCaps[ClientCapAtomic] == 0 {
+			return syscall.EINVAL
+		}
+	default:
+		return syscall.EINVAL
+	}
+	if cc.value > 1 {
+		return syscall.EINVAL
+	}
+	d.clientCaps[cc.capability] = cc.value
+	return nil
+}
+
+func (d *FakeDevice) getResources(res *cModeCardRes) error {
+	var fbs, crtcs, connectors, encoders []uint32
+	for id := range d.fbs {
+		fbs = append(fbs, id)
+	}
+	for _, c := range d.crtcs {
+		crtcs = append(crtcs, c.ID)
+	}
+	for _, c := range d.connectors {
+		// Writeback connectors are hidden from clients that don't know them.
+		if c.Type == ModeConnectorWriteback && d.clientCaps[ClientCapWritebackConnectors] == 0 {
+			continue
+		}
+		connectors = append(connectors, c.ID)
+	}
+	for _, e := range d.encoders {
+		encoders = append(encoders, e.ID)
+	}
+	fakeCopyOut(res.fbIDPtr, &res.countFBs, fbs)
+	fakeCopyOut(res.crtcIDPtr, &res.countCrtcs, crtcs)
+	fakeCopyOut(res.connectorIDPtr, &res.countConnectors, connectors)
+	fakeCopyOut(res.encoderIDPtr, &res.countEncoders, encoders)
+	res.minWidth, res.maxWidth, res.minHeight, res.maxHeight = 1, 8192, 1, 8192
+	return nil
+}
+
+func (d *FakeDevice) getCrtc(crtc *cModeCrtc) error {
+	c, _ := d.crtc(crtc.crtcID)
+	if c == nil {
+		return syscall.ENOENT
+	}
+	crtc.fbID, crtc.x, crtc.y, crtc.gammaSize = c.FBID, c.X, c.Y, c.GammaSize
+	crtc.modeValid, crtc.mode = 0, ModeInfo{}
+	if c.Mode != nil {
+		crtc.modeValid, crtc.mode = 1, *c.Mode
+	}
+	return nil
+}
+
+func (d *FakeDevice) getEncoder(enc *cModeGetEncoder) error {
+	for _, e := range d.encoders {
+		if e.ID == enc.encoderID {
+			enc.encoderType, enc.crtcID = e.Type, e.CrtcID
+			enc.possibleCrtcs, enc.possibleClones = e.PossibleCrtcs, e.PossibleClones
+			return nil
+		}
+	}
+	return syscall.ENOENT
+}
+
+func (d *FakeDevice) getConnector(conn *cModeGetConnector) error {
+	for _, c := range d.connectors {
+		if c.ID != conn.connectorID {
+			continue
+		}
+		props, values := d.objectProperties(c.ID)
+		countProps := conn.countProps
+		fakeCopyOut(conn.modesPtr, &conn.countModes, c.Modes)
+		fakeCopyOut(conn.propsPtr, &conn.countProps, props)
+		fakeCopyOut(conn.propValuesPtr, &countProps, values)
+		fakeCopyOut(conn.encodersPtr, &conn.countEncoders, c.Encoders)
+		conn.encoderID, conn.connectorType, conn.connectorTypeID = c.EncoderID, c.Type, c.TypeID
+		conn.connection, conn.mmWidth, conn.mmHeight, conn.subpixel = c.Connection, c.MMWidth, c.MMHeight, c.SubPixel
+		return nil
+	}
+	return syscall.ENOENT
+}
+
+func (d *FakeDevice) getPlane(plane *cModeGetPlane) error {
+	for _, p := range d.planes {
+		if p.ID == plane.planeID {
+			plane.crtcID, plane.fbID = p.CrtcID, p.FBID
+			plane.possibleCrtcs, plane.gammaSize = p.PossibleCrtcs, p.GammaSize
+			fakeCopyOut(plane.formatTypePtr, &plane.countFormatTypes, p.Formats)
+			return nil
+		}
+	}
+	return syscall.ENOENT
+}
+
+func (d *FakeDevice) getProperty(prop *cModeGetProperty) error {
+	p, ok := d.props[prop.propID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	prop.flags = p.Flags
+	prop.name = [propNameLen]byte{}
+	copy(prop.name[:propNameLen-1], p.Name)
+	enums := make([]cModePropertyEnum, len(p.Enums))
+	for i, e := range p.Enums {
+		enums[i].value = e.Value
+		copy(enums[i].name[:propNameLen-1], e.Name)
+	}
+	fakeCopyOut(prop.valuesPtr, &prop.countValues, p.Values)
+	fakeCopyOut(prop.enumBlobPtr, &prop.countEnumBlobs, enums)
+	return nil
+}
+
+func (d *FakeDevice) getObjectProperties(obj *cModeObjGetProperties) error {
+	if typ, ok := d.objTypes[obj.objID]; !ok || (obj.objType != ModeObjectAny && obj.objType != typ) {
+		return syscall.ENOENT
+	}
+	props, values := d.objectProperties(obj.objID)
+	count := obj.countProps
+	fakeCopyOut(obj.propsPtr, &obj.countProps, props)
+	fakeCopyOut(obj.propValuesPtr, &count, values)
+	return nil
+}
+
+func (d *FakeDevice) objectProperties(id uint32) ([]uint32, []uint64) {
+	var (
+		props  []uint32
+		values []uint64
+	)
+	for _, v := range d.objProps[id] {
+		props = append(props, v.id)
+		values = append(values, v.value)
+	}
+	return props, values
+}
+
+func (d *FakeDevice) createDumb(dumb *cModeCreateDumb) error {
+	if dumb.width == 0 || dumb.height == 0 || dumb.bpp == 0 {
+		return syscall.EINVAL
+	}
+	dumb.pitch = (dumb.width*((dumb.bpp+7)/8) + 63) &^ 63
+	dumb.size = uint64(dumb.pitch) * uint64(dumb.height)
+	dumb.handle = d.newID(ModeObjectAny)
+	d.dumbs[dumb.handle] = make([]byte, dumb.size)
+	return nil
+}
+
+func (d *FakeDevice) addFB(cmd *cModeFBCmd2) error {
+	if _, ok := d.dumbs[cmd.handles[0]]; !ok {
+		return syscall.ENOENT
+	}
+	cmd.fbID = d.newID(ModeObjectFB)
+	d.fbs[cmd.fbID] = FB2{
+		Width:       cmd.width,
+		Height:      cmd.height,
+		PixelFormat: cmd.pixelFormat,
+		Flags:       cmd.flags,
+		Handles:     cmd.handles,
+		Pitches:     cmd.pitches,
+		Offsets:     cmd.offsets,
+		Modifiers:   cmd.modifier,
+	}
+	return nil
+}
+
+// getFB returns a framebuffer with a new handle to each of its buffers.
+func (d *FakeDevice) getFB(cmd *cModeFBCmd2) error {
+	fb, ok := d.fbs[cmd.fbID]
+	if !ok {
+		return syscall.ENOENT
+	}
+	cmd.width, cmd.height, cmd.pixelFormat, cmd.flags = fb.Width, fb.Height, fb.PixelFormat, fb.Flags
+	cmd.pitches, cmd.offsets, cmd.modifier = fb.Pitches, fb.Offsets, fb.Modifiers
+	for i, handle := range fb.Handles {
+		if buf, ok := d.dumbs[handle]; ok {
+			cmd.handles[i] = d.newID(ModeObjectAny)
+			d.dumbs[cmd.handles[i]] = buf
+		}
+	}
+	return nil
+}
+
+func (d *FakeDevice) atomic(req *cModeAtomic) error {
+	if req.flags&^atomicFlagsSupported != 0 || d.clientCaps[ClientCapAtomic] == 0 {
+		return syscall.EINVAL
+	}
+	objs := fakeSlice[uint32](req.objsPtr, req.countObjs)
+	countProps := fakeSlice[uint32](req.countPropsPtr, req.countObjs)
+	var total uint32
+	for _, n := range countProps {
+		total += n
+	}
+	props := fakeSlice[uint32](req.propsPtr, total)
+	values := fakeSlice[uint64](req.propValuesPtr, total)
+
+	commit := FakeCommit{Flags: req.flags, Values: make(map[uint32]map[string]uint64)}
+	var i int
+	for o, id := range objs {
+		commit.Values[id] = make(map[string]uint64)
+		for _, prop := range props[i : i+int(countProps[o])] {
+			if !d.hasProperty(id, prop) {
+				return syscall.EINVAL
+			}
+			commit.Values[id][d.props[prop].Name] = values[i]
+			i++
+		}
+	}
+	d.commits = append(d.commits, commit)
+	if req.flags&AtomicTestOnly != 0 {
+		return nil
+	}
+
+	for id, set := range commit.Values {
+		for name, value := range set {
+			if strings.HasSuffix(name, "OUT_FENCE_PTR") {
+				if err := fakeSignalledFence(value); err != nil {
+					return err
+				}
+				continue
+			}
+			d.setProperty(id, name, value)
+		}
+	}
+	for id, set := range commit.Values {
+		fb, ok := set["WRITEBACK_FB_ID"]
+		if !ok || fb == 0 {
+			continue
+		}
+		crtc, _ := d.propertyValue(id, "CRTC_ID")
+		d.writeback(uint32(crtc), uint32(fb))
+		// The writeback framebuffer is only used for a single job.
+		d.setProperty(id, "WRITEBACK_FB_ID", 0)
+	}
+	if req.flags&AtomicPageFlipEvent != 0 {
+		for id := range commit.Values {
+			if c, i := d.crtc(id); c != nil {
+				d.vblanks[i]++
+				d.queueVblankEvent(eventFlipComplete, req.userData, i)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *FakeDevice) hasProperty(objID, propID uint32) bool {
+	for _, v := range d.objProps[objID] {
+		if v.id == propID {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *FakeDevice) propertyValue(objID uint32, name string) (uint64, bool) {
+	for _, v := range d.objProps[objID] {
+		if d.props[v.id].Name == name {
+			return v.value, true
+		}
+	}
+	return 0, false
+}
+
+// setProperty updates the value of a property, and the state of the objects
+// that it reflects.
+func (d *FakeDevice) setProperty(objID uint32, name string, value uint64) {
+	for i, v := range d.objProps[objID] {
+		if d.props[v.id].Name == name {
+			d.objProps[objID][i].value = value
+		}
+	}
+	if c, _ := d.crtc(objID); c != nil && name == "MODE_ID" {
+		c.Mode = nil
+		if blob := d.blobs[uint32(value)]; len(blob) == int(unsafe.Sizeof(ModeInfo{})) {
+			var mode ModeInfo
+			copy(modeBytes(&mode), blob)
+			c.Mode = &mode
+		}
+	}
+	for _, p := range d.planes {
+		if p.ID != objID {
+			continue
+		}
+		switch name {
+		case "CRTC_ID":
+			p.CrtcID = uint32(value)
+		case "FB_ID":
+			p.FBID = uint32(value)
+		}
+	}
+}
+
+// writeback copies the framebuffer of the first plane on a CRTC into fb.
+func (d *FakeDevice) writeback(crtc, fb uint32) {
+	dst, ok := d.fbs[fb]
+	if !ok {
+		return
+	}
+	for _, p := range d.planes {
+		if p.CrtcID != crtc || p.FBID == 0 {
+			continue
+		}
+		if src, ok := d.fbs[p.FBID]; ok {
+			copy(d.dumbs[dst.Handles[0]], d.dumbs[src.Handles[0]])
+		}
+		return
+	}
+}
+
+func (d *FakeDevice) crtc(id uint32) (*ModeCrtc, int) {
+	for i, c := range d.crtcs {
+		if c.ID == id {
+			return c, i
+		}
+	}
+	return nil, -1
+}
+
+// waitVblank advances the vblank counter of the CRTC to the requested
+// sequence, rather than waiting for it.
+func (d *FakeDevice) waitVblank(vbl *cWaitVblank) error {
+	index := 0
+	switch {
+	case vbl.typ&vblankSecondary != 0:
+		index = 1
+	case vbl.typ&vblankHighCrtcMask != 0:
+		index = int(vbl.typ&vblankHighCrtcMask) >> vblankHighCrtcShift
+	}
+	if index >= len(d.crtcs) || d.crtcs[index].Mode == nil {
+		return syscall.EINVAL
+	}
+	target := vbl.sequence
+	if vbl.typ&WaitVblankRelative != 0 {
+		target += d.vblanks[index]
+	}
+	if target > d.vblanks[index] {
+		d.vblanks[index] = target
+	}
+	if vbl.typ&WaitVblankEvent != 0 {
+		d.queueVblankEvent(eventVblank, uint64(vbl.tvalSec), index)
+		return nil
+	}
+	ts := d.vblankTime(index)
+	vbl.sequence = d.vblanks[index]
+	vbl.tvalSec, vbl.tvalUsec = int64(ts/time.Second), int64(ts%time.Second/time.Microsecond)
+	return nil
+}
+
+// vblankTime returns the time of the current vblank of a CRTC, counting from
+// zero at the refresh rate of its mode.
+func (d *FakeDevice) vblankTime(index int) time.Duration {
+	refresh := uint32(fakeRefresh)
+	if mode := d.crtcs[index].Mode; mode != nil && mode.VRefresh != 0 {
+		refresh = mode.VRefresh
+	}
+	return time.Duration(d.vblanks[index]) * time.Second / time.Duration(refresh)
+}
+
+func (d *FakeDevice) queueVblankEvent(typ uint32, userData uint64, index int) {
+	ts := d.vblankTime(index)
+	e := make([]byte, eventHeaderSize+24)
+	binary.LittleEndian.PutUint32(e, typ)
+	binary.LittleEndian.PutUint32(e[4:], uint32(len(e)))
+	binary.LittleEndian.PutUint64(e[8:], userData)
+	binary.LittleEndian.PutUint32(e[16:], uint32(ts/time.Second))
+	binary.LittleEndian.PutUint32(e[20:], uint32(ts%time.Second/time.Microsecond))
+	binary.LittleEndian.PutUint32(e[24:], d.vblanks[index])
+	binary.LittleEndian.PutUint32(e[28:], d.crtcs[index].ID)
+	d.events = append(d.events, e...)
+	d.cond.Broadcast()
+}
+
+// fakeFormatBlob encodes an IN_FORMATS blob supporting all formats, which must
+// be at most 64, with each modifier.
+func fakeFormatBlob(formats []uint32, modifiers ...uint64) []byte {
+	formatsOffset := formatBlobHeaderSize
+	modifiersOffset := formatsOffset + (len(formats)*4+7)&^7
+	b := make([]byte, modifiersOffset+len(modifiers)*formatModifierSize)
+	for i, v := range []int{formatBlobVersion, 0, len(formats), formatsOffset, len(modifiers), modifiersOffset} {
+		binary.LittleEndian.PutUint32(b[i*4:], uint32(v))
+	}
+	for i, format := range formats {
+		binary.LittleEndian.PutUint32(b[formatsOffset+i*4:], format)
+	}
+	for i, modifier := range modifiers {
+		m := b[modifiersOffset+i*formatModifierSize:]
+		binary.LittleEndian.PutUint64(m, 1<<len(formats)-1)
+		binary.LittleEndian.PutUint64(m[16:], modifier)
+	}
+	return b
+}
+
+// fakeSignalledFence stores an already signalled sync file at the fence
+// pointer ptr: the read end of a pipe holding a byte.
+func fakeSignalledFence(ptr uint64) error {
+	if ptr == 0 {
+		return syscall.EFAULT
+	}
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		return err
+	}
+	defer syscall.Close(p[1])
+	if _, err := syscall.Write(p[1], []byte{0}); err != nil {
+		syscall.Close(p[0])
+		return err
+	}
+	fakeSlice[int32](ptr, 1)[0] = int32(p[0])
+	return nil
+}
+
+// fakeSlice returns the n elements at a pointer passed by a client, as the
+// kernel would read them.
+func fakeSlice[T any](ptr uint64, n uint32) []T {
+	if ptr == 0 || n == 0 {
+		return nil
+	}
+	return unsafe.Slice((*T)(*(*unsafe.Pointer)(unsafe.Pointer(&ptr))), n)
+}
+
+// fakeCopyOut copies src to a client array of *count elements if it is large
+// enough, and sets *count to the length of src, as the kernel does.
+func fakeCopyOut[T any](ptr uint64, count *uint32, src []T) {
+	if int(*count) >= len(src) {
+		copy(fakeSlice[T](ptr, *count), src)
+	}
+	*count = uint32(len(src))
+}
@@ -0,0 +1,299 @@
+package drm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+// fakeVKMSPipe returns the CRTC, primary plane and connectors of a card on a
+// FakeDevice laid out by NewFakeVKMS.
+func fakeVKMSPipe(t *testing.T, c *Card) (crtc, plane uint32, connectors []*ModeConnector) {
+	t.Helper()
+	res, err := c.ModeGetResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crtcs) != 1 {
+		t.Fatalf("got %d CRTCs, want 1", len(res.Crtcs))
+	}
+	if plane, err = c.PrimaryPlane(0); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range res.Connectors {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		connectors = append(connectors, conn)
+	}
+	return res.Crtcs[0], plane, connectors
+}
+
+func TestFakeVersion(t *testing.T) {
+	ver, err := NewWithTransport(NewFakeVKMS(testXGA)).Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Version{Major: 1, Name: "vkms", Date: "0", Desc: "Virtual Kernel Mode Setting"}
+	if !reflect.DeepEqual(ver, want) {
+		t.Errorf("got %+v, want %+v", ver, want)
+	}
+}
+
+func TestFakeCaps(t *testing.T) {
+	dev := NewFakeVKMS(testXGA)
+	c := NewWithTransport(dev)
+	if v, err := c.GetCap(CapDumbBuffer); err != nil || v != 1 {
+		t.Errorf("dumb buffer cap: got %d, %v", v, err)
+	}
+	dev.SetCap(CapDumbBuffer, 0)
+	if _, err := c.GetCap(CapDumbBuffer); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("removed cap: got %v, want EINVAL", err)
+	}
+
+	if err := c.SetClientCap(ClientCapWritebackConnectors, 1); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("writeback cap without atomic: got %v, want EINVAL", err)
+	}
+	for _, cc := range []uint64{ClientCapAtomic, ClientCapWritebackConnectors} {
+		if err := c.SetClientCap(cc, 1); err != nil {
+			t.Fatal(err)
+		}
+		if dev.ClientCap(cc) != 1 {
+			t.Errorf("client cap %d not set", cc)
+		}
+	}
+}
+
+func TestFakeResources(t *testing.T) {
+	c := NewWithTransport(NewFakeVKMS(testXGA))
+	if err := c.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	crtcID, plane, connectors := fakeVKMSPipe(t, c)
+	if len(connectors) != 1 || connectors[0].Type != ModeConnectorVirtual {
+		t.Fatalf("got connectors %+v, want a single virtual one", connectors)
+	}
+	if !reflect.DeepEqual(connectors[0].Modes, []ModeInfo{testXGA}) {
+		t.Errorf("got modes %+v", connectors[0].Modes)
+	}
+
+	crtc, err := c.ModeGetCrtc(crtcID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.Mode == nil || *crtc.Mode != testXGA {
+		t.Errorf("got CRTC mode %+v, want %+v", crtc.Mode, testXGA)
+	}
+	formats, err := c.PlaneFormats(plane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !formats.Supports(fourcc.XRGB8888, fourcc.ModLinear) {
+		t.Errorf("primary plane doesn't support linear XRGB8888")
+	}
+
+	// Writeback connectors are only listed once asked for.
+	if err := c.SetClientCap(ClientCapWritebackConnectors, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, _, connectors = fakeVKMSPipe(t, c)
+	if len(connectors) != 2 || connectors[1].Type != ModeConnectorWriteback {
+		t.Errorf("got connectors %+v, want a virtual and a writeback one", connectors)
+	}
+}
+
+func TestFakeAtomicModeset(t *testing.T) {
+	dev := NewFakeVKMS(testXGA)
+	c := NewWithTransport(dev)
+	if err := c.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	crtcID, plane, _ := fakeVKMSPipe(t, c)
+
+	dumb, err := c.CreateDumb(uint32(testVGA.HDisplay), uint32(testVGA.VDisplay), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := c.MapDumb(dumb, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Unmap()
+	for i := range mapping.Pix {
+		mapping.Pix[i] = byte(i)
+	}
+	fb, err := c.AddDumbFB(dumb, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := c.ModeCreateModeBlob(&testVGA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := c.NewAtomicRequest()
+	for _, obj := range []struct {
+		id, typ uint32
+		values  map[string]uint64
+	}{
+		{crtcID, ModeObjectCrtc, map[string]uint64{"MODE_ID": uint64(blob)}},
+		{plane, ModeObjectPlane, map[string]uint64{"FB_ID": uint64(fb), "SRC_W": uint64(testVGA.HDisplay) << 16}},
+	} {
+		props, err := c.ObjectGetProperties(obj.id, obj.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range obj.values {
+			if err := req.SetByName(obj.id, props, name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := req.Commit(AtomicAllowModeset); err != nil {
+		t.Fatal(err)
+	}
+
+	crtc, err := c.ModeGetCrtc(crtcID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crtc.Mode == nil || *crtc.Mode != testVGA {
+		t.Errorf("got CRTC mode %+v, want %+v", crtc.Mode, testVGA)
+	}
+	p, err := c.ModeGetPlane(plane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.FBID != fb || p.CrtcID != crtcID {
+		t.Errorf("got plane FB %d on CRTC %d, want FB %d on CRTC %d", p.FBID, p.CrtcID, fb, crtcID)
+	}
+	if v, _ := dev.Property(plane, "SRC_W"); v != uint64(testVGA.HDisplay)<<16 {
+		t.Errorf("got SRC_W %#x", v)
+	}
+	if pix, ok := dev.Framebuffer(fb); !ok || !bytes.Equal(pix, mapping.Pix) {
+		t.Errorf("framebuffer doesn't hold the mapped pixels")
+	}
+
+	commits := dev.Commits()
+	if len(commits) != 1 || commits[0].Flags != AtomicAllowModeset || commits[0].Values[plane]["FB_ID"] != uint64(fb) {
+		t.Errorf("got commits %+v", commits)
+	}
+}
+
+func TestFakeGetFB2(t *testing.T) {
+	c := NewWithTransport(NewFakeVKMS(testXGA))
+	dumb, err := c.CreateDumb(uint32(testVGA.HDisplay), uint32(testVGA.VDisplay), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.AddDumbFB(dumb, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := c.GetFB2(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.Width != dumb.Width || fb.Height != dumb.Height || fb.PixelFormat != fourcc.XRGB8888 || fb.Pitches[0] != dumb.Pitch {
+		t.Errorf("got %+v for a %dx%d XRGB8888 dumb buffer", fb, dumb.Width, dumb.Height)
+	}
+	// The new handle shares the memory of the dumb buffer.
+	if fb.Handles[0] == 0 || fb.Handles[0] == dumb.Handle {
+		t.Fatalf("got handle %d, want a new one", fb.Handles[0])
+	}
+	mapping, err := c.MapDumb(dumb, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Unmap()
+	mapping.Pix[0] = 0x42
+	shared := *dumb
+	shared.Handle = fb.Handles[0]
+	view, err := c.MapDumb(&shared, fourcc.XRGB8888)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unmap()
+	if view.Pix[0] != 0x42 {
+		t.Errorf("handle %d doesn't share the buffer", fb.Handles[0])
+	}
+
+	if err := c.GemClose(fb.Handles[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GemClose(fb.Handles[0]); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("closed handle: got %v, want EINVAL", err)
+	}
+	if _, err := c.GetFB2(0); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("missing framebuffer: got %v, want ENOENT", err)
+	}
+}
+
+func TestFakeAtomicUnknownProperty(t *testing.T) {
+	dev := NewFakeVKMS(testXGA)
+	c := NewWithTransport(dev)
+	if err := c.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	crtcID, plane, _ := fakeVKMSPipe(t, c)
+	props, err := c.ObjectGetProperties(plane, ModeObjectPlane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CRTC has no FB_ID property.
+	fbID, _ := props.Lookup("FB_ID")
+	req := c.NewAtomicRequest()
+	req.Set(crtcID, fbID.ID, 0)
+	if err := req.Commit(AtomicAllowModeset); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("got %v, want EINVAL", err)
+	}
+}
+
+func TestFakeWaitVblank(t *testing.T) {
+	c := NewWithTransport(NewFakeVKMS(testXGA))
+	vbl, err := c.WaitVblank(0, WaitVblankRelative, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (VblankReply{Sequence: 3, Timestamp: 50 * time.Millisecond}); *vbl != want {
+		t.Errorf("got %+v, want %+v", *vbl, want)
+	}
+
+	if _, err := c.WaitVblank(0, WaitVblankRelative|WaitVblankEvent, 1, 42); err != nil {
+		t.Fatal(err)
+	}
+	events, err := c.ReadEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crtc, _, _ := fakeVKMSPipe(t, c)
+	// Event timestamps are in microseconds.
+	want := &VblankEvent{UserData: 42, Timestamp: 66666 * time.Microsecond, Sequence: 4, CrtcID: crtc}
+	if len(events) != 1 || !reflect.DeepEqual(events[0], want) {
+		t.Errorf("got %+v, want %+v", events, want)
+	}
+
+	if _, err := c.WaitVblank(1, WaitVblankRelative, 1, 0); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("missing CRTC: got %v, want EINVAL", err)
+	}
+}
+
+func TestFakeClose(t *testing.T) {
+	c := NewWithTransport(NewFakeVKMS(testXGA))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Version(); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("got %v, want EBADF", err)
+	}
+	if _, err := c.ReadEvents(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("got %v, want os.ErrClosed", err)
+	}
+}
@@ -0,0 +1,114 @@
+package drm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+// FormatModifiers maps the formats supported by a plane to the modifiers it
+// supports them with.
+type FormatModifiers map[uint32][]uint64
+
+const (
+	formatBlobVersion    = 1
+	formatBlobHeaderSize = 24
+	formatModifierSize   = 24
+)
+
+// formatBPP returns the bits per pixel of the formats Mapping can decode.
+func formatBPP(format uint32) (uint32, error) {
+	switch format {
+	case fourcc.XRGB8888, fourcc.ARGB8888, fourcc.XBGR8888, fourcc.ABGR8888,
+		fourcc.XRGB2101010, fourcc.ARGB2101010, fourcc.RGB565:
+		info, _ := fourcc.Lookup(format)
+		return uint32(info.BPP()), nil
+	}
+	return 0, fmt.Errorf("unsupported format %s", fourcc.Name(format))
+}
+
+// PlaneFormats returns the formats and modifiers supported by a plane, from
+// its IN_FORMATS property. Without the property, the modifiers of the formats
+// listed by ModeGetPlane are implicit, so they are reported as ModInvalid.
+func (c *Card) PlaneFormats(planeID uint32) (FormatModifiers, error) {
+	props, err := c.ObjectGetProperties(planeID, ModeObjectPlane)
+	if err != nil {
+		return nil, err
+	}
+	if prop, ok := props.Lookup("IN_FORMATS"); ok && prop.BlobID() != 0 {
+		blob, err := c.ModeGetPropBlob(prop.BlobID())
+		if err != nil {
+			return nil, fmt.Errorf("IN_FORMATS: %w", err)
+		}
+		return ParseFormatModifiers(blob)
+	}
+	plane, err := c.ModeGetPlane(planeID)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(FormatModifiers, len(plane.Formats))
+	for _, format := range plane.Formats {
+		ret[format] = []uint64{fourcc.ModInvalid}
+	}
+	return ret, nil
+}
+
+// ParseFormatModifiers decodes a struct drm_format_modifier_blob, as held by
+// the IN_FORMATS property of a plane. Each modifier of the blob applies to a
+// window of 64 formats, selected by a bitmask.
+func ParseFormatModifiers(b []byte) (FormatModifiers, error) {
+	if len(b) < formatBlobHeaderSize {
+		return nil, fmt.Errorf("format blob too short")
+	}
+	if version := binary.LittleEndian.Uint32(b); version != formatBlobVersion {
+		return nil, fmt.Errorf("unsupported format blob version %d", version)
+	}
+	countFormats, formatsOffset := binary.LittleEndian.Uint32(b[8:]), binary.LittleEndian.Uint32(b[12:])
+	countModifiers, modifiersOffset := binary.LittleEndian.Uint32(b[16:]), binary.LittleEndian.Uint32(b[20:])
+	if uint64(formatsOffset)+uint64(countFormats)*4 > uint64(len(b)) ||
+		uint64(modifiersOffset)+uint64(countModifiers)*formatModifierSize > uint64(len(b)) {
+		return nil, fmt.Errorf("format blob arrays out of bounds")
+	}
+
+	formats := make([]uint32, countFormats)
+	ret := make(FormatModifiers, countFormats)
+	for i := range formats {
+		formats[i] = binary.LittleEndian.Uint32(b[formatsOffset+uint32(i)*4:])
+		ret[formats[i]] = nil
+	}
+	for i := uint32(0); i < countModifiers; i++ {
+		m := b[modifiersOffset+i*formatModifierSize:]
+		mask, offset, modifier := binary.LittleEndian.Uint64(m), binary.LittleEndian.Uint32(m[8:]), binary.LittleEndian.Uint64(m[16:])
+		for bit := uint32(0); bit < 64; bit++ {
+			if mask&(1<<bit) == 0 || offset+bit >= countFormats {
+				continue
+			}
+			format := formats[offset+bit]
+			ret[format] = append(ret[format], modifier)
+		}
+	}
+	return ret, nil
+}
+
+// Formats returns the supported formats in ascending order.
+func (f FormatModifiers) Formats() []uint32 {
+	ret := make([]uint32, 0, len(f))
+	for format := range f {
+		ret = append(ret, format)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// Supports reports whether format is supported with modifier. ModInvalid
+// matches formats with implicit modifiers.
+func (f FormatModifiers) Supports(format uint32, modifier uint64) bool {
+	for _, m := range f[format] {
+		if m == modifier {
+			return true
+		}
+	}
+	return false
+}
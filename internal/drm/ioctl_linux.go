@@ -3,8 +3,28 @@ package drm
 import (
 	"os"
 	"syscall"
+	"unsafe"
 )
 
+// Transport carries the requests of a card to a DRM device, which is the
+// kernel for cards opened from a device node, or a FakeDevice in tests.
+type Transport interface {
+	// Ioctl performs request on the kernel layout struct at arg. Errors are
+	// syscall.Errno values, as returned by the kernel.
+	Ioctl(request uint32, arg unsafe.Pointer) error
+	// Read reads pending events.
+	Read(b []byte) (int, error)
+	// Mmap maps length bytes at an offset returned by the map dumb ioctl.
+	Mmap(offset int64, length int) ([]byte, error)
+	Munmap(b []byte) error
+	Close() error
+}
+
+// fileTransport is the transport of a device node.
+type fileTransport struct {
+	f *os.File
+}
+
 const (
 	// IoctlBase is the DRM specific character that identifies DRM ioctls.
 	ioctlBase uint8 = 'd'
@@ -41,8 +61,38 @@ func ioctlRequest(dir uint8, size uint16, typ, nr uint8) uint32 {
 		(uint32(typ) << iocTypeShift) | (uint32(nr) << iocNRShift)
 }
 
-func ioctl(fd *os.File, request uint32, data uintptr) error {
-	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), uintptr(request), data); err != 0 {
+func (t *fileTransport) Ioctl(request uint32, arg unsafe.Pointer) error {
+	return ioctlFile(t.f, request, arg)
+}
+
+func (t *fileTransport) Read(b []byte) (int, error) {
+	return t.f.Read(b)
+}
+
+func (t *fileTransport) Mmap(offset int64, length int) ([]byte, error) {
+	return syscall.Mmap(int(t.f.Fd()), offset, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func (t *fileTransport) Munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+func (t *fileTransport) Close() error {
+	return t.f.Close()
+}
+
+// ioctl performs a request through the transport of the card, typing the
+// errors it returns.
+func (c *Card) ioctl(request uint32, arg unsafe.Pointer) error {
+	if err := c.t.Ioctl(request, arg); err != nil {
+		return ioctlError(request, err)
+	}
+	return nil
+}
+
+// ioctlFile performs an ioctl on any file, such as a dma-buf.
+func ioctlFile(f *os.File, request uint32, arg unsafe.Pointer) error {
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(request), uintptr(arg)); err != 0 {
 		return err
 	}
 	return nil
@@ -0,0 +1,257 @@
+package drm
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type (
+	// Lease is a set of KMS objects leased to another client, which holds DRM
+	// master over them through File.
+	Lease struct {
+		LesseeID uint32
+		// File is the lessee side of the lease. It is a new DRM file
+		// descriptor to be handed to the lessee, see SendLease.
+		File    *os.File
+		Objects []uint32
+	}
+
+	// WritebackLease is a lease of what is needed to capture a CRTC with
+	// writeback: the CRTC, its primary plane and a writeback connector.
+	WritebackLease struct {
+		Lease
+		Connector uint32
+		Crtc      uint32
+		Plane     uint32
+	}
+
+	cModeCreateLease struct {
+		objectIDs   uint64
+		objectCount uint32
+		flags       uint32
+		lesseeID    uint32
+		fd          uint32
+	}
+
+	cModeListLessees struct {
+		countLessees uint32
+		pad          uint32
+		lesseesPtr   uint64
+	}
+
+	cModeGetLease struct {
+		countObjects uint32
+		pad          uint32
+		objectsPtr   uint64
+	}
+
+	cModeRevokeLease struct {
+		lesseeID uint32
+	}
+)
+
+// Flags of the lessee file descriptor.
+const (
+	LeaseCloexec  = uint32(syscall.O_CLOEXEC)
+	LeaseNonblock = uint32(syscall.O_NONBLOCK)
+)
+
+var (
+	ioctlModeCreateLease = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCreateLease{})), ioctlBase, 0xc6)
+	ioctlModeListLessees = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeListLessees{})), ioctlBase, 0xc7)
+	ioctlModeGetLease    = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetLease{})), ioctlBase, 0xc8)
+	ioctlModeRevokeLease = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeRevokeLease{})), ioctlBase, 0xc9)
+)
+
+// CreateLease leases objects to a new lessee. The caller must be DRM master.
+// Leasing a CRTC or connector requires leasing a plane able to drive it too.
+func (c *Card) CreateLease(objects []uint32, flags uint32) (*Lease, error) {
+	lease := cModeCreateLease{objectIDs: slicePtr(objects), objectCount: uint32(len(objects)), flags: flags}
+	if err := c.ioctl(ioctlModeCreateLease, unsafe.Pointer(&lease)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	return &Lease{
+		LesseeID: lease.lesseeID,
+		File:     os.NewFile(uintptr(lease.fd), "drm-lease"),
+		Objects:  append([]uint32(nil), objects...),
+	}, nil
+}
+
+// ListLessees returns the lessees of this lessor.
+func (c *Card) ListLessees() ([]uint32, error) {
+	for {
+		var list cModeListLessees
+		if err := c.ioctl(ioctlModeListLessees, unsafe.Pointer(&list)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		count := list.countLessees
+		ret := make([]uint32, count)
+		list.lesseesPtr = slicePtr(ret)
+		if err := c.ioctl(ioctlModeListLessees, unsafe.Pointer(&list)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		if list.countLessees <= count {
+			return ret[:list.countLessees], nil
+		}
+	}
+}
+
+// GetLease returns the objects leased to this file descriptor. For a lessor,
+// these are all the objects it has access to.
+func (c *Card) GetLease() ([]uint32, error) {
+	for {
+		var lease cModeGetLease
+		if err := c.ioctl(ioctlModeGetLease, unsafe.Pointer(&lease)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		count := lease.countObjects
+		ret := make([]uint32, count)
+		lease.objectsPtr = slicePtr(ret)
+		if err := c.ioctl(ioctlModeGetLease, unsafe.Pointer(&lease)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		if lease.countObjects <= count {
+			return ret[:lease.countObjects], nil
+		}
+	}
+}
+
+// RevokeLease ends a lease. The lessee keeps its file descriptor, but loses
+// access to the objects.
+func (c *Card) RevokeLease(lesseeID uint32) error {
+	revoke := cModeRevokeLease{lesseeID: lesseeID}
+	if err := c.ioctl(ioctlModeRevokeLease, unsafe.Pointer(&revoke)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
+
+// LeaseWriteback finds a writeback connector, a CRTC it can be attached to and
+// the primary plane of that CRTC, and leases them. This is meant to be called
+// by a privileged process holding DRM master, on behalf of an unprivileged
+// capture process.
+func (c *Card) LeaseWriteback(flags uint32) (*WritebackLease, error) {
+	if err := c.SetClientCap(ClientCapAtomic, 1); err != nil {
+		return nil, fmt.Errorf("atomic cap: %w", err)
+	}
+	if err := c.SetClientCap(ClientCapWritebackConnectors, 1); err != nil {
+		return nil, fmt.Errorf("writeback cap: %w", err)
+	}
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return nil, err
+	}
+	planes, err := c.ModeGetPlaneResources()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range res.Connectors {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			return nil, err
+		}
+		if conn.Type != ModeConnectorWriteback {
+			continue
+		}
+		var possibleCrtcs uint32
+		for _, encID := range conn.Encoders {
+			enc, err := c.ModeGetEncoder(encID)
+			if err != nil {
+				return nil, err
+			}
+			possibleCrtcs |= enc.PossibleCrtcs
+		}
+		for i, crtc := range res.Crtcs {
+			if possibleCrtcs&(1<<i) == 0 {
+				continue
+			}
+			plane, err := c.primaryPlane(planes, i)
+			if err != nil {
+				return nil, err
+			}
+			if plane == 0 {
+				continue
+			}
+			lease, err := c.CreateLease([]uint32{conn.ID, crtc, plane}, flags)
+			if err != nil {
+				return nil, err
+			}
+			return &WritebackLease{Lease: *lease, Connector: conn.ID, Crtc: crtc, Plane: plane}, nil
+		}
+	}
+	return nil, fmt.Errorf("no writeback connector with a usable CRTC")
+}
+
+// PrimaryPlane returns the primary plane that can drive the CRTC at index in
+// the resources of the card, or zero if there is none. The card must have
+// ClientCapUniversalPlanes or ClientCapAtomic set.
+func (c *Card) PrimaryPlane(index int) (uint32, error) {
+	planes, err := c.ModeGetPlaneResources()
+	if err != nil {
+		return 0, err
+	}
+	return c.primaryPlane(planes, index)
+}
+
+// primaryPlane returns the primary plane that can drive the CRTC at index, or
+// zero if there is none.
+func (c *Card) primaryPlane(planes []uint32, index int) (uint32, error) {
+	for _, id := range planes {
+		plane, err := c.ModeGetPlane(id)
+		if err != nil {
+			return 0, err
+		}
+		if plane.PossibleCrtcs&(1<<index) == 0 {
+			continue
+		}
+		props, err := c.ObjectGetProperties(id, ModeObjectPlane)
+		if err != nil {
+			return 0, err
+		}
+		if typ, ok := props.Lookup("type"); ok {
+			if name, err := typ.Enum(); err == nil && name == "Primary" {
+				return id, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// SendLease passes the lessee file descriptor of a lease over a unix socket.
+// The lessor keeps its own copy open until it closes l.File.
+func SendLease(conn *net.UnixConn, l *Lease) error {
+	rights := syscall.UnixRights(int(l.File.Fd()))
+	if _, _, err := conn.WriteMsgUnix([]byte{0}, rights, nil); err != nil {
+		return fmt.Errorf("send lease: %w", err)
+	}
+	return nil
+}
+
+// ReceiveLease receives a lessee file descriptor sent by SendLease, and opens
+// it as a card.
+func ReceiveLease(conn *net.UnixConn) (*Card, error) {
+	buf, oob := make([]byte, 1), make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("receive lease: %w", err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("receive lease: %w", err)
+	}
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, fd := range fds[1:] {
+			syscall.Close(fd)
+		}
+		return New(os.NewFile(uintptr(fds[0]), "drm-lease")), nil
+	}
+	return nil, fmt.Errorf("receive lease: no file descriptor")
+}
@@ -0,0 +1,436 @@
+package drm
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+type (
+	// ModeInfo is a display mode, laid out like struct drm_mode_modeinfo.
+	ModeInfo struct {
+		// Clock is the pixel clock in kHz.
+		Clock      uint32
+		HDisplay   uint16
+		HSyncStart uint16
+		HSyncEnd   uint16
+		HTotal     uint16
+		HSkew      uint16
+		VDisplay   uint16
+		VSyncStart uint16
+		VSyncEnd   uint16
+		VTotal     uint16
+		VScan      uint16
+		// VRefresh is the refresh rate in Hz.
+		VRefresh uint32
+		Flags    uint32
+		Type     uint32
+		Name     [displayModeLen]byte
+	}
+
+	ModeResources struct {
+		FBs        []uint32
+		Crtcs      []uint32
+		Connectors []uint32
+		Encoders   []uint32
+		MinWidth   uint32
+		MaxWidth   uint32
+		MinHeight  uint32
+		MaxHeight  uint32
+	}
+
+	ModeConnector struct {
+		ID uint32
+		// EncoderID is the encoder currently driving the connector, zero if none.
+		EncoderID uint32
+		Type      uint32
+		// TypeID distinguishes connectors of the same type, e.g. the 1 in HDMI-A-1.
+		TypeID     uint32
+		Connection uint32
+		// Physical size of the display, zero if unknown.
+		MMWidth  uint32
+		MMHeight uint32
+		SubPixel uint32
+		Modes    []ModeInfo
+		// Props and PropValues are the IDs and values of the properties of the
+		// connector, in the same order.
+		Props      []uint32
+		PropValues []uint64
+		Encoders   []uint32
+	}
+
+	ModeEncoder struct {
+		ID     uint32
+		Type   uint32
+		CrtcID uint32
+		// PossibleCrtcs and PossibleClones are bitmasks of indices into the
+		// CRTCs and encoders of ModeResources.
+		PossibleCrtcs  uint32
+		PossibleClones uint32
+	}
+
+	ModeCrtc struct {
+		ID   uint32
+		FBID uint32
+		X    uint32
+		Y    uint32
+		// Mode is the current mode, nil if the CRTC is disabled.
+		Mode      *ModeInfo
+		GammaSize uint32
+	}
+
+	ModePlane struct {
+		ID     uint32
+		CrtcID uint32
+		FBID   uint32
+		// PossibleCrtcs is a bitmask of indices into the CRTCs of ModeResources.
+		PossibleCrtcs uint32
+		GammaSize     uint32
+		// Formats are the fourcc codes of the pixel formats the plane supports.
+		Formats []uint32
+	}
+
+	cModeCardRes struct {
+		fbIDPtr         uint64
+		crtcIDPtr       uint64
+		connectorIDPtr  uint64
+		encoderIDPtr    uint64
+		countFBs        uint32
+		countCrtcs      uint32
+		countConnectors uint32
+		countEncoders   uint32
+		minWidth        uint32
+		maxWidth        uint32
+		minHeight       uint32
+		maxHeight       uint32
+	}
+
+	cModeGetConnector struct {
+		encodersPtr     uint64
+		modesPtr        uint64
+		propsPtr        uint64
+		propValuesPtr   uint64
+		countModes      uint32
+		countProps      uint32
+		countEncoders   uint32
+		encoderID       uint32
+		connectorID     uint32
+		connectorType   uint32
+		connectorTypeID uint32
+		connection      uint32
+		mmWidth         uint32
+		mmHeight        uint32
+		subpixel        uint32
+		pad             uint32
+	}
+
+	cModeGetEncoder struct {
+		encoderID      uint32
+		encoderType    uint32
+		crtcID         uint32
+		possibleCrtcs  uint32
+		possibleClones uint32
+	}
+
+	cModeCrtc struct {
+		setConnectorsPtr uint64
+		countConnectors  uint32
+		crtcID           uint32
+		fbID             uint32
+		x                uint32
+		y                uint32
+		gammaSize        uint32
+		modeValid        uint32
+		mode             ModeInfo
+	}
+
+	cModeGetPlaneRes struct {
+		planeIDPtr  uint64
+		countPlanes uint32
+	}
+
+	cModeGetPlane struct {
+		planeID          uint32
+		crtcID           uint32
+		fbID             uint32
+		possibleCrtcs    uint32
+		gammaSize        uint32
+		countFormatTypes uint32
+		formatTypePtr    uint64
+	}
+)
+
+const (
+	displayModeLen = 32
+
+	ModeFlagPHSync    uint32 = 1 << 0
+	ModeFlagNHSync    uint32 = 1 << 1
+	ModeFlagPVSync    uint32 = 1 << 2
+	ModeFlagNVSync    uint32 = 1 << 3
+	ModeFlagInterlace uint32 = 1 << 4
+	ModeFlagDblScan   uint32 = 1 << 5
+
+	ModeTypePreferred uint32 = 1 << 3
+	ModeTypeUserDef   uint32 = 1 << 5
+	ModeTypeDriver    uint32 = 1 << 6
+
+	ModeConnected         uint32 = 1
+	ModeDisconnected      uint32 = 2
+	ModeUnknownConnection uint32 = 3
+)
+
+const (
+	ModeConnectorUnknown uint32 = iota
+	ModeConnectorVGA
+	ModeConnectorDVII
+	ModeConnectorDVID
+	ModeConnectorDVIA
+	ModeConnectorComposite
+	ModeConnectorSVIDEO
+	ModeConnectorLVDS
+	ModeConnectorComponent
+	ModeConnector9PinDIN
+	ModeConnectorDisplayPort
+	ModeConnectorHDMIA
+	ModeConnectorHDMIB
+	ModeConnectorTV
+	ModeConnectorEDP
+	ModeConnectorVirtual
+	ModeConnectorDSI
+	ModeConnectorDPI
+	ModeConnectorWriteback
+	ModeConnectorSPI
+	ModeConnectorUSB
+)
+
+const (
+	ModeEncoderNone uint32 = iota
+	ModeEncoderDAC
+	ModeEncoderTMDS
+	ModeEncoderLVDS
+	ModeEncoderTVDAC
+	ModeEncoderVirtual
+	ModeEncoderDSI
+	ModeEncoderDPMST
+	ModeEncoderDPI
+)
+
+var (
+	ioctlModeGetResources      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCardRes{})), ioctlBase, 0xa0)
+	ioctlModeGetCrtc           = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeCrtc{})), ioctlBase, 0xa1)
+	ioctlModeGetEncoder        = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetEncoder{})), ioctlBase, 0xa6)
+	ioctlModeGetConnector      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetConnector{})), ioctlBase, 0xa7)
+	ioctlModeGetPlaneResources = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetPlaneRes{})), ioctlBase, 0xb5)
+	ioctlModeGetPlane          = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetPlane{})), ioctlBase, 0xb6)
+)
+
+var (
+	connectorTypeNames = []string{
+		"Unknown", "VGA", "DVI-I", "DVI-D", "DVI-A", "Composite", "SVIDEO", "LVDS", "Component", "DIN",
+		"DP", "HDMI-A", "HDMI-B", "TV", "eDP", "Virtual", "DSI", "DPI", "Writeback", "SPI", "USB",
+	}
+	encoderTypeNames = []string{"None", "DAC", "TMDS", "LVDS", "TVDAC", "Virtual", "DSI", "DPMST", "DPI"}
+)
+
+// ConnectorTypeName returns the name the kernel gives to a connector type, as
+// used in connector names such as HDMI-A-1.
+func ConnectorTypeName(typ uint32) string {
+	if int(typ) < len(connectorTypeNames) {
+		return connectorTypeNames[typ]
+	}
+	return fmt.Sprintf("Type%d", typ)
+}
+
+func EncoderTypeName(typ uint32) string {
+	if int(typ) < len(encoderTypeNames) {
+		return encoderTypeNames[typ]
+	}
+	return fmt.Sprintf("Type%d", typ)
+}
+
+// slicePtr returns the address of the first element of s, to be passed to the
+// kernel, or zero if s is empty.
+func slicePtr[T any](s []T) uint64 {
+	if len(s) == 0 {
+		return 0
+	}
+	return uint64(uintptr(unsafe.Pointer(&s[0])))
+}
+
+// Name returns the name of the connector, e.g. HDMI-A-1, which is also used in
+// sysfs.
+func (c *ModeConnector) Name() string {
+	return fmt.Sprintf("%s-%d", ConnectorTypeName(c.Type), c.TypeID)
+}
+
+// ModeName returns the name of the mode.
+func (m *ModeInfo) ModeName() string {
+	return cToGoString(m.Name[:])
+}
+
+// SetName sets the name of the mode, truncating it to fit.
+func (m *ModeInfo) SetName(name string) {
+	m.Name = [displayModeLen]byte{}
+	copy(m.Name[:displayModeLen-1], name)
+}
+
+// modeBytes returns the kernel layout of mode, as held by a MODE_ID blob.
+func modeBytes(mode *ModeInfo) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(mode)), unsafe.Sizeof(*mode))
+}
+
+// ModeGetResources returns the KMS objects of the card. The counts are queried
+// first, then the IDs, retrying if objects were added in between.
+func (c *Card) ModeGetResources() (*ModeResources, error) {
+	for {
+		var res cModeCardRes
+		if err := c.ioctl(ioctlModeGetResources, unsafe.Pointer(&res)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		counts := res
+
+		ret := &ModeResources{
+			FBs:        make([]uint32, res.countFBs),
+			Crtcs:      make([]uint32, res.countCrtcs),
+			Connectors: make([]uint32, res.countConnectors),
+			Encoders:   make([]uint32, res.countEncoders),
+		}
+		res.fbIDPtr, res.crtcIDPtr = slicePtr(ret.FBs), slicePtr(ret.Crtcs)
+		res.connectorIDPtr, res.encoderIDPtr = slicePtr(ret.Connectors), slicePtr(ret.Encoders)
+		if err := c.ioctl(ioctlModeGetResources, unsafe.Pointer(&res)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		if res.countFBs > counts.countFBs || res.countCrtcs > counts.countCrtcs ||
+			res.countConnectors > counts.countConnectors || res.countEncoders > counts.countEncoders {
+			continue
+		}
+
+		ret.FBs, ret.Crtcs = ret.FBs[:res.countFBs], ret.Crtcs[:res.countCrtcs]
+		ret.Connectors, ret.Encoders = ret.Connectors[:res.countConnectors], ret.Encoders[:res.countEncoders]
+		ret.MinWidth, ret.MaxWidth = res.minWidth, res.maxWidth
+		ret.MinHeight, ret.MaxHeight = res.minHeight, res.maxHeight
+		return ret, nil
+	}
+}
+
+// ModeGetConnector returns the state of a connector without probing it, so the
+// modes are those found by the last probe.
+func (c *Card) ModeGetConnector(id uint32) (*ModeConnector, error) {
+	for {
+		conn := cModeGetConnector{connectorID: id}
+		// A mode pointer is always passed, otherwise the kernel probes the
+		// connector, which can take a long time.
+		var probe ModeInfo
+		conn.modesPtr, conn.countModes = uint64(uintptr(unsafe.Pointer(&probe))), 1
+		if err := c.ioctl(ioctlModeGetConnector, unsafe.Pointer(&conn)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		counts := conn
+
+		ret := &ModeConnector{
+			Modes:      make([]ModeInfo, conn.countModes),
+			Props:      make([]uint32, conn.countProps),
+			PropValues: make([]uint64, conn.countProps),
+			Encoders:   make([]uint32, conn.countEncoders),
+		}
+		conn.modesPtr, conn.propsPtr = slicePtr(ret.Modes), slicePtr(ret.Props)
+		conn.propValuesPtr, conn.encodersPtr = slicePtr(ret.PropValues), slicePtr(ret.Encoders)
+		if conn.modesPtr == 0 {
+			conn.modesPtr, conn.countModes = uint64(uintptr(unsafe.Pointer(&probe))), 1
+		}
+		if err := c.ioctl(ioctlModeGetConnector, unsafe.Pointer(&conn)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		if conn.countModes > counts.countModes || conn.countProps > counts.countProps ||
+			conn.countEncoders > counts.countEncoders {
+			continue
+		}
+
+		ret.ID, ret.EncoderID = conn.connectorID, conn.encoderID
+		ret.Type, ret.TypeID = conn.connectorType, conn.connectorTypeID
+		ret.Connection, ret.SubPixel = conn.connection, conn.subpixel
+		ret.MMWidth, ret.MMHeight = conn.mmWidth, conn.mmHeight
+		ret.Modes = ret.Modes[:conn.countModes]
+		ret.Props, ret.PropValues = ret.Props[:conn.countProps], ret.PropValues[:conn.countProps]
+		ret.Encoders = ret.Encoders[:conn.countEncoders]
+		return ret, nil
+	}
+}
+
+func (c *Card) ModeGetEncoder(id uint32) (*ModeEncoder, error) {
+	enc := cModeGetEncoder{encoderID: id}
+	if err := c.ioctl(ioctlModeGetEncoder, unsafe.Pointer(&enc)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	return &ModeEncoder{
+		ID:             enc.encoderID,
+		Type:           enc.encoderType,
+		CrtcID:         enc.crtcID,
+		PossibleCrtcs:  enc.possibleCrtcs,
+		PossibleClones: enc.possibleClones,
+	}, nil
+}
+
+func (c *Card) ModeGetCrtc(id uint32) (*ModeCrtc, error) {
+	crtc := cModeCrtc{crtcID: id}
+	if err := c.ioctl(ioctlModeGetCrtc, unsafe.Pointer(&crtc)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	ret := &ModeCrtc{
+		ID:        crtc.crtcID,
+		FBID:      crtc.fbID,
+		X:         crtc.x,
+		Y:         crtc.y,
+		GammaSize: crtc.gammaSize,
+	}
+	if crtc.modeValid != 0 {
+		mode := crtc.mode
+		ret.Mode = &mode
+	}
+	return ret, nil
+}
+
+// ModeGetPlaneResources returns the IDs of the planes of the card. Primary and
+// cursor planes are only included once the universal planes client capability
+// is set.
+func (c *Card) ModeGetPlaneResources() ([]uint32, error) {
+	for {
+		var res cModeGetPlaneRes
+		if err := c.ioctl(ioctlModeGetPlaneResources, unsafe.Pointer(&res)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		count := res.countPlanes
+
+		ret := make([]uint32, count)
+		res.planeIDPtr = slicePtr(ret)
+		if err := c.ioctl(ioctlModeGetPlaneResources, unsafe.Pointer(&res)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		if res.countPlanes > count {
+			continue
+		}
+		return ret[:res.countPlanes], nil
+	}
+}
+
+func (c *Card) ModeGetPlane(id uint32) (*ModePlane, error) {
+	plane := cModeGetPlane{planeID: id}
+	if err := c.ioctl(ioctlModeGetPlane, unsafe.Pointer(&plane)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	// The formats of a plane are fixed when it is created.
+	formats := make([]uint32, plane.countFormatTypes)
+	if len(formats) > 0 {
+		plane.formatTypePtr = slicePtr(formats)
+		if err := c.ioctl(ioctlModeGetPlane, unsafe.Pointer(&plane)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+	}
+	return &ModePlane{
+		ID:            plane.planeID,
+		CrtcID:        plane.crtcID,
+		FBID:          plane.fbID,
+		PossibleCrtcs: plane.possibleCrtcs,
+		GammaSize:     plane.gammaSize,
+		Formats:       formats,
+	}, nil
+}
@@ -0,0 +1,236 @@
+package drm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+// replayTransport answers ioctls with the replies of a fixture, in order. The
+// fixtures in testdata are synthetic replies of a vkms card, laid out as the
+// kernel writes them; testdata/README tells how to record them from a card
+// with recordTransport instead.
+//
+// A fixture is a sequence of little-endian records:
+//
+//	request   uint32
+//	length    uint32
+//	reply     [length]byte, the argument as written back by the kernel
+//	arrays    uint32
+//	then for each array:
+//	  ptr     uint32, offset of the pointer field in the argument
+//	  count   uint32, offset of the count field in the argument
+//	  size    uint32, size of an element
+//	  n       uint32
+//	  data    [n*size]byte
+//
+// Arrays are copied to the pointers passed by the caller if it made room for
+// them, and the count fields are set to n, as the kernel does.
+type replayTransport struct {
+	t       *testing.T
+	records []replayRecord
+	// args are the arguments as passed by the caller, before the replies.
+	args [][]byte
+}
+
+type (
+	replayRecord struct {
+		request uint32
+		reply   []byte
+		arrays  []replayArray
+	}
+
+	replayArray struct {
+		ptr, count, size uint32
+		data             []byte
+	}
+)
+
+func loadReplay(t *testing.T, name string) *replayTransport {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseReplay(t, name, b)
+}
+
+func parseReplay(t *testing.T, name string, b []byte) *replayTransport {
+	t.Helper()
+	ret := &replayTransport{t: t}
+	r := bytes.NewReader(b)
+	read := func() uint32 {
+		var v uint32
+		if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		return v
+	}
+	readBytes := func(n uint32) []byte {
+		ret := make([]byte, n)
+		if _, err := io.ReadFull(r, ret); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		return ret
+	}
+	for r.Len() > 0 {
+		rec := replayRecord{request: read()}
+		rec.reply = readBytes(read())
+		for n := read(); n > 0; n-- {
+			arr := replayArray{ptr: read(), count: read(), size: read()}
+			arr.data = readBytes(read() * arr.size)
+			rec.arrays = append(rec.arrays, arr)
+		}
+		ret.records = append(ret.records, rec)
+	}
+	return ret
+}
+
+func (r *replayTransport) Ioctl(request uint32, arg unsafe.Pointer) error {
+	if len(r.records) == 0 {
+		r.t.Errorf("unexpected ioctl %s", ioctlNames[request])
+		return syscall.EINVAL
+	}
+	rec := r.records[0]
+	r.records = r.records[1:]
+	if request != rec.request {
+		r.t.Errorf("got ioctl %s, want %s", ioctlNames[request], ioctlNames[rec.request])
+		return syscall.EINVAL
+	}
+	b := unsafe.Slice((*byte)(arg), len(rec.reply))
+	r.args = append(r.args, append([]byte(nil), b...))
+
+	ptrs := make([]uint64, len(rec.arrays))
+	counts := make([]uint32, len(rec.arrays))
+	for i, arr := range rec.arrays {
+		ptrs[i] = binary.LittleEndian.Uint64(b[arr.ptr:])
+		counts[i] = binary.LittleEndian.Uint32(b[arr.count:])
+	}
+	copy(b, rec.reply)
+	for i, arr := range rec.arrays {
+		binary.LittleEndian.PutUint64(b[arr.ptr:], ptrs[i])
+		n := uint32(len(arr.data)) / arr.size
+		if counts[i] >= n {
+			copy(fakeSlice[byte](ptrs[i], n*arr.size), arr.data)
+		}
+		binary.LittleEndian.PutUint32(b[arr.count:], n)
+	}
+	return nil
+}
+
+func (r *replayTransport) Read([]byte) (int, error)        { return 0, syscall.EINVAL }
+func (r *replayTransport) Mmap(int64, int) ([]byte, error) { return nil, syscall.EINVAL }
+func (r *replayTransport) Munmap([]byte) error             { return syscall.EINVAL }
+func (r *replayTransport) Close() error                    { return nil }
+
+func testMode(width, height uint16, clock uint32, typ uint32, name string) ModeInfo {
+	ret := ModeInfo{
+		Clock: clock, HDisplay: width, HSyncStart: width + 48, HSyncEnd: width + 80, HTotal: width + 160,
+		VDisplay: height, VSyncStart: height + 3, VSyncEnd: height + 7, VTotal: height + 23,
+		VRefresh: 60, Flags: ModeFlagPHSync | ModeFlagNVSync, Type: typ,
+	}
+	ret.SetName(name)
+	return ret
+}
+
+var (
+	testXGA  = testMode(1024, 768, 56000, ModeTypeDriver|ModeTypePreferred, "1024x768")
+	testSVGA = testMode(800, 600, 35500, ModeTypeDriver, "800x600")
+	testVGA  = testMode(640, 480, 23750, ModeTypeDriver, "640x480")
+)
+
+func TestModeFixtures(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		call    func(*Card) (interface{}, error)
+		want    interface{}
+	}{
+		{
+			// Connectors and encoders are added between the two passes,
+			// so the query is retried.
+			"resources.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetResources() },
+			&ModeResources{
+				FBs: []uint32{}, Crtcs: []uint32{36}, Connectors: []uint32{38, 40}, Encoders: []uint32{37, 39},
+				MinWidth: 20, MaxWidth: 8192, MinHeight: 20, MaxHeight: 8192,
+			},
+		},
+		{
+			"connector.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetConnector(38) },
+			&ModeConnector{
+				ID: 38, EncoderID: 37, Type: ModeConnectorVirtual, TypeID: 1, Connection: ModeConnected, SubPixel: 1,
+				Modes:      []ModeInfo{testXGA, testSVGA, testVGA},
+				Props:      []uint32{1, 2, 5, 6, 20},
+				PropValues: []uint64{0, 0, 0, 0, 36},
+				Encoders:   []uint32{37},
+			},
+		},
+		{
+			"encoder.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetEncoder(37) },
+			&ModeEncoder{ID: 37, Type: ModeEncoderVirtual, CrtcID: 36, PossibleCrtcs: 1, PossibleClones: 1},
+		},
+		{
+			"crtc.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetCrtc(36) },
+			&ModeCrtc{ID: 36, FBID: 42, Mode: &testXGA, GammaSize: 256},
+		},
+		{
+			"crtc_disabled.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetCrtc(36) },
+			&ModeCrtc{ID: 36, GammaSize: 256},
+		},
+		{
+			"plane_resources.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetPlaneResources() },
+			[]uint32{31, 33, 35},
+		},
+		{
+			"plane.bin",
+			func(c *Card) (interface{}, error) { return c.ModeGetPlane(31) },
+			&ModePlane{
+				ID: 31, CrtcID: 36, FBID: 42, PossibleCrtcs: 1,
+				Formats: []uint32{fourcc.XRGB8888, fourcc.XBGR8888, fourcc.ARGB8888, fourcc.ABGR8888},
+			},
+		},
+	} {
+		t.Run(tt.fixture, func(t *testing.T) {
+			replay := loadReplay(t, tt.fixture)
+			got, err := tt.call(NewWithTransport(replay))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+			if len(replay.records) > 0 {
+				t.Errorf("%d replies left over", len(replay.records))
+			}
+		})
+	}
+}
+
+// TestModeGetConnectorNoProbe checks that a mode array is always passed, as the
+// kernel probes connectors queried without one.
+func TestModeGetConnectorNoProbe(t *testing.T) {
+	replay := loadReplay(t, "connector.bin")
+	if _, err := NewWithTransport(replay).ModeGetConnector(38); err != nil {
+		t.Fatal(err)
+	}
+	for i, arg := range replay.args {
+		var conn cModeGetConnector
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&conn)), unsafe.Sizeof(conn)), arg)
+		if conn.modesPtr == 0 || conn.countModes == 0 {
+			t.Errorf("call %d: no mode array passed", i)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package drm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type (
+	cPrimeHandle struct {
+		handle uint32
+		flags  uint32
+		fd     int32
+	}
+
+	cGemClose struct {
+		handle uint32
+		pad    uint32
+	}
+
+	cDmaBufSync struct {
+		flags uint64
+	}
+)
+
+// Flags of an exported dma-buf file descriptor.
+const (
+	PrimeCloexec = uint32(syscall.O_CLOEXEC)
+	PrimeRDWR    = uint32(syscall.O_RDWR)
+)
+
+// Flags of DmaBufSync. Each access is bracketed by a DmaBufSyncStart and a
+// DmaBufSyncEnd call with the same direction.
+const (
+	DmaBufSyncRead  uint64 = 1 << 0
+	DmaBufSyncWrite uint64 = 1 << 1
+	DmaBufSyncRW           = DmaBufSyncRead | DmaBufSyncWrite
+	DmaBufSyncStart uint64 = 0
+	DmaBufSyncEnd   uint64 = 1 << 2
+
+	dmaBufIoctlBase uint8 = 'b'
+)
+
+var (
+	ioctlGemClose        = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(cGemClose{})), ioctlBase, 0x09)
+	ioctlPrimeHandleToFD = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cPrimeHandle{})), ioctlBase, 0x2d)
+	ioctlPrimeFDToHandle = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cPrimeHandle{})), ioctlBase, 0x2e)
+	ioctlDmaBufSync      = ioctlRequest(iocWrite, uint16(unsafe.Sizeof(cDmaBufSync{})), dmaBufIoctlBase, 0x00)
+)
+
+// PrimeHandleToFD exports a buffer object as a dma-buf, which can be passed to
+// another device or process. flags is a combination of PrimeCloexec and
+// PrimeRDWR; PrimeRDWR is needed to map the dma-buf writable.
+func (c *Card) PrimeHandleToFD(handle, flags uint32) (*os.File, error) {
+	prime := cPrimeHandle{handle: handle, flags: flags}
+	if err := c.ioctl(ioctlPrimeHandleToFD, unsafe.Pointer(&prime)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	return os.NewFile(uintptr(prime.fd), "dmabuf"), nil
+}
+
+// PrimeFDToHandle imports a dma-buf as a buffer object of the card. Importing
+// the same dma-buf twice returns the same handle, which must be released with
+// GemClose once.
+func (c *Card) PrimeFDToHandle(f *os.File) (uint32, error) {
+	prime := cPrimeHandle{fd: int32(f.Fd())}
+	if err := c.ioctl(ioctlPrimeFDToHandle, unsafe.Pointer(&prime)); err != nil {
+		return 0, fmt.Errorf("ioctl: %w", err)
+	}
+	return prime.handle, nil
+}
+
+// GemClose releases a buffer object handle. Dumb buffers are released with
+// DestroyDumb instead.
+func (c *Card) GemClose(handle uint32) error {
+	gem := cGemClose{handle: handle}
+	if err := c.ioctl(ioctlGemClose, unsafe.Pointer(&gem)); err != nil {
+		return fmt.Errorf("ioctl: %w", err)
+	}
+	return nil
+}
+
+// DmaBufSync brackets CPU access to a mapped dma-buf, so that caches are kept
+// coherent with the device.
+func DmaBufSync(f *os.File, flags uint64) error {
+	sync := cDmaBufSync{flags: flags}
+	if err := ioctlFile(f, ioctlDmaBufSync, unsafe.Pointer(&sync)); err != nil {
+		return fmt.Errorf("ioctl: %w", ioctlError(ioctlDmaBufSync, err))
+	}
+	return nil
+}
+
+// DmaBufSize returns the size of a dma-buf in bytes.
+func DmaBufSize(f *os.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("dma-buf: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("dma-buf: %w", err)
+	}
+	return size, nil
+}
+
+// ReadDmaBuf maps a dma-buf for reading and calls fn with its contents, inside
+// a read sync. The contents must not be retained after fn returns.
+func ReadDmaBuf(f *os.File, fn func([]byte) error) error {
+	size, err := DmaBufSize(f)
+	if err != nil {
+		return err
+	}
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+	defer syscall.Munmap(b)
+
+	if err := DmaBufSync(f, DmaBufSyncStart|DmaBufSyncRead); err != nil {
+		return err
+	}
+	ferr := fn(b)
+	if err := DmaBufSync(f, DmaBufSyncEnd|DmaBufSyncRead); err != nil && ferr == nil {
+		return err
+	}
+	return ferr
+}
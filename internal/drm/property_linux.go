@@ -0,0 +1,334 @@
+package drm
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+type (
+	// ModeProperty describes a KMS property. The value of a property is held
+	// by each object that has it, see ObjectGetProperties.
+	ModeProperty struct {
+		ID    uint32
+		Name  string
+		Flags uint32
+		// Values are the bounds of a range property, or the object type of an
+		// object property.
+		Values []uint64
+		// Enums are the named values of an enum or bitmask property. For
+		// bitmasks, Value is the bit number.
+		Enums []PropertyEnum
+	}
+
+	PropertyEnum struct {
+		Value uint64
+		Name  string
+	}
+
+	// PropertyType is the type of a property, which defines how its values are
+	// interpreted.
+	PropertyType uint32
+
+	// ObjectProperty is a property of an object with its current value.
+	ObjectProperty struct {
+		*ModeProperty
+		Value uint64
+	}
+
+	// ObjectProperties are the properties of an object.
+	ObjectProperties []ObjectProperty
+
+	cModeGetProperty struct {
+		valuesPtr      uint64
+		enumBlobPtr    uint64
+		propID         uint32
+		flags          uint32
+		name           [propNameLen]byte
+		countValues    uint32
+		countEnumBlobs uint32
+	}
+
+	cModePropertyEnum struct {
+		value uint64
+		name  [propNameLen]byte
+	}
+
+	cModeGetBlob struct {
+		blobID uint32
+		length uint32
+		data   uint64
+	}
+
+	cModeObjGetProperties struct {
+		propsPtr      uint64
+		propValuesPtr uint64
+		countProps    uint32
+		objID         uint32
+		objType       uint32
+	}
+)
+
+const (
+	propNameLen = 32
+
+	PropPending   uint32 = 1 << 0
+	PropImmutable uint32 = 1 << 2
+	PropAtomic    uint32 = 0x80000000
+
+	propLegacyType   uint32 = 1<<1 | 1<<3 | 1<<4 | 1<<5
+	propExtendedType uint32 = 0x0000ffc0
+
+	PropRange       PropertyType = 1 << 1
+	PropEnum        PropertyType = 1 << 3
+	PropBlob        PropertyType = 1 << 4
+	PropBitmask     PropertyType = 1 << 5
+	PropObject      PropertyType = 1 << 6
+	PropSignedRange PropertyType = 2 << 6
+)
+
+const (
+	ModeObjectAny       uint32 = 0
+	ModeObjectCrtc      uint32 = 0xcccccccc
+	ModeObjectConnector uint32 = 0xc0c0c0c0
+	ModeObjectEncoder   uint32 = 0xe0e0e0e0
+	ModeObjectMode      uint32 = 0xdededede
+	ModeObjectProperty  uint32 = 0xb0b0b0b0
+	ModeObjectFB        uint32 = 0xfbfbfbfb
+	ModeObjectBlob      uint32 = 0xbbbbbbbb
+	ModeObjectPlane     uint32 = 0xeeeeeeee
+)
+
+var (
+	ioctlModeGetProperty      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetProperty{})), ioctlBase, 0xaa)
+	ioctlModeGetPropBlob      = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeGetBlob{})), ioctlBase, 0xac)
+	ioctlModeObjGetProperties = ioctlRequest(iocReadWrite, uint16(unsafe.Sizeof(cModeObjGetProperties{})), ioctlBase, 0xb9)
+)
+
+func (c *Card) ModeGetProperty(id uint32) (*ModeProperty, error) {
+	prop := cModeGetProperty{propID: id}
+	if err := c.ioctl(ioctlModeGetProperty, unsafe.Pointer(&prop)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+
+	// Blob properties report a count of enum blobs for legacy reasons, but
+	// their values are only available through the objects that hold them.
+	values := make([]uint64, prop.countValues)
+	var enums []cModePropertyEnum
+	if prop.flags&uint32(PropEnum|PropBitmask) != 0 {
+		enums = make([]cModePropertyEnum, prop.countEnumBlobs)
+	}
+	if len(values) > 0 || len(enums) > 0 {
+		prop.valuesPtr, prop.enumBlobPtr = slicePtr(values), slicePtr(enums)
+		prop.countEnumBlobs = uint32(len(enums))
+		if err := c.ioctl(ioctlModeGetProperty, unsafe.Pointer(&prop)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+	}
+
+	ret := &ModeProperty{
+		ID:     prop.propID,
+		Name:   cToGoString(prop.name[:]),
+		Flags:  prop.flags,
+		Values: values,
+	}
+	if len(enums) > 0 {
+		ret.Enums = make([]PropertyEnum, len(enums))
+		for i, e := range enums {
+			ret.Enums[i] = PropertyEnum{Value: e.value, Name: cToGoString(e.name[:])}
+		}
+	}
+	return ret, nil
+}
+
+// ModeGetPropBlob returns the contents of a blob, e.g. the value of the EDID
+// property of a connector.
+func (c *Card) ModeGetPropBlob(id uint32) ([]byte, error) {
+	blob := cModeGetBlob{blobID: id}
+	if err := c.ioctl(ioctlModeGetPropBlob, unsafe.Pointer(&blob)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	ret := make([]byte, blob.length)
+	if len(ret) == 0 {
+		return ret, nil
+	}
+	blob.data = slicePtr(ret)
+	if err := c.ioctl(ioctlModeGetPropBlob, unsafe.Pointer(&blob)); err != nil {
+		return nil, fmt.Errorf("ioctl: %w", err)
+	}
+	return ret, nil
+}
+
+// ObjectGetProperties returns the properties of a KMS object, such as a CRTC
+// or connector, with their current values. objType is one of the ModeObject
+// constants.
+func (c *Card) ObjectGetProperties(id, objType uint32) (ObjectProperties, error) {
+	var (
+		props  []uint32
+		values []uint64
+	)
+	for {
+		obj := cModeObjGetProperties{objID: id, objType: objType}
+		if err := c.ioctl(ioctlModeObjGetProperties, unsafe.Pointer(&obj)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		count := obj.countProps
+		props, values = make([]uint32, count), make([]uint64, count)
+		obj.propsPtr, obj.propValuesPtr = slicePtr(props), slicePtr(values)
+		if err := c.ioctl(ioctlModeObjGetProperties, unsafe.Pointer(&obj)); err != nil {
+			return nil, fmt.Errorf("ioctl: %w", err)
+		}
+		if obj.countProps <= count {
+			props, values = props[:obj.countProps], values[:obj.countProps]
+			break
+		}
+	}
+
+	ret := make(ObjectProperties, len(props))
+	for i, id := range props {
+		prop, err := c.ModeGetProperty(id)
+		if err != nil {
+			return nil, fmt.Errorf("property %d: %w", id, err)
+		}
+		ret[i] = ObjectProperty{ModeProperty: prop, Value: values[i]}
+	}
+	return ret, nil
+}
+
+// Lookup returns the property with the given name.
+func (p ObjectProperties) Lookup(name string) (ObjectProperty, bool) {
+	for _, prop := range p {
+		if prop.Name == name {
+			return prop, true
+		}
+	}
+	return ObjectProperty{}, false
+}
+
+// Type returns the type of the property, from either the legacy or extended
+// type flags.
+func (p *ModeProperty) Type() PropertyType {
+	if t := p.Flags & propLegacyType; t != 0 {
+		return PropertyType(t)
+	}
+	return PropertyType(p.Flags & propExtendedType)
+}
+
+func (p *ModeProperty) Immutable() bool {
+	return p.Flags&PropImmutable != 0
+}
+
+// Atomic reports whether the property is only exposed to atomic clients.
+func (p *ModeProperty) Atomic() bool {
+	return p.Flags&PropAtomic != 0
+}
+
+// EnumName returns the name of value for an enum property.
+func (p *ModeProperty) EnumName(value uint64) (string, bool) {
+	for _, e := range p.Enums {
+		if e.Value == value {
+			return e.Name, true
+		}
+	}
+	return "", false
+}
+
+// EnumValue returns the value of the named enum, or for a bitmask property,
+// the mask of the named bit.
+func (p *ModeProperty) EnumValue(name string) (uint64, bool) {
+	for _, e := range p.Enums {
+		if e.Name == name {
+			if p.Type() == PropBitmask {
+				return 1 << e.Value, true
+			}
+			return e.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Int returns the value of a signed range property.
+func (p ObjectProperty) Int() int64 {
+	return int64(p.Value)
+}
+
+// Enum returns the name of the value of an enum property.
+func (p ObjectProperty) Enum() (string, error) {
+	name, ok := p.EnumName(p.Value)
+	if !ok {
+		return "", fmt.Errorf("%s: unknown enum value %d", p.Name, p.Value)
+	}
+	return name, nil
+}
+
+// Bits returns the names of the bits set in a bitmask property. Bits without a
+// name are ignored.
+func (p ObjectProperty) Bits() []string {
+	var ret []string
+	for _, e := range p.Enums {
+		if e.Value < 64 && p.Value&(1<<e.Value) != 0 {
+			ret = append(ret, e.Name)
+		}
+	}
+	return ret
+}
+
+// BlobID returns the blob of a blob property, zero if it has no blob.
+func (p ObjectProperty) BlobID() uint32 {
+	return uint32(p.Value)
+}
+
+// ObjectID returns the object referenced by an object property, zero if none.
+func (p ObjectProperty) ObjectID() uint32 {
+	return uint32(p.Value)
+}
+
+// Decode returns the value of the property as a Go value: uint64 for ranges,
+// int64 for signed ranges, a string for enums, a []string for bitmasks, and a
+// uint32 blob or object ID.
+func (p ObjectProperty) Decode() (interface{}, error) {
+	switch p.Type() {
+	case PropRange:
+		return p.Value, nil
+	case PropSignedRange:
+		return p.Int(), nil
+	case PropEnum:
+		return p.Enum()
+	case PropBitmask:
+		return p.Bits(), nil
+	case PropBlob:
+		return p.BlobID(), nil
+	case PropObject:
+		return p.ObjectID(), nil
+	}
+	return nil, fmt.Errorf("%s: unknown property type %#x", p.Name, p.Flags)
+}
+
+func (p ObjectProperty) String() string {
+	value, err := p.Decode()
+	if err != nil {
+		return fmt.Sprintf("%s=%d", p.Name, p.Value)
+	}
+	if bits, ok := value.([]string); ok {
+		return fmt.Sprintf("%s=%s", p.Name, strings.Join(bits, "|"))
+	}
+	return fmt.Sprintf("%s=%v", p.Name, value)
+}
+
+func (t PropertyType) String() string {
+	switch t {
+	case PropRange:
+		return "range"
+	case PropEnum:
+		return "enum"
+	case PropBlob:
+		return "blob"
+	case PropBitmask:
+		return "bitmask"
+	case PropObject:
+		return "object"
+	case PropSignedRange:
+		return "signed range"
+	}
+	return fmt.Sprintf("PropertyType(%#x)", uint32(t))
+}
@@ -0,0 +1,183 @@
+package drm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+var recordCard = flag.String("record", "", "record the fixtures of TestModeFixtures from the card at this path")
+
+// recordTransport performs the ioctls of a card and writes them out in the
+// fixture format read by loadReplay. Failed ioctls are not recorded.
+type recordTransport struct {
+	Transport
+	buf bytes.Buffer
+}
+
+// replyArrays are the arrays that the kernel copies out for each request.
+var replyArrays = map[uint32][]replayArray{
+	ioctlModeGetResources: {
+		{uint32(unsafe.Offsetof(cModeCardRes{}.fbIDPtr)), uint32(unsafe.Offsetof(cModeCardRes{}.countFBs)), 4, nil},
+		{uint32(unsafe.Offsetof(cModeCardRes{}.crtcIDPtr)), uint32(unsafe.Offsetof(cModeCardRes{}.countCrtcs)), 4, nil},
+		{uint32(unsafe.Offsetof(cModeCardRes{}.connectorIDPtr)), uint32(unsafe.Offsetof(cModeCardRes{}.countConnectors)), 4, nil},
+		{uint32(unsafe.Offsetof(cModeCardRes{}.encoderIDPtr)), uint32(unsafe.Offsetof(cModeCardRes{}.countEncoders)), 4, nil},
+	},
+	ioctlModeGetConnector: {
+		{uint32(unsafe.Offsetof(cModeGetConnector{}.encodersPtr)), uint32(unsafe.Offsetof(cModeGetConnector{}.countEncoders)), 4, nil},
+		{uint32(unsafe.Offsetof(cModeGetConnector{}.modesPtr)), uint32(unsafe.Offsetof(cModeGetConnector{}.countModes)), uint32(unsafe.Sizeof(ModeInfo{})), nil},
+		{uint32(unsafe.Offsetof(cModeGetConnector{}.propsPtr)), uint32(unsafe.Offsetof(cModeGetConnector{}.countProps)), 4, nil},
+		{uint32(unsafe.Offsetof(cModeGetConnector{}.propValuesPtr)), uint32(unsafe.Offsetof(cModeGetConnector{}.countProps)), 8, nil},
+	},
+	ioctlModeGetPlaneResources: {
+		{uint32(unsafe.Offsetof(cModeGetPlaneRes{}.planeIDPtr)), uint32(unsafe.Offsetof(cModeGetPlaneRes{}.countPlanes)), 4, nil},
+	},
+	ioctlModeGetPlane: {
+		{uint32(unsafe.Offsetof(cModeGetPlane{}.formatTypePtr)), uint32(unsafe.Offsetof(cModeGetPlane{}.countFormatTypes)), 4, nil},
+	},
+}
+
+func (r *recordTransport) Ioctl(request uint32, arg unsafe.Pointer) error {
+	arrays := replyArrays[request]
+	b := unsafe.Slice((*byte)(arg), request>>iocSizeShift&iocSizeMask)
+	room := make([]uint32, len(arrays))
+	for i, arr := range arrays {
+		room[i] = binary.LittleEndian.Uint32(b[arr.count:])
+	}
+	if err := r.Transport.Ioctl(request, arg); err != nil {
+		return err
+	}
+
+	write := func(v uint32) { binary.Write(&r.buf, binary.LittleEndian, v) }
+	write(request)
+	write(uint32(len(b)))
+	r.buf.Write(b)
+	write(uint32(len(arrays)))
+	for i, arr := range arrays {
+		// Arrays that the caller made no room for were not copied, but
+		// their length is what the replay reports.
+		n := binary.LittleEndian.Uint32(b[arr.count:])
+		data := make([]byte, n*arr.size)
+		if ptr := binary.LittleEndian.Uint64(b[arr.ptr:]); ptr != 0 && room[i] >= n {
+			copy(data, fakeSlice[byte](ptr, n*arr.size))
+		}
+		write(arr.ptr)
+		write(arr.count)
+		write(arr.size)
+		write(n)
+		r.buf.Write(data)
+	}
+	return nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	rec := &recordTransport{Transport: NewFakeVKMS(testXGA)}
+	c := NewWithTransport(rec)
+	if err := c.SetClientCap(ClientCapAtomic, 1); err != nil {
+		t.Fatal(err)
+	}
+	crtc, plane, connectors := fakeVKMSPipe(t, c)
+	rec.buf.Reset()
+
+	calls := []func(*Card) (interface{}, error){
+		func(c *Card) (interface{}, error) { return c.ModeGetResources() },
+		func(c *Card) (interface{}, error) { return c.ModeGetConnector(connectors[0].ID) },
+		func(c *Card) (interface{}, error) { return c.ModeGetEncoder(connectors[0].Encoders[0]) },
+		func(c *Card) (interface{}, error) { return c.ModeGetCrtc(crtc) },
+		func(c *Card) (interface{}, error) { return c.ModeGetPlaneResources() },
+		func(c *Card) (interface{}, error) { return c.ModeGetPlane(plane) },
+	}
+	var want []interface{}
+	for _, call := range calls {
+		v, err := call(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, v)
+	}
+
+	replay := parseReplay(t, "recording", rec.buf.Bytes())
+	for i, call := range calls {
+		got, err := call(NewWithTransport(replay))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("call %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+	if len(replay.records) > 0 {
+		t.Errorf("%d replies left over", len(replay.records))
+	}
+}
+
+// TestRecordFixtures rewrites the fixtures in testdata from a card, see
+// testdata/README. It only runs with -record.
+func TestRecordFixtures(t *testing.T) {
+	if *recordCard == "" {
+		t.Skip("no card to record, set -record")
+	}
+	f, err := os.OpenFile(*recordCard, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordTransport{Transport: &fileTransport{f}}
+	c := NewWithTransport(rec)
+	defer c.Close()
+
+	record := func(name string, call func() (interface{}, error)) {
+		rec.buf.Reset()
+		v, err := call()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := os.WriteFile(filepath.Join("testdata", name), rec.buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// The expectations of TestModeFixtures are updated from these.
+		t.Logf("%s: %#v", name, v)
+	}
+
+	res, err := c.ModeGetResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Connectors) == 0 || len(res.Crtcs) == 0 {
+		t.Fatalf("%s has no connectors or CRTCs", *recordCard)
+	}
+	conn, err := c.ModeGetConnector(res.Connectors[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.Encoders) == 0 {
+		t.Fatalf("connector %d has no encoders", conn.ID)
+	}
+	crtc, err := c.ModeGetCrtc(res.Crtcs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetClientCap(ClientCapUniversalPlanes, 1); err != nil {
+		t.Fatal(err)
+	}
+	plane, err := c.PrimaryPlane(0)
+	if err != nil || plane == 0 {
+		t.Fatalf("no primary plane: %v", err)
+	}
+
+	record("resources.bin", func() (interface{}, error) { return c.ModeGetResources() })
+	record("connector.bin", func() (interface{}, error) { return c.ModeGetConnector(conn.ID) })
+	record("encoder.bin", func() (interface{}, error) { return c.ModeGetEncoder(conn.Encoders[0]) })
+	// The CRTC is recorded as it is, run again with the other state.
+	crtcFixture := "crtc.bin"
+	if crtc.Mode == nil {
+		crtcFixture = "crtc_disabled.bin"
+	}
+	record(crtcFixture, func() (interface{}, error) { return c.ModeGetCrtc(crtc.ID) })
+	record("plane_resources.bin", func() (interface{}, error) { return c.ModeGetPlaneResources() })
+	record("plane.bin", func() (interface{}, error) { return c.ModeGetPlane(plane) })
+}
@@ -0,0 +1,174 @@
+package drm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// SysfsDevice is a DRM device as described by sysfs, which doesn't require
+	// opening it.
+	SysfsDevice struct {
+		// Name is the name of the primary node, e.g. card0.
+		Name string
+		// Driver is the kernel driver bound to the parent device, empty if
+		// none is, as for vkms.
+		Driver string
+		// Parent is the name of the parent device, e.g. 0000:00:02.0, or the
+		// name of a vkms device.
+		Parent      string
+		PrimaryNode string
+		// RenderNode is empty if the device has no render node.
+		RenderNode string
+		Connectors []SysfsConnector
+	}
+
+	SysfsConnector struct {
+		// Name is the sysfs name of the connector, e.g. card0-Virtual-1.
+		Name string
+		// ID is the KMS object ID of the connector, zero on kernels that don't
+		// report it.
+		ID uint32
+		// Status is one of connected, disconnected or unknown.
+		Status  string
+		Enabled bool
+		// Modes are the names of the probed modes, e.g. 1920x1080.
+		Modes []string
+		EDID  []byte
+	}
+)
+
+const (
+	// SysfsDir is the sysfs class directory of DRM devices.
+	SysfsDir = "/sys/class/drm"
+	devDir   = "/dev"
+	driDir   = "/dev/dri"
+)
+
+// SysfsDevices lists the DRM devices under a sysfs class directory, usually
+// SysfsDir, ordered by name. Render-only devices are not listed.
+func SysfsDevices(root string) ([]SysfsDevice, error) {
+	cards, err := filepath.Glob(filepath.Join(root, "card[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	var ret []SysfsDevice
+	for _, card := range cards {
+		// Connectors are also listed as card0-Virtual-1.
+		if strings.Contains(filepath.Base(card), "-") {
+			continue
+		}
+		dev, err := readSysfsDevice(card)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(card), err)
+		}
+		ret = append(ret, *dev)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+func readSysfsDevice(dir string) (*SysfsDevice, error) {
+	ret := &SysfsDevice{Name: filepath.Base(dir)}
+	ret.PrimaryNode = sysfsDevNode(dir)
+
+	if parent, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil {
+		ret.Parent = filepath.Base(parent)
+	}
+	if driver, err := filepath.EvalSymlinks(filepath.Join(dir, "device", "driver")); err == nil {
+		ret.Driver = filepath.Base(driver)
+	} else {
+		ret.Driver = readUeventFile(filepath.Join(dir, "device", "uevent"))["DRIVER"]
+	}
+
+	// Nodes of the same device are siblings under the parent.
+	renders, _ := filepath.Glob(filepath.Join(dir, "device", "drm", "renderD[0-9]*"))
+	if len(renders) > 0 {
+		ret.RenderNode = sysfsDevNode(renders[0])
+	}
+
+	connectors, err := filepath.Glob(filepath.Join(dir, ret.Name+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, connector := range connectors {
+		c, err := readSysfsConnector(connector)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(connector), err)
+		}
+		ret.Connectors = append(ret.Connectors, *c)
+	}
+	return ret, nil
+}
+
+func readSysfsConnector(dir string) (*SysfsConnector, error) {
+	ret := &SysfsConnector{Name: filepath.Base(dir)}
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	ret.Status = strings.TrimSpace(string(status))
+	if enabled, err := os.ReadFile(filepath.Join(dir, "enabled")); err == nil {
+		ret.Enabled = strings.TrimSpace(string(enabled)) == "enabled"
+	}
+	if id, err := os.ReadFile(filepath.Join(dir, "connector_id")); err == nil {
+		if id, err := strconv.ParseUint(strings.TrimSpace(string(id)), 10, 32); err == nil {
+			ret.ID = uint32(id)
+		}
+	}
+	if modes, err := os.ReadFile(filepath.Join(dir, "modes")); err == nil {
+		ret.Modes = strings.Fields(string(modes))
+	}
+	// The EDID file exists but is empty when there is no EDID.
+	if edid, err := os.ReadFile(filepath.Join(dir, "edid")); err == nil && len(edid) > 0 {
+		ret.EDID = edid
+	}
+	return ret, nil
+}
+
+// sysfsDevNode returns the device node of a sysfs DRM node, according to its
+// uevent, or the conventional path.
+func sysfsDevNode(dir string) string {
+	if name, ok := readUeventFile(filepath.Join(dir, "uevent"))["DEVNAME"]; ok {
+		return filepath.Join(devDir, name)
+	}
+	return filepath.Join(driDir, filepath.Base(dir))
+}
+
+// readUeventFile reads the KEY=value lines of a sysfs uevent file, returning
+// an empty map if it can't be read.
+func readUeventFile(path string) map[string]string {
+	ret := make(map[string]string)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ret
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if kv := strings.SplitN(s.Text(), "=", 2); len(kv) == 2 {
+			ret[kv[0]] = kv[1]
+		}
+	}
+	return ret
+}
+
+// Connected reports whether the connector has a sink attached.
+func (c *SysfsConnector) Connected() bool {
+	return c.Status == "connected"
+}
+
+// TypeName returns the connector type part of the connector name, e.g.
+// Writeback for card0-Writeback-1, as returned by ConnectorTypeName.
+func (c *SysfsConnector) TypeName() string {
+	first, last := strings.Index(c.Name, "-"), strings.LastIndex(c.Name, "-")
+	if first < 0 || last <= first {
+		return ""
+	}
+	return c.Name[first+1 : last]
+}
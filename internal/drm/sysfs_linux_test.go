@@ -0,0 +1,85 @@
+package drm
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The tree in testdata/sysfs mimics /sys: class/drm links to the nodes under
+// devices, an i915 card with a render node and two connectors, and a vkms card
+// without a driver or render node, with a writeback connector.
+func TestSysfsDevices(t *testing.T) {
+	edid := append([]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}, make([]byte, 120)...)
+	want := []SysfsDevice{
+		{
+			Name:        "card0",
+			Driver:      "i915",
+			Parent:      "0000-00-02.0",
+			PrimaryNode: "/dev/dri/card0",
+			RenderNode:  "/dev/dri/renderD128",
+			Connectors: []SysfsConnector{
+				{Name: "card0-DP-1", Status: "disconnected", Modes: []string{}},
+				{
+					Name: "card0-eDP-1", ID: 95, Status: "connected", Enabled: true,
+					Modes: []string{"1920x1080", "1920x1080", "1280x720"}, EDID: edid,
+				},
+			},
+		},
+		{
+			Name:        "card1",
+			Parent:      "vkms",
+			PrimaryNode: "/dev/dri/card1",
+			Connectors: []SysfsConnector{
+				{Name: "card1-Virtual-1", Status: "connected", Enabled: true, Modes: []string{"1024x768", "800x600", "640x480"}},
+				{Name: "card1-Writeback-1", Status: "unknown", Modes: []string{}},
+			},
+		},
+	}
+
+	got, err := SysfsDevices(filepath.Join("testdata", "sysfs", "class", "drm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	if !got[0].Connectors[1].Connected() || got[0].Connectors[0].Connected() {
+		t.Errorf("wrong connection status")
+	}
+}
+
+func TestSysfsDevicesEmpty(t *testing.T) {
+	got, err := SysfsDevices(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d devices, want none", len(got))
+	}
+}
+
+func TestReadUeventFile(t *testing.T) {
+	got := readUeventFile(filepath.Join("testdata", "sysfs", "devices", "pci0", "0000-00-02.0", "uevent"))
+	want := map[string]string{"DRIVER": "i915", "PCI_ID": "8086:5916"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := readUeventFile(filepath.Join("testdata", "sysfs", "missing")); len(got) != 0 {
+		t.Errorf("got %v for a missing file", got)
+	}
+}
+
+func TestSysfsConnectorTypeName(t *testing.T) {
+	for name, want := range map[string]string{
+		"card0-eDP-1":       "eDP",
+		"card0-HDMI-A-2":    "HDMI-A",
+		"card1-Writeback-1": ConnectorTypeName(ModeConnectorWriteback),
+		"card0":             "",
+	} {
+		c := SysfsConnector{Name: name}
+		if got := c.TypeName(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
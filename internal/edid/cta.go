@@ -0,0 +1,158 @@
+package edid
+
+import "fmt"
+
+// CTA is a CTA-861 extension block, revision 3.
+type CTA struct {
+	Revision   byte
+	Underscan  bool
+	BasicAudio bool
+	YCbCr444   bool
+	YCbCr422   bool
+	// NativeFormats is the number of detailed timings that are native formats
+	// of the display.
+	NativeFormats int
+
+	// VideoCodes are the short video descriptors of the video data block.
+	VideoCodes []VideoCode
+	// DataBlocks are the data blocks other than the video data block.
+	DataBlocks      []DataBlock
+	DetailedTimings []DetailedTiming
+}
+
+// VideoCode is a short video descriptor, referencing a CTA-861 video format by
+// its video identification code.
+type VideoCode struct {
+	VIC    byte
+	Native bool
+}
+
+// DataBlock is a raw data block. For extended tags, the extended tag code is
+// the first byte of Data.
+type DataBlock struct {
+	Tag  byte
+	Data []byte
+}
+
+const (
+	ctaTag      byte = 0x02
+	ctaRevision byte = 0x03
+
+	ctaDataBlockVideo  byte = 0x02
+	ctaDataBlockBase        = 4
+	ctaDataBlockMaxLen      = 0x1f
+)
+
+func (c *CTA) UnmarshalBinary(b []byte) error {
+	if len(b) != blockSize || b[0] != ctaTag {
+		return fmt.Errorf("%w: not a CTA-861 block", ErrInvalid)
+	}
+	*c = CTA{
+		Revision:      b[1],
+		Underscan:     b[3]&0x80 != 0,
+		BasicAudio:    b[3]&0x40 != 0,
+		YCbCr444:      b[3]&0x20 != 0,
+		YCbCr422:      b[3]&0x10 != 0,
+		NativeFormats: int(b[3] & 0x0f),
+	}
+
+	dtdOffset := int(b[2])
+	if dtdOffset == 0 {
+		return nil
+	}
+	if dtdOffset < ctaDataBlockBase || dtdOffset > blockSize-1 {
+		return fmt.Errorf("%w: CTA-861 DTD offset %d", ErrInvalid, dtdOffset)
+	}
+	for i := ctaDataBlockBase; i < dtdOffset; {
+		tag, length := b[i]>>5, int(b[i]&0x1f)
+		if i+1+length > dtdOffset {
+			return fmt.Errorf("%w: CTA-861 data block overruns DTDs", ErrInvalid)
+		}
+		data := b[i+1 : i+1+length]
+		if tag == ctaDataBlockVideo {
+			for _, svd := range data {
+				c.VideoCodes = append(c.VideoCodes, decodeVideoCode(svd))
+			}
+		} else {
+			c.DataBlocks = append(c.DataBlocks, DataBlock{Tag: tag, Data: append([]byte(nil), data...)})
+		}
+		i += 1 + length
+	}
+	for i := dtdOffset; i+descriptorSize <= blockSize-1; i += descriptorSize {
+		d := b[i : i+descriptorSize]
+		if d[0] == 0 && d[1] == 0 {
+			break
+		}
+		c.DetailedTimings = append(c.DetailedTimings, decodeDetailedTiming(d))
+	}
+	return nil
+}
+
+func (c *CTA) MarshalBinary() ([]byte, error) {
+	b := make([]byte, blockSize)
+	b[0] = ctaTag
+	b[1] = c.Revision
+	if b[1] == 0 {
+		b[1] = ctaRevision
+	}
+	if c.NativeFormats > 0x0f {
+		return nil, fmt.Errorf("%w: %d native formats", ErrTooLarge, c.NativeFormats)
+	}
+	b[3] = byte(c.NativeFormats)
+	for bit, set := range map[byte]bool{0x80: c.Underscan, 0x40: c.BasicAudio, 0x20: c.YCbCr444, 0x10: c.YCbCr422} {
+		if set {
+			b[3] |= bit
+		}
+	}
+
+	blocks := c.DataBlocks
+	if len(c.VideoCodes) > 0 {
+		svds := make([]byte, len(c.VideoCodes))
+		for i, vc := range c.VideoCodes {
+			svds[i] = vc.encode()
+		}
+		blocks = append([]DataBlock{{Tag: ctaDataBlockVideo, Data: svds}}, blocks...)
+	}
+	i := ctaDataBlockBase
+	for _, block := range blocks {
+		if len(block.Data) > ctaDataBlockMaxLen || block.Tag > 0x07 {
+			return nil, fmt.Errorf("%w: CTA-861 data block tag %d", ErrTooLarge, block.Tag)
+		}
+		if i+1+len(block.Data) > blockSize-1 {
+			return nil, fmt.Errorf("%w: CTA-861 data blocks", ErrTooLarge)
+		}
+		b[i] = block.Tag<<5 | byte(len(block.Data))
+		i += 1 + copy(b[i+1:], block.Data)
+	}
+
+	if len(blocks) > 0 || len(c.DetailedTimings) > 0 {
+		b[2] = byte(i)
+	}
+	for _, dt := range c.DetailedTimings {
+		if i+descriptorSize > blockSize-1 {
+			return nil, fmt.Errorf("%w: CTA-861 detailed timings", ErrTooLarge)
+		}
+		if err := dt.encode(b[i : i+descriptorSize]); err != nil {
+			return nil, err
+		}
+		i += descriptorSize
+	}
+	b[blockSize-1] = -checksum(b[:blockSize-1])
+	return b, nil
+}
+
+// VICs 129-192 are VICs 1-64 with the native bit set. Codes above 192 are
+// plain VICs added in CTA-861-F.
+func decodeVideoCode(svd byte) VideoCode {
+	if svd >= 129 && svd <= 192 {
+		return VideoCode{VIC: svd & 0x7f, Native: true}
+	}
+	return VideoCode{VIC: svd}
+}
+
+func (vc VideoCode) encode() byte {
+	if vc.Native && vc.VIC >= 1 && vc.VIC <= 64 {
+		return vc.VIC | 0x80
+	}
+	return vc.VIC
+}
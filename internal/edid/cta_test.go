@@ -0,0 +1,85 @@
+package edid
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCTA(t *testing.T) {
+	cta := CTA{
+		BasicAudio:    true,
+		YCbCr444:      true,
+		NativeFormats: 1,
+		VideoCodes:    []VideoCode{{VIC: 16, Native: true}, {VIC: 4}, {VIC: 193}},
+		// Short audio descriptor: 2 channel LPCM at 32, 44.1 and 48 kHz, 16,
+		// 20 and 24 bits.
+		DataBlocks:      []DataBlock{{Tag: 1, Data: []byte{0x09, 0x07, 0x07}}},
+		DetailedTimings: []DetailedTiming{cvt1080},
+	}
+	b, err := cta.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != blockSize || checksum(b) != 0 {
+		t.Fatalf("got a %d byte block summing to %#x", len(b), checksum(b))
+	}
+
+	want := []byte{
+		0x02, 0x03, // tag, revision 3
+		0x0c,                   // DTDs after 4 + 4 + 4 bytes of data blocks
+		0x61,                   // basic audio, YCbCr 4:4:4, 1 native format
+		0x43, 0x90, 0x04, 0xc1, // video data block, VIC 16 native, VIC 4, VIC 193
+		0x23, 0x09, 0x07, 0x07, // audio data block
+	}
+	want = append(want, cvt1080DTD...)
+	if !bytes.Equal(b[:len(want)], want) {
+		t.Errorf("got % x, want % x", b[:len(want)], want)
+	}
+	if tail := b[len(want) : blockSize-1]; !bytes.Equal(tail, make([]byte, len(tail))) {
+		t.Errorf("padding is not zero: % x", tail)
+	}
+
+	var got CTA
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	cta.Revision = ctaRevision
+	if !reflect.DeepEqual(got, cta) {
+		t.Errorf("got %+v, want %+v", got, cta)
+	}
+}
+
+func TestCTAEmpty(t *testing.T) {
+	b, err := (&CTA{}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without data blocks or DTDs, the DTD offset is zero.
+	if !bytes.Equal(b[:4], []byte{ctaTag, ctaRevision, 0, 0}) || checksum(b) != 0 {
+		t.Errorf("got % x", b)
+	}
+}
+
+func TestCTAErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cta  CTA
+	}{
+		{"native formats", CTA{NativeFormats: 16}},
+		{"data block length", CTA{DataBlocks: []DataBlock{{Tag: 1, Data: make([]byte, 32)}}}},
+		{"data block tag", CTA{DataBlocks: []DataBlock{{Tag: 8}}}},
+		{"detailed timings", CTA{DetailedTimings: []DetailedTiming{cvt1080, cvt1080, cvt1080, cvt1080, cvt1080, cvt1080, cvt1080}}},
+	} {
+		if _, err := tt.cta.MarshalBinary(); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: got %v, want ErrTooLarge", tt.name, err)
+		}
+	}
+
+	b, _ := (&CTA{DetailedTimings: []DetailedTiming{cvt1080}}).MarshalBinary()
+	b[2] = 2
+	if err := new(CTA).UnmarshalBinary(b); !errors.Is(err, ErrInvalid) {
+		t.Errorf("DTD offset 2: got %v, want ErrInvalid", err)
+	}
+}
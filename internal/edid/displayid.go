@@ -0,0 +1,205 @@
+package edid
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DisplayID is a DisplayID section carried in an EDID extension block. Only
+// timing data blocks are decoded, which is what allows describing modes that
+// are too large for a detailed timing descriptor.
+type DisplayID struct {
+	// Version is the DisplayID structure version, e.g. 0x13 or 0x20. Timings
+	// are encoded as Type I timings before 2.0, and Type VII from 2.0.
+	Version     byte
+	ProductType byte
+	// Timings are the detailed timings of the section. The first is marked
+	// as preferred when encoding.
+	Timings []DetailedTiming
+	// DataBlocks are the data blocks other than detailed timings. For
+	// DisplayID, the block revision is the first byte of Data.
+	DataBlocks []DataBlock
+}
+
+const (
+	displayIDTag          byte = 0x70
+	displayIDVersion13    byte = 0x13
+	displayIDVersion20    byte = 0x20
+	displayIDTypeI        byte = 0x03
+	displayIDTypeVII      byte = 0x22
+	displayIDTimingSize        = 20
+	displayIDSectionBase       = 5
+	displayIDMaxPayload        = blockSize - displayIDSectionBase - 2
+	displayIDAspectNone   byte = 0x08
+	displayIDPreferred    byte = 0x80
+	displayIDInterlaced   byte = 0x10
+	displayIDSyncPositive      = 0x8000
+)
+
+func (d *DisplayID) UnmarshalBinary(b []byte) error {
+	if len(b) != blockSize || b[0] != displayIDTag {
+		return fmt.Errorf("%w: not a DisplayID block", ErrInvalid)
+	}
+	section := b[1:]
+	length := int(section[1])
+	if length > displayIDMaxPayload {
+		return fmt.Errorf("%w: DisplayID section length %d", ErrInvalid, length)
+	}
+	if checksum(section[:4+length+1]) != 0 {
+		return fmt.Errorf("%w: DisplayID section", ErrChecksum)
+	}
+	*d = DisplayID{Version: section[0], ProductType: section[2]}
+
+	payload := section[4 : 4+length]
+	for i := 0; i+3 <= len(payload); {
+		tag, revision, n := payload[i], payload[i+1], int(payload[i+2])
+		if tag == 0 && revision == 0 && n == 0 {
+			break
+		}
+		if i+3+n > len(payload) {
+			return fmt.Errorf("%w: DisplayID data block overruns section", ErrInvalid)
+		}
+		data := payload[i+3 : i+3+n]
+		switch tag {
+		case displayIDTypeI, displayIDTypeVII:
+			for j := 0; j+displayIDTimingSize <= len(data); j += displayIDTimingSize {
+				d.Timings = append(d.Timings, decodeDisplayIDTiming(data[j:j+displayIDTimingSize], tag == displayIDTypeVII))
+			}
+		default:
+			d.DataBlocks = append(d.DataBlocks, DataBlock{Tag: tag, Data: append([]byte{revision}, data...)})
+		}
+		i += 3 + n
+	}
+	return nil
+}
+
+func (d *DisplayID) MarshalBinary() ([]byte, error) {
+	version := d.Version
+	if version == 0 {
+		version = displayIDVersion13
+	}
+	tag := displayIDTypeI
+	if version >= displayIDVersion20 {
+		tag = displayIDTypeVII
+	}
+
+	var payload []byte
+	if len(d.Timings) > 0 {
+		timings := make([]byte, 0, len(d.Timings)*displayIDTimingSize)
+		for i, dt := range d.Timings {
+			t, err := encodeDisplayIDTiming(dt, i == 0, tag == displayIDTypeVII)
+			if err != nil {
+				return nil, err
+			}
+			timings = append(timings, t...)
+		}
+		if len(timings) > 0xff {
+			return nil, fmt.Errorf("%w: %d DisplayID timings", ErrTooLarge, len(d.Timings))
+		}
+		payload = append(payload, tag, 0, byte(len(timings)))
+		payload = append(payload, timings...)
+	}
+	for _, block := range d.DataBlocks {
+		if len(block.Data) < 1 || len(block.Data)-1 > 0xff {
+			return nil, fmt.Errorf("%w: DisplayID data block tag %#x", ErrInvalid, block.Tag)
+		}
+		payload = append(payload, block.Tag, block.Data[0], byte(len(block.Data)-1))
+		payload = append(payload, block.Data[1:]...)
+	}
+	if len(payload) > displayIDMaxPayload {
+		return nil, fmt.Errorf("%w: DisplayID section of %d bytes", ErrTooLarge, len(payload))
+	}
+
+	b := make([]byte, blockSize)
+	b[0] = displayIDTag
+	section := b[1:]
+	section[0], section[1], section[2], section[3] = version, byte(len(payload)), d.ProductType, 0
+	copy(section[4:], payload)
+	section[4+len(payload)] = -checksum(section[:4+len(payload)])
+	b[blockSize-1] = -checksum(b[:blockSize-1])
+	return b, nil
+}
+
+// Type I timings have a pixel clock in 10 kHz units, Type VII in kHz. All other
+// fields are stored minus one.
+func decodeDisplayIDTiming(t []byte, typeVII bool) DetailedTiming {
+	field := func(i int) int {
+		return int(binary.LittleEndian.Uint16(t[i:])&0x7fff) + 1
+	}
+	clock := uint32(t[0]) | uint32(t[1])<<8 | uint32(t[2])<<16
+	clock++
+	if !typeVII {
+		clock *= 10
+	}
+	hActive, hBlank, hFront, hSync := field(4), field(6), field(8), field(10)
+	vActive, vBlank, vFront, vSync := field(12), field(14), field(16), field(18)
+	return DetailedTiming{
+		PixelClock:    clock,
+		HActive:       hActive,
+		HSyncStart:    hActive + hFront,
+		HSyncEnd:      hActive + hFront + hSync,
+		HTotal:        hActive + hBlank,
+		VActive:       vActive,
+		VSyncStart:    vActive + vFront,
+		VSyncEnd:      vActive + vFront + vSync,
+		VTotal:        vActive + vBlank,
+		Interlaced:    t[3]&displayIDInterlaced != 0,
+		HSyncPositive: binary.LittleEndian.Uint16(t[8:])&displayIDSyncPositive != 0,
+		VSyncPositive: binary.LittleEndian.Uint16(t[16:])&displayIDSyncPositive != 0,
+	}
+}
+
+func encodeDisplayIDTiming(dt DetailedTiming, preferred, typeVII bool) ([]byte, error) {
+	t := make([]byte, displayIDTimingSize)
+	clock := dt.PixelClock
+	if !typeVII {
+		clock /= 10
+	}
+	if clock == 0 || clock-1 > 0xffffff {
+		return nil, fmt.Errorf("%w: DisplayID pixel clock %d kHz", ErrTooLarge, dt.PixelClock)
+	}
+	clock--
+	t[0], t[1], t[2] = byte(clock), byte(clock>>8), byte(clock>>16)
+
+	t[3] = displayIDAspect(dt.HActive, dt.VActive)
+	if preferred {
+		t[3] |= displayIDPreferred
+	}
+	if dt.Interlaced {
+		t[3] |= displayIDInterlaced
+	}
+
+	fields := []struct {
+		offset, value int
+		positive      bool
+	}{
+		{4, dt.HActive, false},
+		{6, dt.HTotal - dt.HActive, false},
+		{8, dt.HSyncStart - dt.HActive, dt.HSyncPositive},
+		{10, dt.HSyncEnd - dt.HSyncStart, false},
+		{12, dt.VActive, false},
+		{14, dt.VTotal - dt.VActive, false},
+		{16, dt.VSyncStart - dt.VActive, dt.VSyncPositive},
+		{18, dt.VSyncEnd - dt.VSyncStart, false},
+	}
+	for _, f := range fields {
+		if f.value < 1 || f.value-1 > 0x7fff {
+			return nil, fmt.Errorf("%w: DisplayID timing %dx%d", ErrTooLarge, dt.HActive, dt.VActive)
+		}
+		v := uint16(f.value - 1)
+		if f.positive {
+			v |= displayIDSyncPositive
+		}
+		binary.LittleEndian.PutUint16(t[f.offset:], v)
+	}
+	return t, nil
+}
+
+func displayIDAspect(width, height int) byte {
+	for code, ratio := range [][2]int{{1, 1}, {5, 4}, {4, 3}, {15, 9}, {16, 9}, {16, 10}, {64, 27}, {256, 135}} {
+		if width*ratio[1] == height*ratio[0] {
+			return byte(code)
+		}
+	}
+	return displayIDAspectNone
+}
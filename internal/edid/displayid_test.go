@@ -0,0 +1,94 @@
+package edid
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cvt5K is cvt -r 5120 2880 60, which is too large for a detailed timing
+// descriptor.
+var cvt5K = DetailedTiming{
+	PixelClock: 938250,
+	HActive:    5120, HSyncStart: 5168, HSyncEnd: 5200, HTotal: 5280,
+	VActive: 2880, VSyncStart: 2883, VSyncEnd: 2888, VTotal: 2962,
+	HSyncPositive: true,
+}
+
+func TestDisplayID(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		version byte
+		want    []byte
+	}{
+		{"Type I", displayIDVersion13, []byte{
+			0x13, 23, 0, 0, // section header: version, length, product type, extensions
+			0x03, 0x00, 20, // Type I timing block
+			0x80, 0x6e, 0x01, // 938.25 MHz in 10 kHz units, minus one
+			0x84,       // preferred, 16:9
+			0xff, 0x13, // 5120 active
+			0x9f, 0x00, // 160 blanking
+			0x2f, 0x80, // 48 front porch, +hsync
+			0x1f, 0x00, // 32 sync width
+			0x3f, 0x0b, // 2880 active
+			0x51, 0x00, // 82 blanking
+			0x02, 0x00, // 3 front porch, -vsync
+			0x04, 0x00, // 5 sync width
+		}},
+		{"Type VII", displayIDVersion20, []byte{
+			0x20, 23, 0, 0,
+			0x22, 0x00, 20, // Type VII timing block
+			0x09, 0x51, 0x0e, // 938250 kHz, minus one
+			0x84, 0xff, 0x13, 0x9f, 0x00, 0x2f, 0x80, 0x1f, 0x00,
+			0x3f, 0x0b, 0x51, 0x00, 0x02, 0x00, 0x04, 0x00,
+		}},
+	} {
+		d := DisplayID{Version: tt.version, Timings: []DetailedTiming{cvt5K}}
+		b, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(b) != blockSize || b[0] != displayIDTag || checksum(b) != 0 {
+			t.Fatalf("%s: got a %d byte block with tag %#x, summing to %#x", tt.name, len(b), b[0], checksum(b))
+		}
+		section := b[1 : 1+len(tt.want)+1]
+		if !bytes.Equal(section[:len(tt.want)], tt.want) {
+			t.Errorf("%s: got % x, want % x", tt.name, section[:len(tt.want)], tt.want)
+		}
+		if checksum(section) != 0 {
+			t.Errorf("%s: section sums to %#x", tt.name, checksum(section))
+		}
+
+		var got DisplayID
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, d) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, d)
+		}
+	}
+}
+
+func TestDisplayIDDefaultVersion(t *testing.T) {
+	b, err := (&DisplayID{Timings: []DetailedTiming{cvt5K}}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[1] != displayIDVersion13 || b[5] != displayIDTypeI {
+		t.Errorf("got version %#x with block %#x, want 1.3 with Type I timings", b[1], b[5])
+	}
+}
+
+func TestDisplayIDErrors(t *testing.T) {
+	// A section holds up to 5 timings of 20 bytes.
+	timings := []DetailedTiming{cvt5K, cvt5K, cvt5K, cvt5K, cvt5K, cvt5K}
+	if _, err := (&DisplayID{Timings: timings}).MarshalBinary(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("6 timings: got %v, want ErrTooLarge", err)
+	}
+	b, _ := (&DisplayID{Timings: []DetailedTiming{cvt5K}}).MarshalBinary()
+	b[10]++
+	if err := new(DisplayID).UnmarshalBinary(b); !errors.Is(err, ErrChecksum) {
+		t.Errorf("corrupt section: got %v, want ErrChecksum", err)
+	}
+}
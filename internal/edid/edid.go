@@ -0,0 +1,536 @@
+// Package edid encodes and decodes Extended Display Identification Data, so
+// that virtual displays can identify themselves like a physical monitor.
+//
+// The base block follows VESA E-EDID 1.4. CTA-861 and DisplayID extension
+// blocks are supported, other extensions are kept as raw bytes.
+package edid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EDID is the decoded form of a base block and its extensions.
+type EDID struct {
+	// Manufacturer is the three letter PNP ID of the vendor.
+	Manufacturer string
+	ProductCode  uint16
+	SerialNumber uint32
+	// Week and Year of manufacture. Week is optional and may be zero.
+	Week int
+	Year int
+
+	// Version and Revision of the decoded EDID. Encoding always produces 1.4.
+	Version  byte
+	Revision byte
+
+	// VideoInput is the video input definition byte, see DigitalInput.
+	VideoInput byte
+	// Physical size of the display, zero if unknown.
+	WidthCM  int
+	HeightCM int
+	// Gamma is the display transfer characteristic, zero if undefined.
+	Gamma float64
+	// Features is the feature support byte, see FeaturesDefault.
+	Features     byte
+	Chromaticity [10]byte
+
+	EstablishedTimings [3]byte
+	StandardTimings    []StandardTiming
+	// DetailedTimings are the detailed timing descriptors of the base block.
+	// The first is the preferred timing.
+	DetailedTimings []DetailedTiming
+
+	// Display descriptors. Empty if not present.
+	Name         string
+	SerialString string
+	RangeLimits  *RangeLimits
+
+	CTA       []CTA
+	DisplayID []DisplayID
+	// Extensions are the raw bytes of extension blocks with unknown tags.
+	Extensions [][blockSize]byte
+}
+
+// StandardTiming is an entry in the standard timings of the base block.
+type StandardTiming struct {
+	Width       int
+	Height      int
+	RefreshRate int
+}
+
+// DetailedTiming describes a video mode in the same terms as a modeline.
+type DetailedTiming struct {
+	// PixelClock is in kHz.
+	PixelClock uint32
+	HActive    int
+	HSyncStart int
+	HSyncEnd   int
+	HTotal     int
+	VActive    int
+	VSyncStart int
+	VSyncEnd   int
+	VTotal     int
+
+	HSyncPositive bool
+	VSyncPositive bool
+	Interlaced    bool
+
+	// Physical size of the image, zero if unknown.
+	WidthMM  int
+	HeightMM int
+}
+
+// RangeLimits is the display range limits descriptor.
+type RangeLimits struct {
+	MinVRate, MaxVRate int // in Hz
+	MinHRate, MaxHRate int // in kHz
+	// MaxPixelClock is in MHz, rounded up to a multiple of 10.
+	MaxPixelClock int
+}
+
+const (
+	blockSize = 128
+
+	// DigitalInput is a digital input with 8 bits per color over DisplayPort.
+	DigitalInput byte = 0x80 | 0x20 | 0x05
+	// FeaturesDefault declares RGB 4:4:4, sRGB as the default color space and
+	// a preferred timing that is the native mode of the display.
+	FeaturesDefault byte = 0x06
+
+	descriptorSize         = 18
+	descriptorBase         = 54
+	descriptorCount        = 4
+	descriptorSerial  byte = 0xff
+	descriptorText    byte = 0xfe
+	descriptorRange   byte = 0xfd
+	descriptorName    byte = 0xfc
+	descriptorDummy   byte = 0x10
+	descriptorTextLen      = 13
+)
+
+var (
+	ErrInvalid  = errors.New("edid: invalid data")
+	ErrChecksum = errors.New("edid: bad checksum")
+	ErrTooLarge = errors.New("edid: value does not fit")
+)
+
+var (
+	header = [8]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+	// SRGBChromaticity are the chromaticity coordinates of sRGB.
+	SRGBChromaticity = [10]byte{0xee, 0x91, 0xa3, 0x54, 0x4c, 0x99, 0x26, 0x0f, 0x50, 0x54}
+)
+
+// Parse decodes an EDID base block and its extensions.
+func Parse(b []byte) (*EDID, error) {
+	ret := &EDID{}
+	if err := ret.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (e *EDID) UnmarshalBinary(b []byte) error {
+	if len(b) < blockSize || len(b)%blockSize != 0 {
+		return fmt.Errorf("%w: length %d", ErrInvalid, len(b))
+	}
+	if !bytes.Equal(b[:8], header[:]) {
+		return fmt.Errorf("%w: bad header", ErrInvalid)
+	}
+	if checksum(b[:blockSize]) != 0 {
+		return ErrChecksum
+	}
+	*e = EDID{}
+
+	mfg := binary.BigEndian.Uint16(b[8:])
+	e.Manufacturer = string([]byte{
+		byte(mfg>>10&0x1f) + 'A' - 1,
+		byte(mfg>>5&0x1f) + 'A' - 1,
+		byte(mfg&0x1f) + 'A' - 1,
+	})
+	e.ProductCode = binary.LittleEndian.Uint16(b[10:])
+	e.SerialNumber = binary.LittleEndian.Uint32(b[12:])
+	e.Week = int(b[16])
+	e.Year = int(b[17]) + 1990
+	e.Version, e.Revision = b[18], b[19]
+	e.VideoInput = b[20]
+	e.WidthCM, e.HeightCM = int(b[21]), int(b[22])
+	if b[23] != 0xff {
+		e.Gamma = (float64(b[23]) + 100) / 100
+	}
+	e.Features = b[24]
+	copy(e.Chromaticity[:], b[25:35])
+	copy(e.EstablishedTimings[:], b[35:38])
+	for i := 38; i < 54; i += 2 {
+		if st, ok := decodeStandardTiming(b[i:i+2], e.Revision); ok {
+			e.StandardTimings = append(e.StandardTimings, st)
+		}
+	}
+	for i := 0; i < descriptorCount; i++ {
+		d := b[descriptorBase+i*descriptorSize:][:descriptorSize]
+		if d[0] != 0 || d[1] != 0 {
+			e.DetailedTimings = append(e.DetailedTimings, decodeDetailedTiming(d))
+			continue
+		}
+		e.decodeDescriptor(d)
+	}
+
+	extensions := int(b[126])
+	if len(b) < (extensions+1)*blockSize {
+		return fmt.Errorf("%w: %d extensions declared, %d present", ErrInvalid, extensions, len(b)/blockSize-1)
+	}
+	for i := 1; i <= extensions; i++ {
+		ext := b[i*blockSize:][:blockSize]
+		if checksum(ext) != 0 {
+			return fmt.Errorf("%w: extension %d", ErrChecksum, i)
+		}
+		switch ext[0] {
+		case ctaTag:
+			var cta CTA
+			if err := cta.UnmarshalBinary(ext); err != nil {
+				return err
+			}
+			e.CTA = append(e.CTA, cta)
+		case displayIDTag:
+			var did DisplayID
+			if err := did.UnmarshalBinary(ext); err != nil {
+				return err
+			}
+			e.DisplayID = append(e.DisplayID, did)
+		default:
+			var raw [blockSize]byte
+			copy(raw[:], ext)
+			e.Extensions = append(e.Extensions, raw)
+		}
+	}
+	return nil
+}
+
+func (e *EDID) decodeDescriptor(d []byte) {
+	switch d[3] {
+	case descriptorName:
+		e.Name = decodeText(d[5:])
+	case descriptorSerial:
+		e.SerialString = decodeText(d[5:])
+	case descriptorRange:
+		r := &RangeLimits{
+			MinVRate:      int(d[5]),
+			MaxVRate:      int(d[6]),
+			MinHRate:      int(d[7]),
+			MaxHRate:      int(d[8]),
+			MaxPixelClock: int(d[9]) * 10,
+		}
+		// Offsets for rates above 255, added in EDID 1.4.
+		if d[4]&0x03 == 0x03 {
+			r.MinVRate += 255
+		}
+		if d[4]&0x03 >= 0x02 {
+			r.MaxVRate += 255
+		}
+		if d[4]&0x0c == 0x0c {
+			r.MinHRate += 255
+		}
+		if d[4]&0x0c >= 0x08 {
+			r.MaxHRate += 255
+		}
+		e.RangeLimits = r
+	}
+}
+
+// MarshalBinary encodes the EDID as a 1.4 base block followed by its CTA,
+// DisplayID and raw extensions, in that order.
+func (e *EDID) MarshalBinary() ([]byte, error) {
+	extensions := len(e.CTA) + len(e.DisplayID) + len(e.Extensions)
+	if extensions > 0xff {
+		return nil, fmt.Errorf("%w: %d extensions", ErrTooLarge, extensions)
+	}
+	b := make([]byte, blockSize, (extensions+1)*blockSize)
+	copy(b, header[:])
+
+	if len(e.Manufacturer) != 3 {
+		return nil, fmt.Errorf("%w: manufacturer %q is not a PNP ID", ErrInvalid, e.Manufacturer)
+	}
+	var mfg uint16
+	for _, c := range []byte(e.Manufacturer) {
+		if c < 'A' || c > 'Z' {
+			return nil, fmt.Errorf("%w: manufacturer %q is not a PNP ID", ErrInvalid, e.Manufacturer)
+		}
+		mfg = mfg<<5 | uint16(c-'A'+1)
+	}
+	binary.BigEndian.PutUint16(b[8:], mfg)
+	binary.LittleEndian.PutUint16(b[10:], e.ProductCode)
+	binary.LittleEndian.PutUint32(b[12:], e.SerialNumber)
+	if e.Year < 1990 || e.Year > 1990+0xff {
+		return nil, fmt.Errorf("%w: year %d", ErrTooLarge, e.Year)
+	}
+	b[16], b[17] = byte(e.Week), byte(e.Year-1990)
+	b[18], b[19] = 1, 4
+	b[20] = e.VideoInput
+	b[21], b[22] = byte(e.WidthCM), byte(e.HeightCM)
+	b[23] = 0xff
+	if e.Gamma != 0 {
+		b[23] = byte(e.Gamma*100 - 100 + 0.5)
+	}
+	b[24] = e.Features
+	copy(b[25:35], e.Chromaticity[:])
+	copy(b[35:38], e.EstablishedTimings[:])
+
+	if len(e.StandardTimings) > 8 {
+		return nil, fmt.Errorf("%w: %d standard timings", ErrTooLarge, len(e.StandardTimings))
+	}
+	for i := 0; i < 8; i++ {
+		st := b[38+i*2:][:2]
+		if i >= len(e.StandardTimings) {
+			st[0], st[1] = 0x01, 0x01
+			continue
+		}
+		if err := e.StandardTimings[i].encode(st); err != nil {
+			return nil, err
+		}
+	}
+
+	var descriptors [][descriptorSize]byte
+	for _, dt := range e.DetailedTimings {
+		var d [descriptorSize]byte
+		if err := dt.encode(d[:]); err != nil {
+			return nil, err
+		}
+		descriptors = append(descriptors, d)
+	}
+	if e.Name != "" {
+		descriptors = append(descriptors, textDescriptor(descriptorName, e.Name))
+	}
+	if e.SerialString != "" {
+		descriptors = append(descriptors, textDescriptor(descriptorSerial, e.SerialString))
+	}
+	if e.RangeLimits != nil {
+		descriptors = append(descriptors, e.RangeLimits.descriptor())
+	}
+	if len(descriptors) > descriptorCount {
+		return nil, fmt.Errorf("%w: %d descriptors", ErrTooLarge, len(descriptors))
+	}
+	for i := 0; i < descriptorCount; i++ {
+		d := b[descriptorBase+i*descriptorSize:][:descriptorSize]
+		if i < len(descriptors) {
+			copy(d, descriptors[i][:])
+		} else {
+			d[3] = descriptorDummy
+		}
+	}
+
+	b[126] = byte(extensions)
+	b[127] = -checksum(b[:blockSize-1])
+
+	for _, cta := range e.CTA {
+		ext, err := cta.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, ext...)
+	}
+	for _, did := range e.DisplayID {
+		ext, err := did.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, ext...)
+	}
+	for _, ext := range e.Extensions {
+		b = append(b, ext[:]...)
+	}
+	return b, nil
+}
+
+// Preferred returns the preferred timing, if the EDID declares one.
+func (e *EDID) Preferred() (DetailedTiming, bool) {
+	if len(e.DetailedTimings) > 0 {
+		return e.DetailedTimings[0], true
+	}
+	for _, did := range e.DisplayID {
+		if len(did.Timings) > 0 {
+			return did.Timings[0], true
+		}
+	}
+	return DetailedTiming{}, false
+}
+
+func decodeStandardTiming(b []byte, revision byte) (StandardTiming, bool) {
+	if (b[0] == 0x01 && b[1] == 0x01) || b[0] == 0x00 {
+		return StandardTiming{}, false
+	}
+	ret := StandardTiming{Width: (int(b[0]) + 31) * 8, RefreshRate: int(b[1]&0x3f) + 60}
+	switch b[1] >> 6 {
+	case 0:
+		if revision < 3 {
+			ret.Height = ret.Width
+		} else {
+			ret.Height = ret.Width * 10 / 16
+		}
+	case 1:
+		ret.Height = ret.Width * 3 / 4
+	case 2:
+		ret.Height = ret.Width * 4 / 5
+	case 3:
+		ret.Height = ret.Width * 9 / 16
+	}
+	return ret, true
+}
+
+func (st StandardTiming) encode(b []byte) error {
+	if st.Width < 256 || st.Width > 2288 || st.Width%8 != 0 || st.RefreshRate < 60 || st.RefreshRate > 123 {
+		return fmt.Errorf("%w: standard timing %dx%d@%d", ErrTooLarge, st.Width, st.Height, st.RefreshRate)
+	}
+	var aspect byte
+	switch {
+	case st.Height*16 == st.Width*10:
+		aspect = 0
+	case st.Height*4 == st.Width*3:
+		aspect = 1
+	case st.Height*5 == st.Width*4:
+		aspect = 2
+	case st.Height*16 == st.Width*9:
+		aspect = 3
+	default:
+		return fmt.Errorf("%w: standard timing %dx%d has no aspect ratio code", ErrInvalid, st.Width, st.Height)
+	}
+	b[0] = byte(st.Width/8 - 31)
+	b[1] = aspect<<6 | byte(st.RefreshRate-60)
+	return nil
+}
+
+// FitsDescriptor reports whether the timing can be encoded as a detailed
+// timing descriptor of the base block or a CTA-861 extension. Larger modes
+// must use a DisplayID extension.
+func (dt DetailedTiming) FitsDescriptor() bool {
+	return dt.PixelClock/10 <= 0xffff && dt.HActive <= 0xfff && dt.HTotal-dt.HActive <= 0xfff &&
+		dt.VActive <= 0xfff && dt.VTotal-dt.VActive <= 0xfff &&
+		dt.HSyncStart-dt.HActive <= 0x3ff && dt.HSyncEnd-dt.HSyncStart <= 0x3ff &&
+		dt.VSyncStart-dt.VActive <= 0x3f && dt.VSyncEnd-dt.VSyncStart <= 0x3f &&
+		dt.WidthMM <= 0xfff && dt.HeightMM <= 0xfff
+}
+
+func decodeDetailedTiming(d []byte) DetailedTiming {
+	hActive := int(d[2]) | int(d[4]>>4)<<8
+	hBlank := int(d[3]) | int(d[4]&0x0f)<<8
+	vActive := int(d[5]) | int(d[7]>>4)<<8
+	vBlank := int(d[6]) | int(d[7]&0x0f)<<8
+	hFront := int(d[8]) | int(d[11]>>6)<<8
+	hSync := int(d[9]) | int(d[11]>>4&0x03)<<8
+	vFront := int(d[10]>>4) | int(d[11]>>2&0x03)<<4
+	vSync := int(d[10]&0x0f) | int(d[11]&0x03)<<4
+	return DetailedTiming{
+		PixelClock:    uint32(binary.LittleEndian.Uint16(d)) * 10,
+		HActive:       hActive,
+		HSyncStart:    hActive + hFront,
+		HSyncEnd:      hActive + hFront + hSync,
+		HTotal:        hActive + hBlank,
+		VActive:       vActive,
+		VSyncStart:    vActive + vFront,
+		VSyncEnd:      vActive + vFront + vSync,
+		VTotal:        vActive + vBlank,
+		WidthMM:       int(d[12]) | int(d[14]>>4)<<8,
+		HeightMM:      int(d[13]) | int(d[14]&0x0f)<<8,
+		Interlaced:    d[17]&0x80 != 0,
+		VSyncPositive: d[17]&0x18 == 0x18 && d[17]&0x04 != 0,
+		HSyncPositive: d[17]&0x18 >= 0x10 && d[17]&0x02 != 0,
+	}
+}
+
+func (dt DetailedTiming) encode(d []byte) error {
+	if !dt.FitsDescriptor() {
+		return fmt.Errorf("%w: detailed timing %dx%d", ErrTooLarge, dt.HActive, dt.VActive)
+	}
+	hBlank, vBlank := dt.HTotal-dt.HActive, dt.VTotal-dt.VActive
+	hFront, hSync := dt.HSyncStart-dt.HActive, dt.HSyncEnd-dt.HSyncStart
+	vFront, vSync := dt.VSyncStart-dt.VActive, dt.VSyncEnd-dt.VSyncStart
+
+	binary.LittleEndian.PutUint16(d, uint16(dt.PixelClock/10))
+	d[2], d[3] = byte(dt.HActive), byte(hBlank)
+	d[4] = byte(dt.HActive>>8)<<4 | byte(hBlank>>8)
+	d[5], d[6] = byte(dt.VActive), byte(vBlank)
+	d[7] = byte(dt.VActive>>8)<<4 | byte(vBlank>>8)
+	d[8], d[9] = byte(hFront), byte(hSync)
+	d[10] = byte(vFront&0x0f)<<4 | byte(vSync&0x0f)
+	d[11] = byte(hFront>>8)<<6 | byte(hSync>>8)<<4 | byte(vFront>>4)<<2 | byte(vSync>>4)
+	d[12], d[13] = byte(dt.WidthMM), byte(dt.HeightMM)
+	d[14] = byte(dt.WidthMM>>8)<<4 | byte(dt.HeightMM>>8)
+	// Digital separate sync.
+	d[17] = 0x18
+	if dt.Interlaced {
+		d[17] |= 0x80
+	}
+	if dt.VSyncPositive {
+		d[17] |= 0x04
+	}
+	if dt.HSyncPositive {
+		d[17] |= 0x02
+	}
+	return nil
+}
+
+func textDescriptor(tag byte, s string) [descriptorSize]byte {
+	var d [descriptorSize]byte
+	d[3] = tag
+	text := d[5:]
+	for i := range text {
+		text[i] = ' '
+	}
+	if len(s) > descriptorTextLen {
+		s = s[:descriptorTextLen]
+	}
+	n := copy(text, s)
+	if n < descriptorTextLen {
+		text[n] = '\n'
+	}
+	return d
+}
+
+func decodeText(b []byte) string {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	return string(bytes.TrimRight(b, " \x00"))
+}
+
+func (r *RangeLimits) descriptor() [descriptorSize]byte {
+	var d [descriptorSize]byte
+	d[3] = descriptorRange
+	vmin, vmax, hmin, hmax := r.MinVRate, r.MaxVRate, r.MinHRate, r.MaxHRate
+	if vmax > 255 {
+		d[4] |= 0x02
+		vmax -= 255
+		if vmin > 255 {
+			d[4] |= 0x01
+			vmin -= 255
+		}
+	}
+	if hmax > 255 {
+		d[4] |= 0x08
+		hmax -= 255
+		if hmin > 255 {
+			d[4] |= 0x04
+			hmin -= 255
+		}
+	}
+	d[5], d[6], d[7], d[8] = byte(vmin), byte(vmax), byte(hmin), byte(hmax)
+	d[9] = byte((r.MaxPixelClock + 9) / 10)
+	// Range limits only, no timing formula data.
+	d[10] = 0x01
+	d[11] = '\n'
+	for i := 12; i < descriptorSize; i++ {
+		d[i] = ' '
+	}
+	return d
+}
+
+// checksum returns the sum of b, which is zero for a valid block.
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return sum
+}
@@ -0,0 +1,203 @@
+package edid
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cvt1080 is cvt -r 1920 1080 60 on a 96 DPI panel.
+var cvt1080 = DetailedTiming{
+	PixelClock: 138500,
+	HActive:    1920, HSyncStart: 1968, HSyncEnd: 2000, HTotal: 2080,
+	VActive: 1080, VSyncStart: 1083, VSyncEnd: 1088, VTotal: 1111,
+	HSyncPositive: true,
+	WidthMM:       508, HeightMM: 285,
+}
+
+// cvt1080DTD is cvt1080 as a detailed timing descriptor, encoded by hand from
+// the tables of VESA E-EDID 1.4, section 3.10.2. The first 12 bytes are the
+// ones found in the EDIDs of 1080p panels with reduced blanking.
+var cvt1080DTD = []byte{
+	0x1a, 0x36, // 138.50 MHz in 10 kHz units
+	0x80, 0xa0, 0x70, // 1920 active, 160 blanking
+	0x38, 0x1f, 0x40, // 1080 active, 31 blanking
+	0x30, 0x20, 0x35, 0x00, // front porches 48 and 3, sync widths 32 and 5
+	0xfc, 0x1d, 0x11, // 508x285 mm
+	0x00, 0x00, // no border
+	0x1a, // digital separate sync, +hsync -vsync
+}
+
+func testEDID() *EDID {
+	return &EDID{
+		Manufacturer: "VDP",
+		ProductCode:  1,
+		SerialNumber: 1,
+		Week:         10,
+		Year:         2024,
+		VideoInput:   DigitalInput,
+		WidthCM:      51,
+		HeightCM:     29,
+		Gamma:        2.2,
+		Features:     FeaturesDefault,
+		Chromaticity: SRGBChromaticity,
+
+		DetailedTimings: []DetailedTiming{cvt1080},
+		Name:            "vdisplay",
+		RangeLimits:     &RangeLimits{MinVRate: 56, MaxVRate: 76, MinHRate: 30, MaxHRate: 83, MaxPixelClock: 170},
+	}
+}
+
+func TestMarshalBaseBlock(t *testing.T) {
+	b, err := testEDID().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != blockSize {
+		t.Fatalf("got %d bytes, want a single block", len(b))
+	}
+	if checksum(b) != 0 {
+		t.Errorf("block sums to %#x", checksum(b))
+	}
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		want   []byte
+	}{
+		{"header", 0, []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}},
+		// V=22, D=4, P=16 packed in 5 bit letters, big endian.
+		{"manufacturer", 8, []byte{0x58, 0x90}},
+		{"product and serial", 10, []byte{0x01, 0x00, 0x01, 0x00, 0x00, 0x00}},
+		{"week and year", 16, []byte{10, 2024 - 1990}},
+		{"version", 18, []byte{1, 4}},
+		{"basic parameters", 20, []byte{0xa5, 51, 29, 0x78, 0x06}},
+		{"chromaticity", 25, []byte{0xee, 0x91, 0xa3, 0x54, 0x4c, 0x99, 0x26, 0x0f, 0x50, 0x54}},
+		{"established timings", 35, []byte{0, 0, 0}},
+		{"standard timings", 38, bytes.Repeat([]byte{0x01, 0x01}, 8)},
+		{"preferred timing", 54, cvt1080DTD},
+		{"name", 72, []byte{0, 0, 0, 0xfc, 0, 'v', 'd', 'i', 's', 'p', 'l', 'a', 'y', '\n', ' ', ' ', ' ', ' '}},
+		{"range limits", 90, []byte{0, 0, 0, 0xfd, 0, 56, 76, 30, 83, 17, 0x01, '\n', ' ', ' ', ' ', ' ', ' ', ' '}},
+		{"dummy descriptor", 108, []byte{0, 0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"extension count", 126, []byte{0}},
+	} {
+		if got := b[tt.offset : tt.offset+len(tt.want)]; !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % x, want % x", tt.name, got, tt.want)
+		}
+	}
+
+	e, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testEDID()
+	want.Version, want.Revision = 1, 4
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+}
+
+func TestDetailedTiming(t *testing.T) {
+	d := make([]byte, descriptorSize)
+	if err := cvt1080.encode(d); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, cvt1080DTD) {
+		t.Errorf("got % x, want % x", d, cvt1080DTD)
+	}
+	if got := decodeDetailedTiming(cvt1080DTD); got != cvt1080 {
+		t.Errorf("got %+v, want %+v", got, cvt1080)
+	}
+
+	// cvt -r 4096 2160 60 is a pixel too wide for a descriptor.
+	wide := DetailedTiming{
+		PixelClock: 567000,
+		HActive:    4096, HSyncStart: 4144, HSyncEnd: 4176, HTotal: 4256,
+		VActive: 2160, VSyncStart: 2163, VSyncEnd: 2173, VTotal: 2222,
+	}
+	if wide.FitsDescriptor() {
+		t.Errorf("%dx%d fits a descriptor", wide.HActive, wide.VActive)
+	}
+	if err := wide.encode(d); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestRangeLimits(t *testing.T) {
+	for _, tt := range []struct {
+		limits RangeLimits
+		want   []byte
+	}{
+		{RangeLimits{56, 76, 30, 83, 170}, []byte{0x00, 56, 76, 30, 83, 17}},
+		// Rates above 255 are stored with an offset of 255, flagged in byte 4.
+		{RangeLimits{48, 300, 30, 160, 600}, []byte{0x02, 48, 45, 30, 160, 60}},
+		{RangeLimits{260, 300, 256, 400, 1000}, []byte{0x0f, 5, 45, 1, 145, 100}},
+	} {
+		d := tt.limits.descriptor()
+		if !bytes.Equal(d[:4], []byte{0, 0, 0, descriptorRange}) {
+			t.Errorf("%+v: got tag % x", tt.limits, d[:4])
+		}
+		if !bytes.Equal(d[4:10], tt.want) {
+			t.Errorf("%+v: got % x, want % x", tt.limits, d[4:10], tt.want)
+		}
+		var e EDID
+		e.decodeDescriptor(d[:])
+		if e.RangeLimits == nil || *e.RangeLimits != tt.limits {
+			t.Errorf("%+v: decoded %+v", tt.limits, e.RangeLimits)
+		}
+	}
+}
+
+func TestMarshalExtensions(t *testing.T) {
+	e := testEDID()
+	e.CTA = []CTA{{VideoCodes: []VideoCode{{VIC: 16, Native: true}}}}
+	e.DisplayID = []DisplayID{{Timings: []DetailedTiming{cvt1080}}}
+	e.Extensions = [][blockSize]byte{{0xf0}}
+	e.Extensions[0][blockSize-1] = 0x10
+	b, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 4*blockSize || b[126] != 3 {
+		t.Fatalf("got %d bytes declaring %d extensions, want 4 blocks", len(b), b[126])
+	}
+	for i, tag := range []byte{header[0], ctaTag, displayIDTag, 0xf0} {
+		block := b[i*blockSize : (i+1)*blockSize]
+		if block[0] != tag {
+			t.Errorf("block %d: got tag %#x, want %#x", i, block[0], tag)
+		}
+		if checksum(block) != 0 {
+			t.Errorf("block %d sums to %#x", i, checksum(block))
+		}
+	}
+
+	parsed, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.CTA) != 1 || len(parsed.DisplayID) != 1 || len(parsed.Extensions) != 1 {
+		t.Errorf("got %d CTA, %d DisplayID and %d raw extensions", len(parsed.CTA), len(parsed.DisplayID), len(parsed.Extensions))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	b, err := testEDID().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		modify func([]byte) []byte
+		want   error
+	}{
+		{"short", func(b []byte) []byte { return b[:100] }, ErrInvalid},
+		{"header", func(b []byte) []byte { b[0] = 0xff; return b }, ErrInvalid},
+		{"checksum", func(b []byte) []byte { b[20]++; return b }, ErrChecksum},
+		{"missing extension", func(b []byte) []byte { b[126], b[127] = 1, b[127]-1; return b }, ErrInvalid},
+	} {
+		if _, err := Parse(tt.modify(append([]byte(nil), b...))); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package fourcc describes the pixel formats and modifiers of drm_fourcc.h.
+package fourcc
+
+import "fmt"
+
+// Fourcc codes of pixel formats. Components of packed RGB formats are listed
+// from the most significant bits of a little-endian pixel, so XRGB8888 is laid
+// out as BGRx in memory.
+const (
+	XRGB8888    uint32 = 'X' | 'R'<<8 | '2'<<16 | '4'<<24
+	ARGB8888    uint32 = 'A' | 'R'<<8 | '2'<<16 | '4'<<24
+	XBGR8888    uint32 = 'X' | 'B'<<8 | '2'<<16 | '4'<<24
+	ABGR8888    uint32 = 'A' | 'B'<<8 | '2'<<16 | '4'<<24
+	RGB565      uint32 = 'R' | 'G'<<8 | '1'<<16 | '6'<<24
+	XRGB2101010 uint32 = 'X' | 'R'<<8 | '3'<<16 | '0'<<24
+	ARGB2101010 uint32 = 'A' | 'R'<<8 | '3'<<16 | '0'<<24
+	// NV12 is YUV 4:2:0 with a Y plane and an interleaved CbCr plane.
+	NV12 uint32 = 'N' | 'V'<<8 | '1'<<16 | '2'<<24
+)
+
+// Format modifiers describe the tiling and compression of a buffer. The top
+// byte of a modifier is its vendor.
+const (
+	ModLinear  uint64 = 0
+	ModInvalid uint64 = 0x00ffffffffffffff
+
+	modVendorShift = 56
+)
+
+// Info is the memory layout of a pixel format.
+type Info struct {
+	Format uint32
+	// Depth is the number of color bits of RGB formats, zero for YUV formats.
+	Depth int
+	// Planes is the number of planes, each holding CPP[plane] bytes per
+	// pixel. Planes past the first are subsampled by HSub and VSub.
+	Planes     int
+	CPP        [3]int
+	HSub, VSub int
+	HasAlpha   bool
+	YUV        bool
+}
+
+var formats = []Info{
+	{Format: XRGB8888, Depth: 24, Planes: 1, CPP: [3]int{4}, HSub: 1, VSub: 1},
+	{Format: ARGB8888, Depth: 32, Planes: 1, CPP: [3]int{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: XBGR8888, Depth: 24, Planes: 1, CPP: [3]int{4}, HSub: 1, VSub: 1},
+	{Format: ABGR8888, Depth: 32, Planes: 1, CPP: [3]int{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: RGB565, Depth: 16, Planes: 1, CPP: [3]int{2}, HSub: 1, VSub: 1},
+	{Format: XRGB2101010, Depth: 30, Planes: 1, CPP: [3]int{4}, HSub: 1, VSub: 1},
+	{Format: ARGB2101010, Depth: 30, Planes: 1, CPP: [3]int{4}, HSub: 1, VSub: 1, HasAlpha: true},
+	{Format: NV12, Planes: 2, CPP: [3]int{1, 2}, HSub: 2, VSub: 2, YUV: true},
+}
+
+var modVendors = []string{
+	"NONE", "INTEL", "AMD", "NVIDIA", "SAMSUNG", "QCOM", "VIVANTE", "BROADCOM", "ARM", "ALLWINNER", "AMLOGIC",
+}
+
+// Lookup returns the layout of a format.
+func Lookup(format uint32) (Info, bool) {
+	for _, info := range formats {
+		if info.Format == format {
+			return info, true
+		}
+	}
+	return Info{}, false
+}
+
+// Name returns the four characters of a fourcc code, e.g. XR24 for XRGB8888.
+func Name(format uint32) string {
+	return string([]byte{byte(format), byte(format >> 8), byte(format >> 16), byte(format >> 24)})
+}
+
+// ModifierName returns LINEAR or INVALID, or the vendor and vendor specific
+// code of other modifiers.
+func ModifierName(mod uint64) string {
+	switch mod {
+	case ModLinear:
+		return "LINEAR"
+	case ModInvalid:
+		return "INVALID"
+	}
+	vendor := fmt.Sprintf("%#x", mod>>modVendorShift)
+	if v := int(mod >> modVendorShift); v < len(modVendors) {
+		vendor = modVendors[v]
+	}
+	return fmt.Sprintf("%s(%#x)", vendor, mod&(1<<modVendorShift-1))
+}
+
+// BPP returns the bits per pixel of the first plane.
+func (i Info) BPP() int {
+	return i.CPP[0] * 8
+}
+
+// PlaneSize returns the size in pixels of a plane of a width by height image.
+func (i Info) PlaneSize(plane, width, height int) (int, int) {
+	if plane == 0 {
+		return width, height
+	}
+	return (width + i.HSub - 1) / i.HSub, (height + i.VSub - 1) / i.VSub
+}
+
+// MinStride returns the length in bytes of a row of a plane without padding.
+func (i Info) MinStride(plane, width int) int {
+	w, _ := i.PlaneSize(plane, width, 1)
+	return w * i.CPP[plane]
+}
+
+func (i Info) String() string {
+	return Name(i.Format)
+}
@@ -0,0 +1,41 @@
+package timing
+
+import (
+	"github.com/inahga/vdisplay/internal/edid"
+	"github.com/jezek/xgb/randr"
+)
+
+// RandR converts the mode to a RandR mode. The caller sets NameLen to the
+// length of the name passed to CreateMode.
+func (m Mode) RandR() randr.ModeInfo {
+	return randr.ModeInfo{
+		Width:      uint16(m.HDisplay),
+		Height:     uint16(m.VDisplay),
+		DotClock:   m.Clock * 1000,
+		HsyncStart: uint16(m.HSyncStart),
+		HsyncEnd:   uint16(m.HSyncEnd),
+		Htotal:     uint16(m.HTotal),
+		VsyncStart: uint16(m.VSyncStart),
+		VsyncEnd:   uint16(m.VSyncEnd),
+		Vtotal:     uint16(m.VTotal),
+		ModeFlags:  uint32(m.Flags),
+	}
+}
+
+// DetailedTiming converts the mode to an EDID detailed timing, without a
+// physical image size.
+func (m Mode) DetailedTiming() edid.DetailedTiming {
+	return edid.DetailedTiming{
+		PixelClock:    m.Clock,
+		HActive:       m.HDisplay,
+		HSyncStart:    m.HSyncStart,
+		HSyncEnd:      m.HSyncEnd,
+		HTotal:        m.HTotal,
+		VActive:       m.VDisplay,
+		VSyncStart:    m.VSyncStart,
+		VSyncEnd:      m.VSyncEnd,
+		VTotal:        m.VTotal,
+		HSyncPositive: m.Flags&HSyncPositive != 0,
+		VSyncPositive: m.Flags&VSyncPositive != 0,
+	}
+}
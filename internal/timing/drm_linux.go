@@ -0,0 +1,29 @@
+package timing
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/inahga/vdisplay/internal/drm"
+)
+
+// DRM converts the mode to a user defined DRM mode, named like the kernel names
+// modes it generates.
+func (m Mode) DRM() drm.ModeInfo {
+	ret := drm.ModeInfo{
+		Clock:      m.Clock,
+		HDisplay:   uint16(m.HDisplay),
+		HSyncStart: uint16(m.HSyncStart),
+		HSyncEnd:   uint16(m.HSyncEnd),
+		HTotal:     uint16(m.HTotal),
+		VDisplay:   uint16(m.VDisplay),
+		VSyncStart: uint16(m.VSyncStart),
+		VSyncEnd:   uint16(m.VSyncEnd),
+		VTotal:     uint16(m.VTotal),
+		VRefresh:   uint32(math.Round(m.Refresh())),
+		Flags:      uint32(m.Flags),
+		Type:       drm.ModeTypeUserDef,
+	}
+	ret.SetName(fmt.Sprintf("%dx%d", m.HDisplay, m.VDisplay))
+	return ret
+}
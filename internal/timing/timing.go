@@ -0,0 +1,298 @@
+// Package timing calculates video timings for arbitrary resolutions with the
+// VESA Coordinated Video Timings (CVT) and Generalized Timing Formula (GTF)
+// standards.
+//
+// CVT and CVT reduced blanking follow the X server implementation, so that the
+// results are identical to the output of the cvt(1) tool. GTF is identical to
+// gtf(1). CVT reduced blanking v2 follows the VESA CVT 1.2 spreadsheet.
+package timing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Mode is a video mode in the same terms as a modeline.
+type Mode struct {
+	// Clock is the pixel clock in kHz.
+	Clock      uint32
+	HDisplay   int
+	HSyncStart int
+	HSyncEnd   int
+	HTotal     int
+	VDisplay   int
+	VSyncStart int
+	VSyncEnd   int
+	VTotal     int
+	Flags      Flags
+}
+
+// Flags are the sync polarities of a mode. The values are shared by DRM and
+// RandR.
+type Flags uint32
+
+const (
+	HSyncPositive Flags = 1 << iota
+	HSyncNegative
+	VSyncPositive
+	VSyncNegative
+)
+
+var ErrInvalid = errors.New("timing: invalid mode")
+
+const (
+	// Horizontal character cell granularity, in pixels.
+	cellGranularity = 8
+	// Minimum time of vertical sync and back porch, in microseconds.
+	minVSyncBackPorch = 550.0
+	// Blanking formula parameters: the gradient M, offset C, scaling factor K
+	// and weighting J, combined into C' and M'.
+	blankingCPrime = (40-20)*128/256 + 20
+	blankingMPrime = 600 * 128 / 256
+
+	cvtMinVPorch      = 3
+	cvtMinVBackPorch  = 6
+	cvtHSyncPercent   = 8
+	cvtClockStep      = 250 // in kHz
+	cvtRBMinVBlank    = 460.0
+	cvtRBHSync        = 32
+	cvtRBHBlank       = 160
+	cvtRBVFrontPorch  = 3
+	cvtRB2HBlank      = 80
+	cvtRB2HFrontPorch = 8
+	cvtRB2VFrontPorch = 1
+	cvtRB2VSync       = 8
+
+	gtfMinPorch        = 1
+	gtfVSync           = 3
+	gtfHSyncPercent    = 8.0
+	gtfCellGranularity = 8.0
+)
+
+func validate(width, height int, refresh float64) error {
+	if width <= 0 || height <= 0 || refresh <= 0 || math.IsInf(refresh, 0) || math.IsNaN(refresh) {
+		return fmt.Errorf("%w: %dx%d@%g", ErrInvalid, width, height, refresh)
+	}
+	return nil
+}
+
+// cvtVSync returns the vertical sync width in lines, which encodes the aspect
+// ratio of the mode.
+func cvtVSync(width, height int) int {
+	switch {
+	case height%3 == 0 && height*4/3 == width:
+		return 4
+	case height%9 == 0 && height*16/9 == width:
+		return 5
+	case height%10 == 0 && height*16/10 == width:
+		return 6
+	case height%4 == 0 && height*5/4 == width:
+		return 7
+	case height%9 == 0 && height*15/9 == width:
+		return 7
+	default:
+		return 10
+	}
+}
+
+// CVT calculates timings with standard CVT blanking, suitable for CRTs and
+// displays that need a long blanking interval. The width is rounded up to a
+// multiple of 8, as cvt(1) does.
+func CVT(width, height int, refresh float64) (Mode, error) {
+	if err := validate(width, height, refresh); err != nil {
+		return Mode{}, err
+	}
+	// Intermediate values are single precision, as in the X server, so that
+	// rounding matches cvt(1).
+	ret := Mode{
+		HDisplay: cvtRoundWidth(width),
+		VDisplay: height,
+		Flags:    HSyncNegative | VSyncPositive,
+	}
+	vsync := cvtVSync(ret.HDisplay, height)
+
+	hperiod := float32(1e6/float64(float32(refresh))-minVSyncBackPorch) / float32(height+cvtMinVPorch)
+	vsyncBackPorch := int(minVSyncBackPorch/float64(hperiod)) + 1
+	if vsyncBackPorch < vsync+cvtMinVPorch {
+		vsyncBackPorch = vsync + cvtMinVPorch
+	}
+	ret.VTotal = height + vsyncBackPorch + cvtMinVPorch
+
+	hblankPercent := float32(blankingCPrime - float64(blankingMPrime*hperiod)/1000.0)
+	if hblankPercent < 20 {
+		hblankPercent = 20
+	}
+	hblank := int(float64(float32(ret.HDisplay)*hblankPercent) / (100.0 - float64(hblankPercent)))
+	hblank -= hblank % (2 * cellGranularity)
+	ret.HTotal = ret.HDisplay + hblank
+
+	ret.HSyncEnd = ret.HDisplay + hblank/2
+	ret.HSyncStart = ret.HSyncEnd - ret.HTotal*cvtHSyncPercent/100
+	ret.HSyncStart += cellGranularity - ret.HSyncStart%cellGranularity
+	ret.VSyncStart = ret.VDisplay + cvtMinVPorch
+	ret.VSyncEnd = ret.VSyncStart + vsync
+
+	ret.Clock = cvtClock(ret.HTotal, hperiod)
+	return ret, nil
+}
+
+// CVTReduced calculates timings with CVT reduced blanking, as used by most flat
+// panels. Reduced blanking is only defined for refresh rates that are a
+// multiple of 60 Hz, other rates are calculated all the same. The width is
+// rounded up to a multiple of 8, as cvt(1) does.
+func CVTReduced(width, height int, refresh float64) (Mode, error) {
+	if err := validate(width, height, refresh); err != nil {
+		return Mode{}, err
+	}
+	ret := Mode{
+		HDisplay: cvtRoundWidth(width),
+		VDisplay: height,
+		Flags:    HSyncPositive | VSyncNegative,
+	}
+	vsync := cvtVSync(ret.HDisplay, height)
+
+	hperiod := float32(1e6/float64(float32(refresh))-cvtRBMinVBlank) / float32(height)
+	if hperiod <= 0 {
+		return Mode{}, fmt.Errorf("%w: %dx%d@%g leaves no time for blanking", ErrInvalid, width, height, refresh)
+	}
+	vblank := int(cvtRBMinVBlank/hperiod + 1)
+	if vblank < cvtRBVFrontPorch+vsync+cvtMinVBackPorch {
+		vblank = cvtRBVFrontPorch + vsync + cvtMinVBackPorch
+	}
+	ret.VTotal = height + vblank
+	ret.HTotal = ret.HDisplay + cvtRBHBlank
+
+	ret.HSyncEnd = ret.HDisplay + cvtRBHBlank/2
+	ret.HSyncStart = ret.HSyncEnd - cvtRBHSync
+	ret.VSyncStart = ret.VDisplay + cvtRBVFrontPorch
+	ret.VSyncEnd = ret.VSyncStart + vsync
+
+	ret.Clock = cvtClock(ret.HTotal, hperiod)
+	return ret, nil
+}
+
+// CVTReducedV2 calculates timings with CVT reduced blanking v2, which has a
+// shorter horizontal blanking interval and a pixel clock precise to 1 kHz.
+// Unlike CVT v1, the width is not rounded.
+func CVTReducedV2(width, height int, refresh float64) (Mode, error) {
+	if err := validate(width, height, refresh); err != nil {
+		return Mode{}, err
+	}
+	ret := Mode{
+		HDisplay: width,
+		VDisplay: height,
+		Flags:    HSyncPositive | VSyncNegative,
+	}
+
+	hperiod := (1e6/refresh - cvtRBMinVBlank) / float64(height)
+	if hperiod <= 0 {
+		return Mode{}, fmt.Errorf("%w: %dx%d@%g leaves no time for blanking", ErrInvalid, width, height, refresh)
+	}
+	vblank := int(math.Floor(cvtRBMinVBlank/hperiod)) + 1
+	if vblank < cvtRB2VFrontPorch+cvtRB2VSync+cvtMinVBackPorch {
+		vblank = cvtRB2VFrontPorch + cvtRB2VSync + cvtMinVBackPorch
+	}
+	ret.VTotal = height + vblank
+	ret.HTotal = width + cvtRB2HBlank
+
+	ret.HSyncStart = width + cvtRB2HFrontPorch
+	ret.HSyncEnd = ret.HSyncStart + cvtRBHSync
+	ret.VSyncStart = height + vblank - cvtRB2VSync - cvtMinVBackPorch
+	ret.VSyncEnd = ret.VSyncStart + cvtRB2VSync
+
+	ret.Clock = uint32(math.Floor(refresh * float64(ret.VTotal) * float64(ret.HTotal) / 1000))
+	return ret, nil
+}
+
+// cvtRoundWidth rounds the width up to the character cell granularity. The X
+// server rounds down, but cvt(1) rounds up before calling it, e.g. 1366 is
+// turned into 1368.
+func cvtRoundWidth(width int) int {
+	return (width + cellGranularity - 1) / cellGranularity * cellGranularity
+}
+
+// cvtClock returns the pixel clock in kHz for hperiod in microseconds, rounded
+// down to the CVT clock step.
+func cvtClock(htotal int, hperiod float32) uint32 {
+	clock := int(float64(htotal) * 1000.0 / float64(hperiod))
+	return uint32(clock - clock%cvtClockStep)
+}
+
+// GTF calculates timings with the GTF default secondary curve. The width is
+// rounded to the nearest multiple of 8.
+func GTF(width, height int, refresh float64) (Mode, error) {
+	if err := validate(width, height, refresh); err != nil {
+		return Mode{}, err
+	}
+	// Single precision, as in gtf(1).
+	rate := float32(refresh)
+	hDisplay := float32(math.RoundToEven(float64(width)/gtfCellGranularity) * gtfCellGranularity)
+	vDisplay := float32(height)
+
+	hperiodEst := float32((1.0/float64(rate) - minVSyncBackPorch/1e6) /
+		float64(vDisplay+gtfMinPorch) * 1e6)
+	vsyncBackPorch := float32(math.RoundToEven(minVSyncBackPorch / float64(hperiodEst)))
+	vtotal := vDisplay + vsyncBackPorch + gtfMinPorch
+	rateEst := float32(1.0 / float64(hperiodEst) / float64(vtotal) * 1e6)
+	hperiod := hperiodEst / (rate / rateEst)
+	if hperiod <= 0 || vsyncBackPorch < gtfVSync {
+		return Mode{}, fmt.Errorf("%w: %dx%d@%g leaves no time for blanking", ErrInvalid, width, height, refresh)
+	}
+
+	dutyCycle := float32(blankingCPrime - blankingMPrime*float64(hperiod)/1000.0)
+	hblank := float32(math.RoundToEven(float64(hDisplay*dutyCycle)/(100.0-float64(dutyCycle))/(2*gtfCellGranularity)) *
+		(2 * gtfCellGranularity))
+	htotal := hDisplay + hblank
+	clock := htotal / hperiod // in MHz
+	hsync := float32(math.RoundToEven(gtfHSyncPercent/100.0*float64(htotal)/gtfCellGranularity) * gtfCellGranularity)
+	hfrontPorch := hblank/2 - hsync
+
+	return Mode{
+		Clock:      uint32(math.Round(float64(clock) * 1000)),
+		HDisplay:   int(hDisplay),
+		HSyncStart: int(hDisplay + hfrontPorch),
+		HSyncEnd:   int(hDisplay + hfrontPorch + hsync),
+		HTotal:     int(htotal),
+		VDisplay:   int(vDisplay),
+		VSyncStart: int(vDisplay + gtfMinPorch),
+		VSyncEnd:   int(vDisplay + gtfMinPorch + gtfVSync),
+		VTotal:     int(vtotal),
+		Flags:      HSyncNegative | VSyncPositive,
+	}, nil
+}
+
+// Refresh returns the refresh rate in Hz.
+func (m Mode) Refresh() float64 {
+	if m.HTotal == 0 || m.VTotal == 0 {
+		return 0
+	}
+	return float64(m.Clock) * 1000 / float64(m.HTotal*m.VTotal)
+}
+
+// HFreq returns the horizontal frequency in kHz.
+func (m Mode) HFreq() float64 {
+	if m.HTotal == 0 {
+		return 0
+	}
+	return float64(m.Clock) / float64(m.HTotal)
+}
+
+// Name returns a name in the style of cvt(1), e.g. 1920x1080_59.96. Unlike
+// cvt(1), the actual refresh rate is used rather than the requested one.
+func (m Mode) Name() string {
+	return fmt.Sprintf("%dx%d_%.2f", m.HDisplay, m.VDisplay, m.Refresh())
+}
+
+// String formats the mode as an xorg.conf modeline.
+func (m Mode) String() string {
+	hsync, vsync := "-hsync", "-vsync"
+	if m.Flags&HSyncPositive != 0 {
+		hsync = "+hsync"
+	}
+	if m.Flags&VSyncPositive != 0 {
+		vsync = "+vsync"
+	}
+	return fmt.Sprintf("Modeline %q %.2f %d %d %d %d %d %d %d %d %s %s", m.Name(), float64(m.Clock)/1000,
+		m.HDisplay, m.HSyncStart, m.HSyncEnd, m.HTotal, m.VDisplay, m.VSyncStart, m.VSyncEnd, m.VTotal, hsync, vsync)
+}
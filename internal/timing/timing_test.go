@@ -0,0 +1,95 @@
+package timing
+
+import (
+	"errors"
+	"testing"
+)
+
+// Golden modes are the output of cvt(1), cvt -r and gtf(1) from the X server,
+// and of the VESA CVT 1.2 spreadsheet for reduced blanking v2. The tools print
+// the pixel clock to 10 kHz.
+var golden = []struct {
+	name    string
+	fn      func(int, int, float64) (Mode, error)
+	width   int
+	height  int
+	refresh float64
+	want    Mode
+}{
+	// cvt 1920 1080 60
+	{"CVT", CVT, 1920, 1080, 60, Mode{173000, 1920, 2048, 2248, 2576, 1080, 1083, 1088, 1120, HSyncNegative | VSyncPositive}},
+	// cvt 1280 720 60
+	{"CVT", CVT, 1280, 720, 60, Mode{74500, 1280, 1344, 1472, 1664, 720, 723, 728, 748, HSyncNegative | VSyncPositive}},
+	// cvt 1024 768 60
+	{"CVT", CVT, 1024, 768, 60, Mode{63500, 1024, 1072, 1176, 1328, 768, 771, 775, 798, HSyncNegative | VSyncPositive}},
+	// cvt 800 600 60
+	{"CVT", CVT, 800, 600, 60, Mode{38250, 800, 832, 912, 1024, 600, 603, 607, 624, HSyncNegative | VSyncPositive}},
+	// cvt 1280 1024 60
+	{"CVT", CVT, 1280, 1024, 60, Mode{109000, 1280, 1368, 1496, 1712, 1024, 1027, 1034, 1063, HSyncNegative | VSyncPositive}},
+	// cvt 1920 1200 60
+	{"CVT", CVT, 1920, 1200, 60, Mode{193250, 1920, 2056, 2256, 2592, 1200, 1203, 1209, 1245, HSyncNegative | VSyncPositive}},
+	// cvt 2560 1440 60
+	{"CVT", CVT, 2560, 1440, 60, Mode{312250, 2560, 2752, 3024, 3488, 1440, 1443, 1448, 1493, HSyncNegative | VSyncPositive}},
+	// cvt 3840 2160 60
+	{"CVT", CVT, 3840, 2160, 60, Mode{712750, 3840, 4160, 4576, 5312, 2160, 2163, 2168, 2237, HSyncNegative | VSyncPositive}},
+	// cvt 1366 768 60, whose width is rounded up to 1368
+	{"CVT", CVT, 1366, 768, 60, Mode{85250, 1368, 1440, 1576, 1784, 768, 771, 781, 798, HSyncNegative | VSyncPositive}},
+
+	// cvt -r 1920 1080 60
+	{"CVTReduced", CVTReduced, 1920, 1080, 60, Mode{138500, 1920, 1968, 2000, 2080, 1080, 1083, 1088, 1111, HSyncPositive | VSyncNegative}},
+	// cvt -r 1280 720 60
+	{"CVTReduced", CVTReduced, 1280, 720, 60, Mode{63750, 1280, 1328, 1360, 1440, 720, 723, 728, 741, HSyncPositive | VSyncNegative}},
+	// cvt -r 2560 1440 60
+	{"CVTReduced", CVTReduced, 2560, 1440, 60, Mode{241500, 2560, 2608, 2640, 2720, 1440, 1443, 1448, 1481, HSyncPositive | VSyncNegative}},
+	// cvt -r 3840 2160 60
+	{"CVTReduced", CVTReduced, 3840, 2160, 60, Mode{533000, 3840, 3888, 3920, 4000, 2160, 2163, 2168, 2222, HSyncPositive | VSyncNegative}},
+	// cvt -r 1366 768 60
+	{"CVTReduced", CVTReduced, 1366, 768, 60, Mode{72250, 1368, 1416, 1448, 1528, 768, 771, 781, 790, HSyncPositive | VSyncNegative}},
+
+	{"CVTReducedV2", CVTReducedV2, 1920, 1080, 60, Mode{133320, 1920, 1928, 1960, 2000, 1080, 1097, 1105, 1111, HSyncPositive | VSyncNegative}},
+
+	// gtf 1920 1080 60
+	{"GTF", GTF, 1920, 1080, 60, Mode{172800, 1920, 2040, 2248, 2576, 1080, 1081, 1084, 1118, HSyncNegative | VSyncPositive}},
+	// gtf 1280 720 60
+	{"GTF", GTF, 1280, 720, 60, Mode{74480, 1280, 1336, 1472, 1664, 720, 721, 724, 746, HSyncNegative | VSyncPositive}},
+	// gtf 1024 768 60
+	{"GTF", GTF, 1024, 768, 60, Mode{64110, 1024, 1080, 1184, 1344, 768, 769, 772, 795, HSyncNegative | VSyncPositive}},
+	// gtf 800 600 60
+	{"GTF", GTF, 800, 600, 60, Mode{38220, 800, 832, 912, 1024, 600, 601, 604, 622, HSyncNegative | VSyncPositive}},
+	// gtf 1280 1024 60
+	{"GTF", GTF, 1280, 1024, 60, Mode{108880, 1280, 1360, 1496, 1712, 1024, 1025, 1028, 1060, HSyncNegative | VSyncPositive}},
+	// gtf 1366 768 60
+	{"GTF", GTF, 1366, 768, 60, Mode{85860, 1368, 1440, 1584, 1800, 768, 769, 772, 795, HSyncNegative | VSyncPositive}},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tt := range golden {
+		got, err := tt.fn(tt.width, tt.height, tt.refresh)
+		if err != nil {
+			t.Errorf("%s(%d, %d, %g): %s", tt.name, tt.width, tt.height, tt.refresh, err)
+			continue
+		}
+		// Round the clock as the tools print it.
+		got.Clock = (got.Clock + 5) / 10 * 10
+		if got != tt.want {
+			t.Errorf("%s(%d, %d, %g):\ngot  %s\nwant %s", tt.name, tt.width, tt.height, tt.refresh, got, tt.want)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, fn := range []func(int, int, float64) (Mode, error){CVT, CVTReduced, CVTReducedV2, GTF} {
+		for _, args := range []struct {
+			width, height int
+			refresh       float64
+		}{
+			{0, 1080, 60},
+			{1920, -1, 60},
+			{1920, 1080, 0},
+		} {
+			if _, err := fn(args.width, args.height, args.refresh); !errors.Is(err, ErrInvalid) {
+				t.Errorf("%dx%d@%g: got %v, want ErrInvalid", args.width, args.height, args.refresh, err)
+			}
+		}
+	}
+}
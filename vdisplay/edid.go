@@ -0,0 +1,69 @@
+package vdisplay
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/inahga/vdisplay/internal/edid"
+	"github.com/inahga/vdisplay/internal/timing"
+)
+
+const (
+	edidManufacturer = "VDP"
+	edidProductCode  = 0x0001
+	edidName         = "vdisplay"
+
+	// Virtual displays pretend to be 96 DPI.
+	edidDPI = 96
+)
+
+var edidSerial uint32
+
+// newEDIDSerial returns a serial number unique to this process, identifying a
+// virtual display across mode changes.
+func newEDIDSerial() uint32 {
+	return atomic.AddUint32(&edidSerial, 1)
+}
+
+// newEDID generates the identity of a virtual display, with mode as its
+// preferred timing. Modes too large for the base block are described in a
+// DisplayID extension.
+func newEDID(serial uint32, mode Mode) ([]byte, error) {
+	t, err := mode.timing()
+	if err != nil {
+		return nil, err
+	}
+	timing := t.DetailedTiming()
+	timing.WidthMM = int(mode.Width) * 254 / (edidDPI * 10)
+	timing.HeightMM = int(mode.Height) * 254 / (edidDPI * 10)
+
+	year, week := time.Now().ISOWeek()
+	e := &edid.EDID{
+		Manufacturer: edidManufacturer,
+		ProductCode:  edidProductCode,
+		SerialNumber: serial,
+		Week:         week,
+		Year:         year,
+		VideoInput:   edid.DigitalInput,
+		WidthCM:      (timing.WidthMM + 5) / 10,
+		HeightCM:     (timing.HeightMM + 5) / 10,
+		Gamma:        2.2,
+		Features:     edid.FeaturesDefault,
+		Chromaticity: edid.SRGBChromaticity,
+		Name:         edidName,
+		SerialString: fmt.Sprintf("%08d", serial),
+	}
+	if timing.FitsDescriptor() {
+		e.DetailedTimings = []edid.DetailedTiming{timing}
+	} else {
+		e.DisplayID = []edid.DisplayID{{Timings: []edid.DetailedTiming{timing}}}
+	}
+	return e.MarshalBinary()
+}
+
+// timing calculates CVT reduced blanking timings for the mode, which is what
+// flat panels report.
+func (m Mode) timing() (timing.Mode, error) {
+	return timing.CVTReduced(int(m.Width), int(m.Height), float64(m.RefreshRate))
+}
@@ -0,0 +1,46 @@
+package vdisplay
+
+import (
+	"testing"
+
+	"github.com/inahga/vdisplay/internal/edid"
+)
+
+func TestNewEDID(t *testing.T) {
+	for _, tt := range []struct {
+		mode Mode
+		// displayID is whether the preferred timing is too large for the
+		// base block, which is limited to 4095 pixels.
+		displayID bool
+	}{
+		{Mode{1920, 1080, 60}, false},
+		{Mode{3840, 2160, 60}, false},
+		// CVT rounds 4095 up to 4096.
+		{Mode{4095, 2160, 60}, true},
+		{Mode{5120, 2880, 60}, true},
+	} {
+		b, err := newEDID(7, tt.mode)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.mode, err)
+		}
+		e, err := edid.Parse(b)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.mode, err)
+		}
+		if e.Manufacturer != edidManufacturer || e.SerialNumber != 7 || e.Name != edidName || e.SerialString != "00000007" {
+			t.Errorf("%s: got %s %d %q %q", tt.mode, e.Manufacturer, e.SerialNumber, e.Name, e.SerialString)
+		}
+		if got := len(e.DisplayID) == 1 && len(e.DetailedTimings) == 0; got != tt.displayID {
+			t.Errorf("%s: got %d DisplayID extensions and %d detailed timings", tt.mode, len(e.DisplayID), len(e.DetailedTimings))
+		}
+
+		tm, _ := tt.mode.timing()
+		want := tm.DetailedTiming()
+		got, ok := e.Preferred()
+		// DisplayID timings have no image size.
+		got.WidthMM, got.HeightMM = 0, 0
+		if !ok || got != want {
+			t.Errorf("%s: got preferred timing %+v, want %+v", tt.mode, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package vdisplay
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// EventType identifies what happened to a display.
+type EventType int
+
+const (
+	// EventConnected is sent once the display is up after Create, and whenever
+	// it comes back after being disconnected.
+	EventConnected EventType = iota + 1
+	// EventModeChanged is sent when the mode of the display changes, either
+	// through Resize or by another client of the display server.
+	EventModeChanged
+	// EventDisconnected is sent when the display is removed or turned off by
+	// someone other than the owner of the handle. The handle must still be
+	// destroyed.
+	EventDisconnected
+	// EventError is sent when the display can no longer be monitored. No
+	// further events follow.
+	EventError
+)
+
+// Event is a change in the state of a display.
+type Event struct {
+	Type EventType
+	// Mode is the mode of the display after a Connected or ModeChanged event.
+	Mode Mode
+	// Err is why monitoring stopped, for Error events.
+	Err error
+}
+
+// eventBuffer is how many events are kept for a slow reader before further
+// events are dropped.
+const eventBuffer = 16
+
+// eventQueue delivers the events of a display without ever blocking the
+// backend.
+type eventQueue struct {
+	lock   sync.Mutex
+	ch     chan Event
+	closed bool
+}
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventModeChanged:
+		return "mode changed"
+	case EventDisconnected:
+		return "disconnected"
+	case EventError:
+		return "error"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case EventConnected, EventModeChanged:
+		return fmt.Sprintf("%s: %s", e.Type, e.Mode)
+	case EventError:
+		return fmt.Sprintf("%s: %s", e.Type, e.Err)
+	}
+	return e.Type.String()
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{ch: make(chan Event, eventBuffer)}
+}
+
+func (q *eventQueue) send(e Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.ch <- e:
+	default:
+		log.Printf("vdisplay: dropped %s event, events are not being read", e.Type)
+	}
+}
+
+// close closes the channel. Events sent afterwards are discarded.
+func (q *eventQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
@@ -2,12 +2,323 @@
 
 package vdisplay
 
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/inahga/vdisplay/capture"
+)
+
 // Mutter uses the GNOME Window Manager.
 //
 // Creation of a virtual display is supported in GNOME 40.
 // See https://gitlab.gnome.org/GNOME/mutter/-/merge_requests/1698.
-type Mutter struct{}
+//
+// A virtual monitor is created by calling RecordVirtual on a screen cast
+// session that is tied to a remote desktop session. These are private Mutter
+// APIs, so no portal dialog is shown. See
+// https://gitlab.gnome.org/GNOME/mutter/-/blob/main/data/dbus-interfaces/org.gnome.Mutter.ScreenCast.xml
+type Mutter struct {
+	conn *dbus.Conn
+}
+
+// MutterDisplay is a virtual monitor created by Mutter. The monitor only exists
+// while its PipeWire stream is being consumed, and takes the size and refresh
+// rate negotiated by the consumer, which Capture asks to be those of the mode.
+// Mutter does not accept an EDID for virtual monitors.
+type MutterDisplay struct {
+	// lock guards mutter, which Destroy clears.
+	lock      sync.Mutex
+	mutter    *Mutter
+	rdSession dbus.ObjectPath
+	scSession dbus.ObjectPath
+	stream    dbus.ObjectPath
+	nodeID    uint32
+	mode      Mode
+	events    *eventQueue
+	signals   chan *dbus.Signal
+	done      chan struct{}
+}
+
+type vardict = map[string]dbus.Variant
+
+const (
+	mutterRemoteDesktopDest    = "org.gnome.Mutter.RemoteDesktop"
+	mutterRemoteDesktopPath    = "/org/gnome/Mutter/RemoteDesktop"
+	mutterRemoteDesktopSession = "org.gnome.Mutter.RemoteDesktop.Session"
+
+	mutterScreenCastDest    = "org.gnome.Mutter.ScreenCast"
+	mutterScreenCastPath    = "/org/gnome/Mutter/ScreenCast"
+	mutterScreenCastSession = "org.gnome.Mutter.ScreenCast.Session"
+	mutterScreenCastStream  = "org.gnome.Mutter.ScreenCast.Stream"
+
+	mutterCursorModeEmbedded uint32 = 1
+
+	dbusNameOwnerChanged = "org.freedesktop.DBus.NameOwnerChanged"
+)
+
+func init() {
+	Register("mutter", probeMutter)
+}
+
+// probeMutter checks that the Mutter remote desktop and screen cast services
+// are on the session bus.
+func probeMutter() (VDisplay, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("mutter: dbus: %w", err)
+	}
+	return newMutter(conn)
+}
+
+func newMutter(conn *dbus.Conn) (*Mutter, error) {
+	for _, name := range []string{mutterRemoteDesktopDest, mutterScreenCastDest} {
+		var ok bool
+		if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&ok); err != nil {
+			return nil, fmt.Errorf("mutter: NameHasOwner: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("mutter: %s is not on the session bus, is GNOME running?", name)
+		}
+	}
+	return &Mutter{conn: conn}, nil
+}
+
+func (m *Mutter) Create(ctx context.Context, mode Mode) (Display, error) {
+	if err := mode.validate(); err != nil {
+		return nil, fmt.Errorf("mutter: %w", err)
+	}
+
+	ret := &MutterDisplay{mutter: m, mode: mode, events: newEventQueue()}
+	if err := m.conn.Object(mutterRemoteDesktopDest, mutterRemoteDesktopPath).
+		CallWithContext(ctx, mutterRemoteDesktopDest+".CreateSession", 0).
+		Store(&ret.rdSession); err != nil {
+		return nil, fmt.Errorf("mutter: CreateSession: %w", err)
+	}
+	if err := ret.start(ctx); err != nil {
+		ret.Destroy()
+		return nil, fmt.Errorf("mutter: %w", err)
+	}
+	ret.events.send(Event{Type: EventConnected, Mode: mode})
+	if err := ret.watch(ctx); err != nil {
+		ret.Destroy()
+		return nil, fmt.Errorf("mutter: %w", err)
+	}
+	return ret, nil
+}
 
 func (m *Mutter) priority() int {
 	return 50
 }
+
+// start records a virtual monitor in a new screen cast session, then starts the
+// remote desktop session and waits for the PipeWire node to be announced.
+func (d *MutterDisplay) start(ctx context.Context) error {
+	conn := d.mutter.conn
+	rd := conn.Object(mutterRemoteDesktopDest, d.rdSession)
+
+	sessionID, err := rd.GetProperty(mutterRemoteDesktopSession + ".SessionId")
+	if err != nil {
+		return fmt.Errorf("get SessionId: %w", err)
+	}
+	if err := conn.Object(mutterScreenCastDest, mutterScreenCastPath).
+		CallWithContext(ctx, mutterScreenCastDest+".CreateSession", 0, vardict{
+			"remote-desktop-session-id": sessionID,
+		}).Store(&d.scSession); err != nil {
+		return fmt.Errorf("screen cast CreateSession: %w", err)
+	}
+	if err := conn.Object(mutterScreenCastDest, d.scSession).
+		CallWithContext(ctx, mutterScreenCastSession+".RecordVirtual", 0, vardict{
+			"cursor-mode": dbus.MakeVariant(mutterCursorModeEmbedded),
+		}).Store(&d.stream); err != nil {
+		return fmt.Errorf("RecordVirtual: %w", err)
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(d.stream),
+		dbus.WithMatchInterface(mutterScreenCastStream),
+		dbus.WithMatchMember("PipeWireStreamAdded"),
+	}
+	if err := conn.AddMatchSignalContext(ctx, match...); err != nil {
+		return fmt.Errorf("add match: %w", err)
+	}
+	defer conn.RemoveMatchSignal(match...)
+	signal := make(chan *dbus.Signal, 1)
+	conn.Signal(signal)
+	defer conn.RemoveSignal(signal)
+
+	if err := rd.CallWithContext(ctx, mutterRemoteDesktopSession+".Start", 0).Err; err != nil {
+		return fmt.Errorf("Start: %w", err)
+	}
+	for {
+		select {
+		case s, ok := <-signal:
+			if !ok {
+				return fmt.Errorf("dbus connection closed")
+			}
+			if s.Path != d.stream || s.Name != mutterScreenCastStream+".PipeWireStreamAdded" {
+				continue
+			}
+			if len(s.Body) != 1 {
+				return fmt.Errorf("PipeWireStreamAdded: unexpected body %v", s.Body)
+			}
+			nodeID, ok := s.Body[0].(uint32)
+			if !ok {
+				return fmt.Errorf("PipeWireStreamAdded: unexpected body %v", s.Body)
+			}
+			d.nodeID = nodeID
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// NodeID returns the PipeWire node carrying the contents of the display.
+func (d *MutterDisplay) NodeID() uint32 {
+	return d.nodeID
+}
+
+func (d *MutterDisplay) Mode() Mode {
+	return d.mode
+}
+
+// Resize fails with ErrUnsupported unless mode is the current mode. Mutter sizes
+// the virtual monitor from the video format negotiated on its PipeWire stream,
+// which is fixed once the stream is connected, so the display has to be
+// created again with the new mode.
+func (d *MutterDisplay) Resize(mode Mode) error {
+	if !d.alive() {
+		return fmt.Errorf("mutter: %w", ErrDestroyed)
+	}
+	if mode != d.mode {
+		return fmt.Errorf("mutter: %w: resizing a virtual monitor", ErrUnsupported)
+	}
+	return nil
+}
+
+// Capture returns a capture of the PipeWire stream of the virtual monitor, which
+// always carries the whole display. The stream is negotiated in the mode of
+// the display, at most at its refresh rate.
+func (d *MutterDisplay) Capture() (capture.Capture, error) {
+	if !d.alive() {
+		return nil, fmt.Errorf("mutter: %w", ErrDestroyed)
+	}
+	ret, err := mutterCapture(d.nodeID, d.mode)
+	if err != nil {
+		return nil, fmt.Errorf("mutter: %w", err)
+	}
+	return ret, nil
+}
+
+// alive reports whether the display hasn't been destroyed.
+func (d *MutterDisplay) alive() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.mutter != nil
+}
+
+func (d *MutterDisplay) Events() <-chan Event {
+	return d.events.ch
+}
+
+// watchMatches are the signals announcing that the virtual monitor is gone:
+// either session closing, or Mutter leaving the bus.
+func (d *MutterDisplay) watchMatches() [][]dbus.MatchOption {
+	return [][]dbus.MatchOption{
+		{
+			dbus.WithMatchObjectPath(d.rdSession),
+			dbus.WithMatchInterface(mutterRemoteDesktopSession),
+			dbus.WithMatchMember("Closed"),
+		},
+		{
+			dbus.WithMatchObjectPath(d.scSession),
+			dbus.WithMatchInterface(mutterScreenCastSession),
+			dbus.WithMatchMember("Closed"),
+		},
+		{
+			dbus.WithMatchSender("org.freedesktop.DBus"),
+			dbus.WithMatchInterface("org.freedesktop.DBus"),
+			dbus.WithMatchMember("NameOwnerChanged"),
+			dbus.WithMatchArg(0, mutterScreenCastDest),
+		},
+	}
+}
+
+// watch reports the display as disconnected when its sessions close, until the
+// display is destroyed.
+func (d *MutterDisplay) watch(ctx context.Context) error {
+	conn := d.mutter.conn
+	for _, match := range d.watchMatches() {
+		if err := conn.AddMatchSignalContext(ctx, match...); err != nil {
+			return fmt.Errorf("add match: %w", err)
+		}
+	}
+	d.signals, d.done = make(chan *dbus.Signal, eventBuffer), make(chan struct{})
+	conn.Signal(d.signals)
+
+	go func() {
+		for {
+			select {
+			case s, ok := <-d.signals:
+				if !ok {
+					d.events.send(Event{Type: EventError, Err: fmt.Errorf("mutter: dbus connection closed")})
+					return
+				}
+				if d.closedBy(s) {
+					d.events.send(Event{Type: EventDisconnected})
+					return
+				}
+			case <-d.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// closedBy reports whether s means the virtual monitor was removed.
+func (d *MutterDisplay) closedBy(s *dbus.Signal) bool {
+	switch s.Name {
+	case mutterRemoteDesktopSession + ".Closed":
+		return s.Path == d.rdSession
+	case mutterScreenCastSession + ".Closed":
+		return s.Path == d.scSession
+	case dbusNameOwnerChanged:
+		if len(s.Body) != 3 {
+			return false
+		}
+		name, _ := s.Body[0].(string)
+		owner, _ := s.Body[2].(string)
+		return name == mutterScreenCastDest && owner == ""
+	}
+	return false
+}
+
+// Destroy stops the remote desktop session, which also closes the screen cast
+// session and removes the virtual monitor.
+func (d *MutterDisplay) Destroy() error {
+	d.lock.Lock()
+	if d.mutter == nil {
+		d.lock.Unlock()
+		return fmt.Errorf("mutter: %w", ErrDestroyed)
+	}
+	conn := d.mutter.conn
+	d.mutter = nil
+	d.lock.Unlock()
+	if d.done != nil {
+		close(d.done)
+		conn.RemoveSignal(d.signals)
+		for _, match := range d.watchMatches() {
+			conn.RemoveMatchSignal(match...)
+		}
+	}
+	d.events.close()
+	if err := conn.Object(mutterRemoteDesktopDest, d.rdSession).
+		Call(mutterRemoteDesktopSession+".Stop", 0).Err; err != nil {
+		return fmt.Errorf("mutter: Stop: %w", err)
+	}
+	return nil
+}
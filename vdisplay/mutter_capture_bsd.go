@@ -0,0 +1,11 @@
+//go:build freebsd || openbsd || dragonfly
+
+package vdisplay
+
+import "github.com/inahga/vdisplay/capture"
+
+// mutterCapture is not implemented, as the PipeWire capture backend is only
+// built on Linux.
+func mutterCapture(uint32, Mode) (capture.Capture, error) {
+	return nil, ErrNotImplemented
+}
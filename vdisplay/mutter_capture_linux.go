@@ -0,0 +1,10 @@
+//go:build linux && cgo
+
+package vdisplay
+
+import "github.com/inahga/vdisplay/capture"
+
+// mutterCapture consumes the PipeWire node of a virtual monitor in mode.
+func mutterCapture(nodeID uint32, mode Mode) (capture.Capture, error) {
+	return capture.NewPipewireNode(nodeID, mode.Width, mode.Height, mode.RefreshRate)
+}
@@ -0,0 +1,14 @@
+//go:build linux && !cgo
+
+package vdisplay
+
+import (
+	"fmt"
+
+	"github.com/inahga/vdisplay/capture"
+)
+
+// mutterCapture is unsupported, as the PipeWire capture backend needs cgo.
+func mutterCapture(uint32, Mode) (capture.Capture, error) {
+	return nil, fmt.Errorf("%w: PipeWire capture needs cgo", ErrUnsupported)
+}
@@ -0,0 +1,271 @@
+//go:build linux || freebsd || openbsd || dragonfly
+
+package vdisplay
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const testBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+const (
+	testNodeID    = 42
+	testSessionID = "fake-session"
+
+	fakeRDSession = dbus.ObjectPath("/org/gnome/Mutter/RemoteDesktop/Session/1")
+	fakeSCSession = dbus.ObjectPath("/org/gnome/Mutter/ScreenCast/Session/1")
+	fakeStream    = dbus.ObjectPath("/org/gnome/Mutter/ScreenCast/Stream/1")
+)
+
+// fakeMutter serves the parts of the Mutter remote desktop and screen cast
+// APIs used by the backend, for a single session.
+type fakeMutter struct {
+	conn *dbus.Conn
+
+	lock    sync.Mutex
+	started bool
+	stopped bool
+	options vardict
+}
+
+type (
+	fakeRemoteDesktop        struct{ *fakeMutter }
+	fakeRemoteDesktopSession struct{ *fakeMutter }
+	fakeScreenCast           struct{ *fakeMutter }
+	fakeScreenCastSession    struct{ *fakeMutter }
+	fakeProperties           struct{}
+)
+
+// startBus runs a private dbus-daemon for the test, returning its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(testBusConfig, dir)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("dbus-daemon", "--config-file="+config, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dbus-daemon address: %s", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connectBus(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newFakeMutter(t *testing.T, address string) *fakeMutter {
+	t.Helper()
+	f := &fakeMutter{conn: connectBus(t, address)}
+	for _, export := range []struct {
+		v     interface{}
+		path  dbus.ObjectPath
+		iface string
+	}{
+		{fakeRemoteDesktop{f}, mutterRemoteDesktopPath, mutterRemoteDesktopDest},
+		{fakeRemoteDesktopSession{f}, fakeRDSession, mutterRemoteDesktopSession},
+		{fakeProperties{}, fakeRDSession, "org.freedesktop.DBus.Properties"},
+		{fakeScreenCast{f}, mutterScreenCastPath, mutterScreenCastDest},
+		{fakeScreenCastSession{f}, fakeSCSession, mutterScreenCastSession},
+	} {
+		if err := f.conn.Export(export.v, export.path, export.iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{mutterRemoteDesktopDest, mutterScreenCastDest} {
+		reply, err := f.conn.RequestName(name, dbus.NameFlagDoNotQueue)
+		if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+			t.Fatalf("RequestName %s: %v %v", name, reply, err)
+		}
+	}
+	return f
+}
+
+func (f fakeRemoteDesktop) CreateSession() (dbus.ObjectPath, *dbus.Error) {
+	return fakeRDSession, nil
+}
+
+// Start announces the PipeWire node of the recorded virtual monitor.
+func (f fakeRemoteDesktopSession) Start() *dbus.Error {
+	f.lock.Lock()
+	f.started = true
+	f.lock.Unlock()
+	go f.conn.Emit(fakeStream, mutterScreenCastStream+".PipeWireStreamAdded", uint32(testNodeID))
+	return nil
+}
+
+// Stop closes both sessions, as Mutter does.
+func (f fakeRemoteDesktopSession) Stop() *dbus.Error {
+	f.lock.Lock()
+	f.stopped = true
+	f.lock.Unlock()
+	f.conn.Emit(fakeSCSession, mutterScreenCastSession+".Closed")
+	f.conn.Emit(fakeRDSession, mutterRemoteDesktopSession+".Closed")
+	return nil
+}
+
+func (fakeProperties) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	if iface != mutterRemoteDesktopSession || name != "SessionId" {
+		return dbus.Variant{}, dbus.MakeFailedError(os.ErrNotExist)
+	}
+	return dbus.MakeVariant(testSessionID), nil
+}
+
+func (f fakeScreenCast) CreateSession(options vardict) (dbus.ObjectPath, *dbus.Error) {
+	if id, _ := options["remote-desktop-session-id"].Value().(string); id != testSessionID {
+		return "", dbus.MakeFailedError(os.ErrInvalid)
+	}
+	return fakeSCSession, nil
+}
+
+func (f fakeScreenCastSession) RecordVirtual(options vardict) (dbus.ObjectPath, *dbus.Error) {
+	f.lock.Lock()
+	f.options = options
+	f.lock.Unlock()
+	return fakeStream, nil
+}
+
+func nextEvent(t *testing.T, events <-chan Event) (Event, bool) {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		return e, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}, false
+}
+
+func createMutterDisplay(t *testing.T) (*fakeMutter, *MutterDisplay) {
+	t.Helper()
+	address := startBus(t)
+	fake := newFakeMutter(t, address)
+	m, err := newMutter(connectBus(t, address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	display, err := m.Create(ctx, Mode{Width: 1920, Height: 1080, RefreshRate: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, display.(*MutterDisplay)
+}
+
+func TestMutterNotRunning(t *testing.T) {
+	address := startBus(t)
+	if _, err := newMutter(connectBus(t, address)); err == nil {
+		t.Fatal("newMutter succeeded without Mutter on the bus")
+	}
+}
+
+func TestMutterCreate(t *testing.T) {
+	fake, d := createMutterDisplay(t)
+	defer d.Destroy()
+
+	if d.NodeID() != testNodeID {
+		t.Errorf("NodeID() = %d, want %d", d.NodeID(), testNodeID)
+	}
+	fake.lock.Lock()
+	started, options := fake.started, fake.options
+	fake.lock.Unlock()
+	if !started {
+		t.Error("remote desktop session not started")
+	}
+	if mode, _ := options["cursor-mode"].Value().(uint32); mode != mutterCursorModeEmbedded {
+		t.Errorf("cursor-mode = %v, want %d", options["cursor-mode"], mutterCursorModeEmbedded)
+	}
+	if e, _ := nextEvent(t, d.Events()); e.Type != EventConnected || e.Mode != d.Mode() {
+		t.Errorf("first event is %s, want connected: %s", e, d.Mode())
+	}
+}
+
+func TestMutterEvents(t *testing.T) {
+	fake, d := createMutterDisplay(t)
+	defer d.Destroy()
+
+	events := d.Events()
+	nextEvent(t, events)
+	// Virtual monitors can't be resized, and no mode change is reported.
+	if err := d.Resize(Mode{Width: 1280, Height: 720, RefreshRate: 60}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Resize: got %v, want ErrUnsupported", err)
+	}
+	if err := d.Resize(d.Mode()); err != nil {
+		t.Errorf("Resize to the current mode: %s", err)
+	}
+	// Mutter closing the session on its own removes the monitor.
+	fake.conn.Emit(fakeRDSession, mutterRemoteDesktopSession+".Closed")
+	if e, _ := nextEvent(t, events); e.Type != EventDisconnected {
+		t.Errorf("got %s, want disconnected", e)
+	}
+}
+
+func TestMutterDestroy(t *testing.T) {
+	fake, d := createMutterDisplay(t)
+
+	events := d.Events()
+	nextEvent(t, events)
+	if err := d.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	stopped := fake.stopped
+	fake.lock.Unlock()
+	if !stopped {
+		t.Error("remote desktop session not stopped")
+	}
+	// The sessions closing because of Destroy are not reported.
+	if e, ok := nextEvent(t, events); ok {
+		t.Errorf("got %s after Destroy, want the channel closed", e)
+	}
+	if err := d.Destroy(); err == nil {
+		t.Error("second Destroy succeeded")
+	}
+}
@@ -0,0 +1,109 @@
+package vdisplay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Options controls backend selection in Open.
+type Options struct {
+	// Mode is the mode of the display to create.
+	Mode Mode
+	// Force restricts selection to the named backends. All backends are
+	// considered if empty.
+	Force []string
+	// Exclude skips the named backends.
+	Exclude []string
+	// Capture skips backends whose displays can't be captured on this
+	// machine.
+	Capture bool
+}
+
+// BackendError records why a backend was rejected by Open. Backends prefix
+// their errors with their name, so Err is reported as is.
+type BackendError struct {
+	Backend string
+	Err     error
+}
+
+// OpenError is returned by Open when no backend could create a display.
+type OpenError struct {
+	Errs []BackendError
+}
+
+func (e *BackendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+func (e *OpenError) Error() string {
+	if len(e.Errs) == 0 {
+		return "vdisplay: no backends available"
+	}
+	msgs := make([]string, len(e.Errs))
+	for i := range e.Errs {
+		msgs[i] = e.Errs[i].Error()
+	}
+	return "vdisplay: no usable backend: " + strings.Join(msgs, "; ")
+}
+
+// Open creates a display with the first backend that succeeds, trying backends
+// in descending priority. Excluded backends are never probed. With
+// Options.Capture, backends that report up front that they can't capture are
+// skipped before creating a display.
+func Open(ctx context.Context, opts Options) (Display, error) {
+	var (
+		oerr       OpenError
+		candidates []probed
+	)
+	for _, name := range opts.Force {
+		if lookup(name) == nil {
+			oerr.Errs = append(oerr.Errs, BackendError{Backend: name, Err: fmt.Errorf("%s: %w", name, ErrUnknownBackend)})
+		}
+	}
+	for _, b := range registered() {
+		if (len(opts.Force) > 0 && !contains(opts.Force, b.name)) || contains(opts.Exclude, b.name) {
+			oerr.Errs = append(oerr.Errs, BackendError{Backend: b.name, Err: fmt.Errorf("%s: %w", b.name, ErrExcluded)})
+			continue
+		}
+		p := b.probe()
+		if p.err != nil {
+			oerr.Errs = append(oerr.Errs, BackendError{Backend: b.name, Err: p.err})
+			continue
+		}
+		if c, ok := p.vdisplay.(captureChecker); opts.Capture && ok {
+			if err := c.checkCapture(); err != nil {
+				oerr.Errs = append(oerr.Errs, BackendError{Backend: b.name, Err: err})
+				continue
+			}
+		}
+		candidates = append(candidates, p)
+	}
+	sortByPriority(candidates)
+
+	for _, b := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		display, err := b.vdisplay.Create(ctx, opts.Mode)
+		if err != nil {
+			oerr.Errs = append(oerr.Errs, BackendError{Backend: b.name, Err: err})
+			continue
+		}
+		return display, nil
+	}
+	return nil, &oerr
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package vdisplay
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory probes for a backend, returning an error describing why it is
+// unusable on this machine.
+type Factory func() (VDisplay, error)
+
+// BackendInfo describes a registered backend and the outcome of probing it.
+type BackendInfo struct {
+	Name      string
+	Priority  int
+	Available bool
+	// Err is why the backend is not available.
+	Err error
+}
+
+type backend struct {
+	name    string
+	factory Factory
+
+	// lock guards vdisplay, which is only set once a probe succeeds. Failed
+	// probes are retried, as the backend may have become usable since, e.g.
+	// once a kernel module is loaded or the session bus is up.
+	lock     sync.Mutex
+	vdisplay VDisplay
+}
+
+// probed is the outcome of probing a backend.
+type probed struct {
+	*backend
+	vdisplay VDisplay
+	err      error
+}
+
+var (
+	registryLock sync.Mutex
+	registry     []*backend
+)
+
+// Register makes a backend available under the given name. The factory is not
+// called until the backend is first needed by Open, Available or Backends, and
+// is called again by each of them until it succeeds.
+// Register panics if the name is already registered.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if factory == nil {
+		panic("vdisplay: Register factory is nil")
+	}
+	for _, b := range registry {
+		if b.name == name {
+			panic(fmt.Sprintf("vdisplay: Register called twice for backend %q", name))
+		}
+	}
+	registry = append(registry, &backend{name: name, factory: factory})
+}
+
+func (b *backend) probe() probed {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.vdisplay == nil {
+		vd, err := b.factory()
+		if err != nil {
+			return probed{backend: b, err: err}
+		}
+		b.vdisplay = vd
+	}
+	return probed{backend: b, vdisplay: b.vdisplay}
+}
+
+func (p probed) priority() int {
+	if pr, ok := p.vdisplay.(prioritizer); ok {
+		return pr.priority()
+	}
+	return 0
+}
+
+// registered returns a snapshot of the registry.
+func registered() []*backend {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	ret := make([]*backend, len(registry))
+	copy(ret, registry)
+	return ret
+}
+
+// sortByPriority orders probed backends by descending priority.
+func sortByPriority(backends []probed) {
+	sort.SliceStable(backends, func(i, j int) bool {
+		return backends[i].priority() > backends[j].priority()
+	})
+}
+
+// probeAll probes every registered backend, in descending priority.
+func probeAll() []probed {
+	backends := registered()
+	ret := make([]probed, len(backends))
+	for i, b := range backends {
+		ret[i] = b.probe()
+	}
+	sortByPriority(ret)
+	return ret
+}
+
+// Backends probes every registered backend and reports whether it is available,
+// in descending priority.
+func Backends() []BackendInfo {
+	backends := probeAll()
+	ret := make([]BackendInfo, len(backends))
+	for i, b := range backends {
+		ret[i] = BackendInfo{
+			Name:      b.name,
+			Priority:  b.priority(),
+			Available: b.err == nil,
+			Err:       b.err,
+		}
+	}
+	return ret
+}
+
+// Available returns the virtual display handlers usable on this machine, in
+// descending priority.
+func Available() []VDisplay {
+	var ret []VDisplay
+	for _, b := range probeAll() {
+		if b.err == nil {
+			ret = append(ret, b.vdisplay)
+		}
+	}
+	return ret
+}
+
+func lookup(name string) *backend {
+	for _, b := range registered() {
+		if b.name == name {
+			return b
+		}
+	}
+	return nil
+}
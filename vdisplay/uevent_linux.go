@@ -0,0 +1,80 @@
+package vdisplay
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// uevent is a kernel device event, as broadcast on the kobject uevent netlink
+// socket.
+type uevent struct {
+	Action    string
+	Subsystem string
+	// DevName is the device node relative to /dev, e.g. dri/card1.
+	DevName string
+	Env     map[string]string
+}
+
+const (
+	// ueventKernelGroup is the multicast group of events sent by the kernel,
+	// as opposed to those rebroadcast by udev.
+	ueventKernelGroup = 1
+	ueventBufferSize  = 8192
+)
+
+// ueventSocket listens for kernel uevents. Closing it unblocks a pending read.
+type ueventSocket struct {
+	f *os.File
+}
+
+func openUeventSocket() (*ueventSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("uevent socket: %w", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventKernelGroup}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("uevent bind: %w", err)
+	}
+	return &ueventSocket{f: os.NewFile(uintptr(fd), "uevent")}, nil
+}
+
+// read returns the next uevent.
+func (s *ueventSocket) read() (*uevent, error) {
+	buf := make([]byte, ueventBufferSize)
+	for {
+		n, err := s.f.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("uevent read: %w", err)
+		}
+		if ev := parseUevent(buf[:n]); ev != nil {
+			return ev, nil
+		}
+	}
+}
+
+func (s *ueventSocket) close() error {
+	return s.f.Close()
+}
+
+// parseUevent decodes a message of the form "action@devpath\0KEY=value\0...",
+// returning nil for messages that are not uevents.
+func parseUevent(b []byte) *uevent {
+	fields := bytes.Split(bytes.TrimRight(b, "\x00"), []byte{0})
+	if len(fields) < 2 || !bytes.Contains(fields[0], []byte("@")) {
+		return nil
+	}
+	ret := &uevent{Env: make(map[string]string, len(fields)-1)}
+	for _, field := range fields[1:] {
+		kv := bytes.SplitN(field, []byte("="), 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ret.Env[string(kv[0])] = string(kv[1])
+	}
+	ret.Action, ret.Subsystem, ret.DevName = ret.Env["ACTION"], ret.Env["SUBSYSTEM"], ret.Env["DEVNAME"]
+	return ret
+}
@@ -2,9 +2,77 @@
 // supports.
 package vdisplay
 
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/inahga/vdisplay/capture"
+)
+
 // VDisplay is a virtual display handler.
 type VDisplay interface {
+	// Create creates a new virtual display with the requested mode.
+	Create(ctx context.Context, mode Mode) (Display, error)
+}
+
+// prioritizer is implemented by the built-in backends to order them ahead of
+// backends registered by other packages, which have priority 0.
+type prioritizer interface {
 	priority() int
 }
 
-var availableVDisplays []VDisplay
+// captureChecker is implemented by backends that can tell before creating a
+// display whether it could be captured. Other backends are assumed to support
+// capture.
+type captureChecker interface {
+	checkCapture() error
+}
+
+// Display is a handle to a virtual display created by a VDisplay.
+type Display interface {
+	// Mode returns the current mode of the display.
+	Mode() Mode
+	// Resize changes the mode of the display. Backends that can't change the
+	// mode of an existing display return ErrUnsupported.
+	Resize(mode Mode) error
+	// Capture returns a new capture of the display, to be closed by the
+	// caller. The rectangle given to Start is relative to the display, and
+	// the whole display is captured if it is empty. Backends that can only
+	// capture the whole display ignore it.
+	Capture() (capture.Capture, error)
+	// Events returns the changes to the display, starting with Connected. The
+	// channel is closed by Destroy.
+	Events() <-chan Event
+	// Destroy tears down the display. The handle must not be used afterwards.
+	Destroy() error
+}
+
+// Mode is a display resolution and refresh rate.
+type Mode struct {
+	Width       uint32
+	Height      uint32
+	RefreshRate uint32 // in Hz
+}
+
+var (
+	ErrNotImplemented  = errors.New("not implemented")
+	ErrUnsupported     = errors.New("not supported by the backend")
+	ErrInvalidMode     = errors.New("invalid mode")
+	ErrBusy            = errors.New("display already in use")
+	ErrDestroyed       = errors.New("display destroyed")
+	ErrExcluded        = errors.New("excluded by options")
+	ErrUnknownBackend  = errors.New("unknown backend")
+	ErrFeatureDisabled = errors.New("feature disabled")
+)
+
+func (m Mode) String() string {
+	return fmt.Sprintf("%dx%d@%d", m.Width, m.Height, m.RefreshRate)
+}
+
+func (m Mode) validate() error {
+	if m.Width == 0 || m.Height == 0 || m.RefreshRate == 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidMode, m)
+	}
+	return nil
+}
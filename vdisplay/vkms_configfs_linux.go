@@ -0,0 +1,133 @@
+package vdisplay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/inahga/vdisplay/internal/drm"
+)
+
+// vkmsDevice is a vkms device built through configfs. Each device is a separate
+// DRM card with its own planes, CRTC, encoder and connector.
+//
+// See https://docs.kernel.org/gpu/vkms.html#configuring-with-configfs.
+type vkmsDevice struct {
+	dir  string
+	name string
+}
+
+const (
+	vkmsConfigfsDir = "/sys/kernel/config/vkms"
+
+	vkmsPlaneTypeOverlay = "0"
+	vkmsPlaneTypePrimary = "1"
+	vkmsPlaneTypeCursor  = "2"
+)
+
+var vkmsDeviceCount uint32
+
+// vkmsConfigfsAvailable reports whether the kernel supports building vkms
+// devices through configfs.
+func vkmsConfigfsAvailable() bool {
+	info, err := os.Stat(vkmsConfigfsDir)
+	return err == nil && info.IsDir()
+}
+
+// createVKMSDevice builds and enables a device with a primary and cursor plane
+// on a single CRTC with writeback, driving one connector. The EDID is attached
+// to the connector if the kernel supports it.
+func createVKMSDevice(edid []byte) (*vkmsDevice, error) {
+	name := fmt.Sprintf("vdisplay-%d-%d", os.Getpid(), atomic.AddUint32(&vkmsDeviceCount, 1))
+	ret := &vkmsDevice{dir: filepath.Join(vkmsConfigfsDir, name), name: name}
+	if err := os.Mkdir(ret.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("configfs: %w", err)
+	}
+	if err := ret.build(edid); err != nil {
+		ret.remove()
+		return nil, fmt.Errorf("configfs: %w", err)
+	}
+	return ret, nil
+}
+
+func (d *vkmsDevice) build(edid []byte) error {
+	crtc := d.path("crtcs", "crtc0")
+	encoder := d.path("encoders", "encoder0")
+	for _, dir := range []string{
+		d.path("planes", "primary"),
+		d.path("planes", "cursor"),
+		crtc,
+		encoder,
+		d.path("connectors", "connector0"),
+	} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	for file, value := range map[string]string{
+		d.path("planes", "primary", "type"):   vkmsPlaneTypePrimary,
+		d.path("planes", "cursor", "type"):    vkmsPlaneTypeCursor,
+		d.path("crtcs", "crtc0", "writeback"): "1",
+	} {
+		if err := os.WriteFile(file, []byte(value), 0); err != nil {
+			return err
+		}
+	}
+	for link, target := range map[string]string{
+		d.path("planes", "primary", "possible_crtcs", "crtc0"):              crtc,
+		d.path("planes", "cursor", "possible_crtcs", "crtc0"):               crtc,
+		d.path("encoders", "encoder0", "possible_crtcs", "crtc0"):           crtc,
+		d.path("connectors", "connector0", "possible_encoders", "encoder0"): encoder,
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(d.path("connectors", "connector0", "edid")); err == nil {
+		if err := os.WriteFile(d.path("connectors", "connector0", "edid"), edid, 0); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(d.path("enabled"), []byte("1"), 0)
+}
+
+func (d *vkmsDevice) path(elem ...string) string {
+	return filepath.Join(append([]string{d.dir}, elem...)...)
+}
+
+// cardPath returns the primary node of the device once it is enabled.
+func (d *vkmsDevice) cardPath() (string, error) {
+	devices, err := drm.SysfsDevices(drm.SysfsDir)
+	if err != nil {
+		return "", err
+	}
+	for _, dev := range devices {
+		if dev.Parent == d.name {
+			return dev.PrimaryNode, nil
+		}
+	}
+	return "", fmt.Errorf("no card for vkms device %s", d.name)
+}
+
+// remove disables the device and tears down its configfs tree. Configfs only
+// allows removing a group once it is empty of links and children.
+func (d *vkmsDevice) remove() error {
+	if err := os.WriteFile(d.path("enabled"), []byte("0"), 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("configfs: %w", err)
+	}
+	links, _ := filepath.Glob(d.path("*", "*", "possible_*", "*"))
+	for _, link := range links {
+		os.Remove(link)
+	}
+	children, _ := filepath.Glob(d.path("*", "*"))
+	for _, child := range children {
+		if info, err := os.Lstat(child); err == nil && info.IsDir() {
+			os.Remove(child)
+		}
+	}
+	if err := os.Remove(d.dir); err != nil {
+		return fmt.Errorf("configfs: %w", err)
+	}
+	return nil
+}
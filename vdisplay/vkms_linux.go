@@ -1,10 +1,14 @@
 package vdisplay
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sync"
 
 	"github.com/inahga/vdisplay/capture"
 	"github.com/inahga/vdisplay/internal/drm"
@@ -13,60 +17,384 @@ import (
 // VKMS uses the `vkms` kernel module.
 //
 // See https://github.com/torvalds/linux/tree/master/drivers/gpu/drm/vkms.
+//
+// If the kernel supports it, every display is a separate vkms device built
+// through configfs. Otherwise, the single card created when the module was
+// loaded is used, which can only host one display.
 type VKMS struct {
-	card    *drm.Card
-	capture capture.Capture
+	configfs bool
+	card     *drm.Card
+	cardPath string
+	// sysfs is the card as listed in sysfs when it was probed.
+	sysfs    drm.SysfsDevice
+	features VKMSFeatures
+	display  *vkmsDisplay
+}
+
+// vkmsDisplay is the virtual connector of a vkms card. Create and Resize set
+// the mode on the card, which needs DRM master. Mode changes made by other
+// processes are not reported.
+type vkmsDisplay struct {
+	vkms     *VKMS
+	card     *drm.Card
+	cardName string
+	device   *vkmsDevice
+	scanout  *vkmsScanout
+	events   *eventQueue
+	uevents  *ueventSocket
+
+	// lock guards vkms, which Destroy clears, mode and connected, which are
+	// also updated from uevents, and connector, the virtual connector driven
+	// by modeset whose status is read from sysfs on hotplug.
+	lock      sync.Mutex
+	mode      Mode
+	connected bool
+	connector uint32
 }
 
 const (
-	vkmsDRIDir     = "/dev/dri"
 	vkmsIdentifier = "vkms"
+
+	vkmsUeventSubsystem = "drm"
+	vkmsUeventDRIDir    = "dri"
+
+	// Limits enforced by the vkms driver, see vkms_drv.c.
+	vkmsMinRes = 20
+	vkmsMaxRes = 8192
 )
 
-func newVKMS(c *drm.Card) (*VKMS, error) {
-	ret := &VKMS{card: c}
+func newVKMS(dev drm.SysfsDevice, c *drm.Card) (*VKMS, error) {
+	if err := checkVKMS(c); err != nil {
+		return nil, err
+	}
+	features, err := readVKMSFeatures(vkmsParametersDir)
+	if err != nil {
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	return &VKMS{card: c, cardPath: dev.PrimaryNode, sysfs: dev, features: features}, nil
+}
+
+func checkVKMS(c *drm.Card) error {
 	ver, err := c.Version()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if ver.Name != vkmsIdentifier {
-		return nil, fmt.Errorf("card is not vkms")
+		return fmt.Errorf("card is not vkms")
 	}
-	return ret, nil
+	return nil
 }
 
 func init() {
-	files, err := os.ReadDir(vkmsDRIDir)
+	Register("vkms", probeVKMS)
+}
+
+// probeVKMS checks for vkms configfs support, falling back to looking for a
+// loaded vkms card among the primary nodes listed in sysfs.
+func probeVKMS() (VDisplay, error) {
+	if vkmsConfigfsAvailable() {
+		log.Printf("[vkms] using configfs at %s", vkmsConfigfsDir)
+		return &VKMS{configfs: true}, nil
+	}
+
+	devices, err := drm.SysfsDevices(drm.SysfsDir)
 	if err != nil {
-		log.Printf("[vkms] readdir: %s", files)
+		return nil, fmt.Errorf("vkms: %w", err)
 	}
 
-	var found bool
-	for _, f := range files {
-		if f.Type()&os.ModeDevice != 0 {
-			p := filepath.Join(vkmsDRIDir, f.Name())
-			c, err := drm.Open(p)
-			if err != nil {
-				log.Printf("[vkms] open: %s", err)
-				continue
+	for _, dev := range devices {
+		c, err := drm.Open(dev.PrimaryNode)
+		if err != nil {
+			log.Printf("[vkms] open: %s", err)
+			continue
+		}
+		vkms, err := newVKMS(dev, c)
+		if err != nil {
+			c.Close()
+			continue
+		}
+		log.Printf("[vkms] using card %s (%s)", dev.PrimaryNode, vkms.features)
+		return vkms, nil
+	}
+	return nil, fmt.Errorf("vkms: no cards found, is the kernel module enabled?")
+}
+
+// Features returns the features of the card created when the module was
+// loaded. Devices built through configfs have all the features used by
+// vdisplay, so the returned features are all enabled.
+func (v *VKMS) Features() VKMSFeatures {
+	if v.configfs {
+		return VKMSFeatures{Cursor: true, Writeback: true}
+	}
+	return v.features
+}
+
+func (v *VKMS) priority() int {
+	return 100
+}
+
+// checkCapture checks that the card supports the capture that Capture picks:
+// writeback if it is enabled, otherwise reading the framebuffer of the primary
+// plane. Devices built through configfs are always created with writeback
+// enabled.
+func (v *VKMS) checkCapture() error {
+	if v.configfs {
+		return nil
+	}
+	check := capture.CheckScanout(v.card)
+	if v.features.Writeback {
+		check = capture.CheckWriteback(v.card, &v.sysfs)
+	}
+	if check != nil {
+		return fmt.Errorf("vkms: %w", check)
+	}
+	return nil
+}
+
+func (v *VKMS) Create(_ context.Context, mode Mode) (Display, error) {
+	if err := vkmsValidateMode(mode); err != nil {
+		return nil, err
+	}
+	if v.configfs {
+		return v.createDevice(mode)
+	}
+	if v.display != nil {
+		return nil, fmt.Errorf("vkms: %w", ErrBusy)
+	}
+	display := &vkmsDisplay{vkms: v, card: v.card, cardName: filepath.Base(v.cardPath), mode: mode}
+	if err := display.modeset(mode); err != nil {
+		if advice := drm.Advice(err); advice != "" {
+			log.Printf("[vkms] %s: %s", display.cardName, advice)
+		}
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	v.display = display
+	display.watch()
+	return display, nil
+}
+
+func (v *VKMS) createDevice(mode Mode) (Display, error) {
+	edid, err := newEDID(newEDIDSerial(), mode)
+	if err != nil {
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	device, err := createVKMSDevice(edid)
+	if err != nil {
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	ret := &vkmsDisplay{vkms: v, device: device, mode: mode}
+	path, err := device.cardPath()
+	if err != nil {
+		device.remove()
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	ret.cardName = filepath.Base(path)
+	if ret.card, err = drm.Open(path); err != nil {
+		device.remove()
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	if err := checkVKMS(ret.card); err != nil {
+		ret.card.Close()
+		device.remove()
+		return nil, fmt.Errorf("vkms: %s: %w", path, err)
+	}
+	// The card was just created, so this process is its first opener and
+	// holds DRM master.
+	if err := ret.modeset(mode); err != nil {
+		ret.card.Close()
+		device.remove()
+		return nil, fmt.Errorf("vkms: %s: %w", path, err)
+	}
+	log.Printf("[vkms] created device %s at %s", device.name, path)
+	ret.watch()
+	return ret, nil
+}
+
+func vkmsValidateMode(mode Mode) error {
+	if err := mode.validate(); err != nil {
+		return fmt.Errorf("vkms: %w", err)
+	}
+	if mode.Width < vkmsMinRes || mode.Height < vkmsMinRes ||
+		mode.Width > vkmsMaxRes || mode.Height > vkmsMaxRes {
+		return fmt.Errorf("vkms: %w: %s out of range", ErrInvalidMode, mode)
+	}
+	return nil
+}
+
+func (d *vkmsDisplay) Mode() Mode {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.mode
+}
+
+func (d *vkmsDisplay) Resize(mode Mode) error {
+	if err := vkmsValidateMode(mode); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.vkms == nil {
+		return fmt.Errorf("vkms: %w", ErrDestroyed)
+	}
+	if mode == d.mode {
+		return nil
+	}
+	if err := d.modeset(mode); err != nil {
+		return fmt.Errorf("vkms: %w", err)
+	}
+	d.mode = mode
+	d.events.send(Event{Type: EventModeChanged, Mode: mode})
+	return nil
+}
+
+// Capture returns a capture of the card driving the display, through writeback
+// if it is enabled. Otherwise the framebuffer of the primary plane is read,
+// which leaves out the cursor and overlay planes. This process must be DRM
+// master of the card, and the display must be active.
+func (d *vkmsDisplay) Capture() (capture.Capture, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.vkms == nil {
+		return nil, fmt.Errorf("vkms: %w", ErrDestroyed)
+	}
+	var (
+		ret capture.Capture
+		err error
+	)
+	if d.vkms.Features().Writeback {
+		ret, err = capture.NewWriteback(d.card)
+	} else {
+		log.Printf("[vkms] %s: %s", d.cardName, vkmsWritebackAdvice)
+		ret, err = capture.NewScanout(d.card)
+	}
+	if err != nil {
+		if advice := drm.Advice(err); advice != "" {
+			log.Printf("[vkms] %s: %s", d.cardName, advice)
+		}
+		return nil, fmt.Errorf("vkms: %w", err)
+	}
+	return ret, nil
+}
+
+func (d *vkmsDisplay) Events() <-chan Event {
+	return d.events.ch
+}
+
+// Destroy releases the display. Devices built through configfs are removed.
+func (d *vkmsDisplay) Destroy() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.vkms == nil {
+		return fmt.Errorf("vkms: %w", ErrDestroyed)
+	}
+	vkms := d.vkms
+	d.vkms = nil
+	if d.uevents != nil {
+		d.uevents.close()
+	}
+	d.events.close()
+	if d.scanout != nil {
+		d.scanout.release(d.card)
+		d.scanout = nil
+	}
+	if d.device == nil {
+		vkms.display = nil
+		return nil
+	}
+	d.card.Close()
+	if err := d.device.remove(); err != nil {
+		return fmt.Errorf("vkms: %w", err)
+	}
+	return nil
+}
+
+// watch reports the display as connected, then follows hotplug and removal
+// uevents of its card. Without uevents, only Resize is reported.
+func (d *vkmsDisplay) watch() {
+	d.events = newEventQueue()
+	d.connected = true
+	d.events.send(Event{Type: EventConnected, Mode: d.mode})
+
+	sock, err := openUeventSocket()
+	if err != nil {
+		log.Printf("[vkms] %s: %s", d.cardName, err)
+		return
+	}
+	d.uevents = sock
+	go func() {
+		for {
+			ev, err := sock.read()
+			if errors.Is(err, os.ErrClosed) {
+				return
 			}
-			vkms, err := newVKMS(c)
 			if err != nil {
-				log.Printf("[vkms] %s: %s", p, err)
-				c.Close()
+				d.events.send(Event{Type: EventError, Err: fmt.Errorf("vkms: %w", err)})
+				return
+			}
+			if ev.Subsystem != vkmsUeventSubsystem || ev.DevName != path.Join(vkmsUeventDRIDir, d.cardName) {
 				continue
 			}
-			log.Printf("[vkms] using card %s", p)
-			found = true
-			availableVDisplays = append(availableVDisplays, vkms)
-			return
+			switch {
+			case ev.Action == "remove":
+				d.setConnected(false)
+			case ev.Action == "change" && ev.Env["HOTPLUG"] == "1":
+				d.lock.Lock()
+				connector := d.connector
+				d.lock.Unlock()
+				connected, err := vkmsConnectorStatus(d.cardName, connector)
+				if err != nil {
+					log.Printf("[vkms] %s: %s", d.cardName, err)
+					continue
+				}
+				d.setConnected(connected)
+			}
 		}
+	}()
+}
+
+func (d *vkmsDisplay) setConnected(connected bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if connected == d.connected {
+		return
 	}
-	if !found {
-		log.Printf("[vkms] no cards found, is the kernel module enabled?")
+	d.connected = connected
+	if connected {
+		d.events.send(Event{Type: EventConnected, Mode: d.mode})
+	} else {
+		d.events.send(Event{Type: EventDisconnected})
 	}
 }
 
-func (v *VKMS) priority() int {
-	return 100
+// vkmsConnectorStatus reports whether a connector of the card is connected,
+// according to sysfs.
+func vkmsConnectorStatus(card string, connector uint32) (bool, error) {
+	devices, err := drm.SysfsDevices(drm.SysfsDir)
+	if err != nil {
+		return false, err
+	}
+	c, err := vkmsSysfsConnector(devices, card, connector)
+	if err != nil {
+		return false, err
+	}
+	return c.Connected(), nil
+}
+
+// vkmsSysfsConnector looks up a connector of the card by its object ID. Kernels
+// that don't report connector IDs in sysfs only leave the virtual connector to
+// go by.
+func vkmsSysfsConnector(devices []drm.SysfsDevice, card string, connector uint32) (*drm.SysfsConnector, error) {
+	virtual := drm.ConnectorTypeName(drm.ModeConnectorVirtual)
+	for _, dev := range devices {
+		if dev.Name != card {
+			continue
+		}
+		for i, c := range dev.Connectors {
+			if c.ID == connector || (c.ID == 0 && c.TypeName() == virtual) {
+				return &dev.Connectors[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%s has no connector %d", card, connector)
+	}
+	return nil, fmt.Errorf("%s not found in sysfs", card)
 }
@@ -0,0 +1,274 @@
+package vdisplay
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/inahga/vdisplay/capture"
+	"github.com/inahga/vdisplay/internal/drm"
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+// newFakeVKMSCard returns a card on a fake vkms device, active with mode.
+func newFakeVKMSCard(t *testing.T, mode Mode) (*drm.FakeDevice, *drm.Card) {
+	t.Helper()
+	timing, err := mode.timing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := drm.NewFakeVKMS(timing.DRM())
+	return dev, drm.NewWithTransport(dev)
+}
+
+func TestCheckVKMS(t *testing.T) {
+	_, card := newFakeVKMSCard(t, Mode{1024, 768, 60})
+	if err := checkVKMS(card); err != nil {
+		t.Errorf("vkms: %s", err)
+	}
+	if err := checkVKMS(drm.NewWithTransport(drm.NewFakeDevice("i915"))); err == nil {
+		t.Errorf("i915: got no error")
+	}
+}
+
+func TestVKMSModeset(t *testing.T) {
+	dev, card := newFakeVKMSCard(t, Mode{1024, 768, 60})
+	d := &vkmsDisplay{card: card}
+
+	var fbs []uint32
+	// CVT rounds the width of 1366x768 up to 1368.
+	for _, mode := range []Mode{{1366, 768, 60}, {800, 600, 75}} {
+		if err := d.modeset(mode); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		timing, _ := mode.timing()
+		want := timing.DRM()
+		connector, crtcID, plane, err := vkmsPipe(card)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		crtc, err := card.ModeGetCrtc(crtcID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crtc.Mode == nil || *crtc.Mode != want {
+			t.Errorf("%s: got CRTC mode %+v, want %+v", mode, crtc.Mode, want)
+		}
+		if v, _ := dev.Property(connector, "CRTC_ID"); v != uint64(crtcID) {
+			t.Errorf("%s: connector is on CRTC %d, want %d", mode, v, crtcID)
+		}
+		for name, value := range map[string]uint64{
+			"CRTC_ID": uint64(crtcID), "FB_ID": uint64(d.scanout.fb),
+			"SRC_W": uint64(want.HDisplay) << 16, "SRC_H": uint64(want.VDisplay) << 16,
+			"CRTC_W": uint64(want.HDisplay), "CRTC_H": uint64(want.VDisplay),
+		} {
+			if v, _ := dev.Property(plane, name); v != value {
+				t.Errorf("%s: got plane %s %d, want %d", mode, name, v, value)
+			}
+		}
+		if _, ok := dev.Framebuffer(d.scanout.fb); !ok {
+			t.Errorf("%s: no framebuffer %d", mode, d.scanout.fb)
+		}
+		if d.connector != connector {
+			t.Errorf("%s: recorded connector %d, want %d", mode, d.connector, connector)
+		}
+		if dumb := d.scanout.dumb; dumb.Width != uint32(want.HDisplay) || dumb.Height != uint32(want.VDisplay) {
+			t.Errorf("%s: got a %dx%d scanout, want %dx%d", mode, dumb.Width, dumb.Height, want.HDisplay, want.VDisplay)
+		}
+		fbs = append(fbs, d.scanout.fb)
+	}
+
+	// The first scanout is released by the second modeset.
+	if _, ok := dev.Framebuffer(fbs[0]); ok {
+		t.Errorf("framebuffer %d of the first mode was not released", fbs[0])
+	}
+	d.scanout.release(card)
+	if _, ok := dev.Framebuffer(fbs[1]); ok {
+		t.Errorf("framebuffer %d was not released", fbs[1])
+	}
+}
+
+func TestVKMSResize(t *testing.T) {
+	_, card := newFakeVKMSCard(t, Mode{1024, 768, 60})
+	d := &vkmsDisplay{vkms: &VKMS{}, card: card, mode: Mode{1024, 768, 60}, events: newEventQueue()}
+
+	mode := Mode{1920, 1080, 60}
+	if err := d.Resize(mode); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Mode(); got != mode {
+		t.Errorf("got mode %s, want %s", got, mode)
+	}
+	if err := d.Resize(mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Resize(Mode{vkmsMaxRes + 1, 1080, 60}); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("got %v, want ErrInvalidMode", err)
+	}
+	d.events.close()
+
+	var events []Event
+	for e := range d.Events() {
+		events = append(events, e)
+	}
+	if len(events) != 1 || events[0].Type != EventModeChanged || events[0].Mode != mode {
+		t.Errorf("got events %v, want a single mode change to %s", events, mode)
+	}
+}
+
+func TestVKMSCheckCapture(t *testing.T) {
+	dev, card := newFakeVKMSCard(t, Mode{1024, 768, 60})
+	v := &VKMS{card: card, features: VKMSFeatures{Writeback: true}, sysfs: drm.SysfsDevice{
+		Name:       "card0",
+		Connectors: []drm.SysfsConnector{{Name: "card0-Virtual-1"}, {Name: "card0-Writeback-1"}},
+	}}
+	if err := v.checkCapture(); err != nil {
+		t.Errorf("writeback enabled: %s", err)
+	}
+	v.sysfs.Connectors = v.sysfs.Connectors[:1]
+	if err := v.checkCapture(); err == nil {
+		t.Errorf("no writeback connector: got no error")
+	}
+	// Without writeback, the primary plane is read instead.
+	v.features.Writeback = false
+	if err := v.checkCapture(); err != nil {
+		t.Errorf("writeback disabled: %s", err)
+	}
+	dev.SetCap(drm.CapDumbBuffer, 0)
+	if err := v.checkCapture(); err == nil {
+		t.Errorf("writeback disabled, no dumb buffers: got no error")
+	}
+}
+
+func TestVKMSCapture(t *testing.T) {
+	for _, writeback := range []bool{true, false} {
+		_, card := newFakeVKMSCard(t, Mode{1024, 768, 60})
+		d := &vkmsDisplay{vkms: &VKMS{card: card, features: VKMSFeatures{Writeback: writeback}}, card: card}
+		if err := d.modeset(Mode{800, 600, 60}); err != nil {
+			t.Fatal(err)
+		}
+		pixel := [4]byte{0x10, 0x20, 0x30, 0xff}
+		mapping, err := card.MapDumb(d.scanout.dumb, fourcc.XRGB8888)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(mapping.Pix); i += 4 {
+			copy(mapping.Pix[i:], pixel[:])
+		}
+		mapping.Unmap()
+
+		c, err := d.Capture()
+		if err != nil {
+			t.Fatalf("writeback %t: %s", writeback, err)
+		}
+		switch c.(type) {
+		case *capture.Writeback:
+			if !writeback {
+				t.Errorf("writeback disabled: got a writeback capture")
+			}
+		case *capture.Scanout:
+			if writeback {
+				t.Errorf("writeback enabled: got a scanout capture")
+			}
+		default:
+			t.Errorf("writeback %t: got a %T capture", writeback, c)
+		}
+
+		frames := make(chan image.Image, 1)
+		err = c.Start(0, image.Rectangle{}, func(img image.Image) {
+			select {
+			case frames <- img:
+			default:
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var frame image.Image
+		select {
+		case frame = <-frames:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("writeback %t: no frame captured", writeback)
+		}
+		if err := c.Close(); err != nil {
+			t.Error(err)
+		}
+		rgba := frame.(*image.RGBA)
+		if rgba.Rect != image.Rect(0, 0, 800, 600) || !bytes.Equal(rgba.Pix[:3], pixel[:3]) {
+			t.Errorf("writeback %t: got a %s frame starting with % x", writeback, rgba.Rect, rgba.Pix[:4])
+		}
+	}
+}
+
+func TestVKMSSysfsConnector(t *testing.T) {
+	devices := []drm.SysfsDevice{
+		{Name: "card0", Connectors: []drm.SysfsConnector{{Name: "card0-eDP-1", ID: 40, Status: "connected"}}},
+		{Name: "card1", Connectors: []drm.SysfsConnector{
+			{Name: "card1-Writeback-1", ID: 35, Status: "unknown"},
+			{Name: "card1-Virtual-1", ID: 36, Status: "disconnected"},
+		}},
+		// Kernels before connector_id was added to sysfs.
+		{Name: "card2", Connectors: []drm.SysfsConnector{
+			{Name: "card2-Writeback-1", Status: "unknown"},
+			{Name: "card2-Virtual-1", Status: "connected"},
+		}},
+		{Name: "card3"},
+	}
+	for _, tt := range []struct {
+		card      string
+		connector uint32
+		want      string
+	}{
+		{"card1", 36, "card1-Virtual-1"},
+		{"card1", 35, "card1-Writeback-1"},
+		{"card2", 36, "card2-Virtual-1"},
+		{"card1", 40, ""},
+		{"card3", 36, ""},
+		{"card4", 36, ""},
+	} {
+		c, err := vkmsSysfsConnector(devices, tt.card, tt.connector)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s connector %d: got %s, want an error", tt.card, tt.connector, c.Name)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s connector %d: %s", tt.card, tt.connector, err)
+		case tt.want != "" && c.Name != tt.want:
+			t.Errorf("%s connector %d: got %s, want %s", tt.card, tt.connector, c.Name, tt.want)
+		}
+	}
+}
+
+func TestVKMSDestroy(t *testing.T) {
+	_, card := newFakeVKMSCard(t, Mode{1024, 768, 60})
+	d := &vkmsDisplay{vkms: &VKMS{card: card}, card: card, mode: Mode{1024, 768, 60}, events: newEventQueue()}
+	if err := d.modeset(d.mode); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one of concurrent calls destroys the display.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- d.Destroy() }()
+	}
+	var destroyed int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; errors.Is(err, ErrDestroyed) {
+			destroyed++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if destroyed != 1 {
+		t.Errorf("%d calls found the display destroyed, want 1", destroyed)
+	}
+
+	if err := d.Resize(Mode{800, 600, 60}); !errors.Is(err, ErrDestroyed) {
+		t.Errorf("Resize: got %v, want ErrDestroyed", err)
+	}
+	if _, err := d.Capture(); !errors.Is(err, ErrDestroyed) {
+		t.Errorf("Capture: got %v, want ErrDestroyed", err)
+	}
+}
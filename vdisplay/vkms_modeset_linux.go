@@ -0,0 +1,139 @@
+package vdisplay
+
+import (
+	"fmt"
+
+	"github.com/inahga/vdisplay/internal/drm"
+	"github.com/inahga/vdisplay/internal/fourcc"
+)
+
+// vkmsScanout is what the CRTC of a vkms display scans out: a black
+// framebuffer through the primary plane, in a mode held by a blob. It is
+// released once replaced by another modeset, or when the display is destroyed.
+type vkmsScanout struct {
+	dumb *drm.DumbBuffer
+	fb   uint32
+	blob uint32
+}
+
+// modeset enables the virtual connector of the card with mode, driven by its
+// CRTC and primary plane. Writeback capture and clients drawing on the card
+// need an active CRTC. The process must be DRM master of the card.
+func (d *vkmsDisplay) modeset(mode Mode) error {
+	t, err := mode.timing()
+	if err != nil {
+		return err
+	}
+	info := t.DRM()
+
+	if err := d.card.SetClientCap(drm.ClientCapAtomic, 1); err != nil {
+		return fmt.Errorf("atomic cap: %w", err)
+	}
+	connector, crtc, plane, err := vkmsPipe(d.card)
+	if err != nil {
+		return err
+	}
+
+	// The scanout covers the CRTC, whose width CVT may have rounded up.
+	w, h := uint64(info.HDisplay), uint64(info.VDisplay)
+	dumb, err := d.card.CreateDumb(uint32(w), uint32(h), 32)
+	if err != nil {
+		return err
+	}
+	next := &vkmsScanout{dumb: dumb}
+	if next.fb, err = d.card.AddDumbFB(dumb, fourcc.XRGB8888); err != nil {
+		next.release(d.card)
+		return err
+	}
+	if next.blob, err = d.card.ModeCreateModeBlob(&info); err != nil {
+		next.release(d.card)
+		return err
+	}
+
+	req := d.card.NewAtomicRequest()
+	for _, obj := range []struct {
+		id, typ uint32
+		values  map[string]uint64
+	}{
+		{connector, drm.ModeObjectConnector, map[string]uint64{"CRTC_ID": uint64(crtc)}},
+		{crtc, drm.ModeObjectCrtc, map[string]uint64{"ACTIVE": 1, "MODE_ID": uint64(next.blob)}},
+		{plane, drm.ModeObjectPlane, map[string]uint64{
+			"FB_ID": uint64(next.fb), "CRTC_ID": uint64(crtc),
+			// Source coordinates are 16.16 fixed point.
+			"SRC_X": 0, "SRC_Y": 0, "SRC_W": w << 16, "SRC_H": h << 16,
+			"CRTC_X": 0, "CRTC_Y": 0, "CRTC_W": w, "CRTC_H": h,
+		}},
+	} {
+		props, err := d.card.ObjectGetProperties(obj.id, obj.typ)
+		if err != nil {
+			next.release(d.card)
+			return err
+		}
+		for name, value := range obj.values {
+			if err := req.SetByName(obj.id, props, name, value); err != nil {
+				next.release(d.card)
+				return err
+			}
+		}
+	}
+	if err := req.Commit(drm.AtomicAllowModeset); err != nil {
+		next.release(d.card)
+		return fmt.Errorf("modeset: %w", err)
+	}
+	if d.scanout != nil {
+		d.scanout.release(d.card)
+	}
+	d.scanout, d.connector = next, connector
+	return nil
+}
+
+// vkmsPipe finds the virtual connector of a vkms card, the CRTC it can be
+// driven by, and the primary plane of the CRTC.
+func vkmsPipe(c *drm.Card) (connector, crtc, plane uint32, err error) {
+	res, err := c.ModeGetResources()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, id := range res.Connectors {
+		conn, err := c.ModeGetConnector(id)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if conn.Type == drm.ModeConnectorWriteback {
+			continue
+		}
+		var possibleCrtcs uint32
+		for _, encID := range conn.Encoders {
+			enc, err := c.ModeGetEncoder(encID)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			possibleCrtcs |= enc.PossibleCrtcs
+		}
+		for i, crtc := range res.Crtcs {
+			if possibleCrtcs&(1<<i) == 0 {
+				continue
+			}
+			plane, err := c.PrimaryPlane(i)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			if plane != 0 {
+				return id, crtc, plane, nil
+			}
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("no connector with a CRTC and primary plane")
+}
+
+// release frees the scanout. A framebuffer still in use is disabled by the
+// kernel along with its plane and CRTC.
+func (s *vkmsScanout) release(c *drm.Card) {
+	if s.blob != 0 {
+		c.ModeDestroyPropBlob(s.blob)
+	}
+	if s.fb != 0 {
+		c.RmFB(s.fb)
+	}
+	c.DestroyDumb(s.dumb.Handle)
+}
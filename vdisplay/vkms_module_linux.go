@@ -0,0 +1,55 @@
+package vdisplay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VKMSFeatures are the optional features of the vkms card created when the
+// module is loaded, as chosen by its module parameters. Devices built through
+// configfs are configured separately, and always have a cursor and writeback.
+type VKMSFeatures struct {
+	Cursor    bool
+	Writeback bool
+	Overlay   bool
+}
+
+const vkmsParametersDir = "/sys/module/vkms/parameters"
+
+// readVKMSFeatures reads the module parameters under dir. Kernels without a
+// parameter predate its feature, so missing parameters are reported disabled.
+func readVKMSFeatures(dir string) (VKMSFeatures, error) {
+	var ret VKMSFeatures
+	for name, feature := range map[string]*bool{
+		"enable_cursor":    &ret.Cursor,
+		"enable_writeback": &ret.Writeback,
+		"enable_overlay":   &ret.Overlay,
+	} {
+		value, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return VKMSFeatures{}, fmt.Errorf("module parameters: %w", err)
+		}
+		*feature = strings.TrimSpace(string(value)) == "Y"
+	}
+	return ret, nil
+}
+
+func (f VKMSFeatures) String() string {
+	yn := func(b bool) string {
+		if b {
+			return "Y"
+		}
+		return "N"
+	}
+	return fmt.Sprintf("cursor=%s writeback=%s overlay=%s", yn(f.Cursor), yn(f.Writeback), yn(f.Overlay))
+}
+
+// vkmsWritebackAdvice tells how to enable writeback, without which captures
+// leave out the cursor and overlay planes.
+const vkmsWritebackAdvice = "writeback is disabled, so only the primary plane is captured; reload the module with " +
+	"`modprobe -r vkms && modprobe vkms enable_writeback=1` to capture all planes"
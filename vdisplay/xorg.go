@@ -1,11 +1,531 @@
-//go:build cgo && (linux || freebsd || openbsd || dragonfly)
+//go:build linux || freebsd || openbsd || dragonfly
 
 package vdisplay
 
-// Xorg sets up a VIRTUAL display with xrandr, and captures screenshots with
-// Xlib. Not all drivers support a VIRTUAL display.
-type Xorg struct{}
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/inahga/vdisplay/capture"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+)
+
+// Xorg sets up a VIRTUAL display with RandR, and captures it with the X11
+// capture backend. Not all drivers support a VIRTUAL display, e.g. the intel
+// driver requires the VirtualHeads option.
+type Xorg struct {
+	conn *xgb.Conn
+	root xproto.Window
+
+	// lock serializes changes to the screen configuration, and guards displays.
+	lock     sync.Mutex
+	displays map[randr.Crtc]*XorgDisplay
+}
+
+// XorgDisplay is a VIRTUAL output driven by one of the free CRTCs of the X
+// screen. It is placed to the right of the existing screen contents.
+type XorgDisplay struct {
+	xorg *Xorg
+	// lock is the lock of the Xorg backend, which also guards xorg, cleared
+	// by Destroy, and mode, rect and connected as they are updated from RandR
+	// events.
+	lock   *sync.Mutex
+	output randr.Output
+	name   string
+	crtc   randr.Crtc
+	modeID randr.Mode
+	mode   Mode
+	rect   image.Rectangle
+	serial uint32
+	events *eventQueue
+	// connected is whether the CRTC is driving the output.
+	connected bool
+
+	// Screen size before the display was added, restored on Destroy.
+	screenWidth, screenHeight     uint16
+	screenMMWidth, screenMMHeight uint32
+}
+
+const xorgVirtualPrefix = "VIRTUAL"
+
+func init() {
+	Register("xorg", probeXorg)
+}
+
+// probeXorg connects to the X server and checks that it has VIRTUAL outputs.
+func probeXorg() (VDisplay, error) {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("xorg: %w", err)
+	}
+	ret, err := newXorg(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+func newXorg(conn *xgb.Conn) (*Xorg, error) {
+	if err := randr.Init(conn); err != nil {
+		return nil, fmt.Errorf("xorg: randr: %w", err)
+	}
+	ver, err := randr.QueryVersion(conn, 1, 3).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("xorg: randr: %w", err)
+	}
+	if ver.MajorVersion < 1 || (ver.MajorVersion == 1 && ver.MinorVersion < 3) {
+		return nil, fmt.Errorf("xorg: randr %d.%d is too old, need 1.3", ver.MajorVersion, ver.MinorVersion)
+	}
+
+	ret := &Xorg{
+		conn:     conn,
+		root:     xproto.Setup(conn).DefaultScreen(conn).Root,
+		displays: make(map[randr.Crtc]*XorgDisplay),
+	}
+	res, err := randr.GetScreenResourcesCurrent(conn, ret.root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("xorg: GetScreenResourcesCurrent: %w", err)
+	}
+	for _, output := range res.Outputs {
+		info, err := randr.GetOutputInfo(conn, output, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("xorg: GetOutputInfo: %w", err)
+		}
+		if strings.HasPrefix(string(info.Name), xorgVirtualPrefix) {
+			if err := randr.SelectInputChecked(conn, ret.root, randr.NotifyMaskCrtcChange).Check(); err != nil {
+				return nil, fmt.Errorf("xorg: randr SelectInput: %w", err)
+			}
+			go ret.watch()
+			return ret, nil
+		}
+	}
+	return nil, fmt.Errorf("xorg: no %s outputs, does the driver support them?", xorgVirtualPrefix)
+}
+
+func (x *Xorg) Create(_ context.Context, mode Mode) (Display, error) {
+	if err := mode.validate(); err != nil {
+		return nil, fmt.Errorf("xorg: %w", err)
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	res, err := randr.GetScreenResourcesCurrent(x.conn, x.root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("xorg: GetScreenResourcesCurrent: %w", err)
+	}
+	ret := &XorgDisplay{xorg: x, lock: &x.lock, serial: newEDIDSerial(), events: newEventQueue()}
+	if err := ret.findOutput(res); err != nil {
+		return nil, fmt.Errorf("xorg: %w", err)
+	}
+
+	geom, err := xproto.GetGeometry(x.conn, xproto.Drawable(x.root)).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("xorg: GetGeometry: %w", err)
+	}
+	screen := xproto.Setup(x.conn).DefaultScreen(x.conn)
+	ret.screenWidth, ret.screenHeight = geom.Width, geom.Height
+	ret.screenMMWidth, ret.screenMMHeight = uint32(screen.WidthInMillimeters), uint32(screen.HeightInMillimeters)
+
+	if err := ret.setMode(mode, int(ret.screenWidth)); err != nil {
+		ret.teardown()
+		return nil, fmt.Errorf("xorg: %w", err)
+	}
+	x.displays[ret.crtc] = ret
+	ret.connected = true
+	ret.events.send(Event{Type: EventConnected, Mode: ret.mode})
+	return ret, nil
+}
 
 func (x *Xorg) priority() int {
 	return 25
 }
+
+// findOutput picks a disconnected VIRTUAL output that is not driven by a CRTC,
+// and a free CRTC that can drive it.
+func (d *XorgDisplay) findOutput(res *randr.GetScreenResourcesCurrentReply) error {
+	conn := d.xorg.conn
+	for _, output := range res.Outputs {
+		info, err := randr.GetOutputInfo(conn, output, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return fmt.Errorf("GetOutputInfo: %w", err)
+		}
+		if !strings.HasPrefix(string(info.Name), xorgVirtualPrefix) ||
+			info.Connection != randr.ConnectionDisconnected || info.Crtc != 0 {
+			continue
+		}
+		for _, crtc := range info.Crtcs {
+			crtcInfo, err := randr.GetCrtcInfo(conn, crtc, res.ConfigTimestamp).Reply()
+			if err != nil {
+				return fmt.Errorf("GetCrtcInfo: %w", err)
+			}
+			if crtcInfo.Mode == 0 && len(crtcInfo.Outputs) == 0 {
+				d.output, d.name, d.crtc = output, string(info.Name), crtc
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: no free %s output", ErrBusy, xorgVirtualPrefix)
+}
+
+// xorgModeInfo returns the RandR mode for the timing of mode, and the mode as
+// the X server reports it: CVT rounds the width up to a multiple of 8.
+func xorgModeInfo(mode Mode) (randr.ModeInfo, Mode, error) {
+	t, err := mode.timing()
+	if err != nil {
+		return randr.ModeInfo{}, Mode{}, err
+	}
+	info := t.RandR()
+	return info, randrMode(info), nil
+}
+
+// randrMode returns the resolution and refresh rate of a RandR mode.
+func randrMode(info randr.ModeInfo) Mode {
+	refresh := math.Round(float64(info.DotClock) / float64(info.Htotal) / float64(info.Vtotal))
+	return Mode{Width: uint32(info.Width), Height: uint32(info.Height), RefreshRate: uint32(refresh)}
+}
+
+// setMode creates the RandR mode, attaches it to the output and drives the
+// output at horizontal offset x, growing the screen to fit.
+func (d *XorgDisplay) setMode(mode Mode, x int) error {
+	conn, root := d.xorg.conn, d.xorg.root
+
+	info, actual, err := xorgModeInfo(mode)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s", d.name, actual)
+	info.NameLen = uint16(len(name))
+	modeID, err := randr.CreateMode(conn, root, info, name).Reply()
+	if err != nil {
+		return fmt.Errorf("CreateMode: %w", err)
+	}
+	if err := randr.AddOutputModeChecked(conn, d.output, modeID.Mode).Check(); err != nil {
+		randr.DestroyMode(conn, modeID.Mode)
+		return fmt.Errorf("AddOutputMode: %w", err)
+	}
+
+	rect := image.Rect(x, 0, x+int(actual.Width), int(actual.Height))
+	if err := d.growScreen(rect); err != nil {
+		d.removeMode(modeID.Mode)
+		return err
+	}
+
+	res, err := randr.GetScreenResourcesCurrent(conn, root).Reply()
+	if err != nil {
+		d.removeMode(modeID.Mode)
+		return fmt.Errorf("GetScreenResourcesCurrent: %w", err)
+	}
+	reply, err := randr.SetCrtcConfig(conn, d.crtc, xproto.TimeCurrentTime, res.ConfigTimestamp,
+		int16(rect.Min.X), int16(rect.Min.Y), modeID.Mode, randr.RotationRotate0, []randr.Output{d.output}).Reply()
+	if err != nil {
+		d.removeMode(modeID.Mode)
+		return fmt.Errorf("SetCrtcConfig: %w", err)
+	}
+	if reply.Status != randr.SetConfigSuccess {
+		d.removeMode(modeID.Mode)
+		return fmt.Errorf("SetCrtcConfig: status %d", reply.Status)
+	}
+
+	if d.modeID != 0 {
+		d.removeMode(d.modeID)
+	}
+	d.modeID, d.mode, d.rect = modeID.Mode, actual, rect
+	if err := d.setEDID(mode); err != nil {
+		log.Printf("[xorg] %s: %s", d.name, err)
+	}
+	return nil
+}
+
+// setEDID replaces the EDID property of the output, so that clients see a named
+// monitor with mode as its preferred timing.
+func (d *XorgDisplay) setEDID(mode Mode) error {
+	b, err := newEDID(d.serial, mode)
+	if err != nil {
+		return err
+	}
+	atom, err := d.xorg.edidAtom()
+	if err != nil {
+		return err
+	}
+	if err := randr.ChangeOutputPropertyChecked(d.xorg.conn, d.output, atom, xproto.AtomInteger, 8,
+		xproto.PropModeReplace, uint32(len(b)), b).Check(); err != nil {
+		return fmt.Errorf("ChangeOutputProperty: %w", err)
+	}
+	return nil
+}
+
+func (x *Xorg) edidAtom() (xproto.Atom, error) {
+	const name = "EDID"
+	reply, err := xproto.InternAtom(x.conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("InternAtom: %w", err)
+	}
+	return reply.Atom, nil
+}
+
+// growScreen enlarges the X screen so that it contains rect, keeping its DPI.
+func (d *XorgDisplay) growScreen(rect image.Rectangle) error {
+	conn, root := d.xorg.conn, d.xorg.root
+	geom, err := xproto.GetGeometry(conn, xproto.Drawable(root)).Reply()
+	if err != nil {
+		return fmt.Errorf("GetGeometry: %w", err)
+	}
+	width, height := int(geom.Width), int(geom.Height)
+	if rect.Max.X <= width && rect.Max.Y <= height {
+		return nil
+	}
+	if rect.Max.X > width {
+		width = rect.Max.X
+	}
+	if rect.Max.Y > height {
+		height = rect.Max.Y
+	}
+
+	sizeRange, err := randr.GetScreenSizeRange(conn, root).Reply()
+	if err != nil {
+		return fmt.Errorf("GetScreenSizeRange: %w", err)
+	}
+	if width > int(sizeRange.MaxWidth) || height > int(sizeRange.MaxHeight) {
+		return fmt.Errorf("%w: screen would be %dx%d, maximum is %dx%d", ErrInvalidMode,
+			width, height, sizeRange.MaxWidth, sizeRange.MaxHeight)
+	}
+	return d.setScreenSize(uint16(width), uint16(height))
+}
+
+func (d *XorgDisplay) setScreenSize(width, height uint16) error {
+	mmWidth := uint32(width) * d.screenMMWidth / uint32(d.screenWidth)
+	mmHeight := uint32(height) * d.screenMMHeight / uint32(d.screenHeight)
+	if err := randr.SetScreenSizeChecked(d.xorg.conn, d.xorg.root, width, height, mmWidth, mmHeight).Check(); err != nil {
+		return fmt.Errorf("SetScreenSize: %w", err)
+	}
+	return nil
+}
+
+func (d *XorgDisplay) removeMode(mode randr.Mode) {
+	randr.DeleteOutputMode(d.xorg.conn, d.output, mode)
+	randr.DestroyMode(d.xorg.conn, mode)
+}
+
+// teardown disables the CRTC and removes the mode, then shrinks the screen back
+// to its original size or to what the remaining CRTCs still need.
+func (d *XorgDisplay) teardown() error {
+	conn, root := d.xorg.conn, d.xorg.root
+	res, err := randr.GetScreenResourcesCurrent(conn, root).Reply()
+	if err != nil {
+		return fmt.Errorf("GetScreenResourcesCurrent: %w", err)
+	}
+	if _, err := randr.SetCrtcConfig(conn, d.crtc, xproto.TimeCurrentTime, res.ConfigTimestamp,
+		0, 0, 0, randr.RotationRotate0, nil).Reply(); err != nil {
+		return fmt.Errorf("SetCrtcConfig: %w", err)
+	}
+	if d.modeID != 0 {
+		d.removeMode(d.modeID)
+		d.modeID = 0
+	}
+	if atom, err := d.xorg.edidAtom(); err == nil {
+		randr.DeleteOutputProperty(conn, d.output, atom)
+	}
+
+	width, height := int(d.screenWidth), int(d.screenHeight)
+	for _, crtc := range res.Crtcs {
+		if crtc == d.crtc {
+			continue
+		}
+		info, err := randr.GetCrtcInfo(conn, crtc, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return fmt.Errorf("GetCrtcInfo: %w", err)
+		}
+		if info.Mode == 0 {
+			continue
+		}
+		if w := int(info.X) + int(info.Width); w > width {
+			width = w
+		}
+		if h := int(info.Y) + int(info.Height); h > height {
+			height = h
+		}
+	}
+	return d.setScreenSize(uint16(width), uint16(height))
+}
+
+// Rect returns the area of the X screen covered by the display.
+func (d *XorgDisplay) Rect() image.Rectangle {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.rect
+}
+
+func (d *XorgDisplay) Mode() Mode {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.mode
+}
+
+func (d *XorgDisplay) Resize(mode Mode) error {
+	if err := mode.validate(); err != nil {
+		return fmt.Errorf("xorg: %w", err)
+	}
+	_, actual, err := xorgModeInfo(mode)
+	if err != nil {
+		return fmt.Errorf("xorg: %w", err)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.xorg == nil {
+		return fmt.Errorf("xorg: %w", ErrDestroyed)
+	}
+	if actual == d.mode {
+		return nil
+	}
+	if err := d.setMode(mode, d.rect.Min.X); err != nil {
+		return fmt.Errorf("xorg: %w", err)
+	}
+	typ := EventModeChanged
+	if !d.connected {
+		typ = EventConnected
+	}
+	d.connected = true
+	d.events.send(Event{Type: typ, Mode: d.mode})
+	return nil
+}
+
+// xorgCapture captures the area of the X screen covered by a display, as it is
+// when Start is called.
+type xorgCapture struct {
+	*capture.X11
+	display *XorgDisplay
+}
+
+// Capture returns a capture of the display area of the X screen, over a new
+// connection to the X server.
+func (d *XorgDisplay) Capture() (capture.Capture, error) {
+	d.lock.Lock()
+	destroyed := d.xorg == nil
+	d.lock.Unlock()
+	if destroyed {
+		return nil, fmt.Errorf("xorg: %w", ErrDestroyed)
+	}
+	x11, err := capture.NewX11()
+	if err != nil {
+		return nil, fmt.Errorf("xorg: %w", err)
+	}
+	return &xorgCapture{X11: x11, display: d}, nil
+}
+
+func (c *xorgCapture) Start(framerate uint32, rect image.Rectangle, cb func(image.Image)) error {
+	area := c.display.Rect()
+	if !rect.Empty() {
+		// An empty area would be taken as the whole screen, which shows other
+		// outputs.
+		bounds := area
+		if area = rect.Add(bounds.Min).Intersect(bounds); area.Empty() {
+			return fmt.Errorf("xorg: capture area outside of the %dx%d display", bounds.Dx(), bounds.Dy())
+		}
+	}
+	return c.X11.Start(framerate, area, cb)
+}
+
+func (d *XorgDisplay) Events() <-chan Event {
+	return d.events.ch
+}
+
+func (d *XorgDisplay) Destroy() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.xorg == nil {
+		return fmt.Errorf("xorg: %w", ErrDestroyed)
+	}
+	delete(d.xorg.displays, d.crtc)
+	err := d.teardown()
+	d.xorg = nil
+	d.events.close()
+	if err != nil {
+		return fmt.Errorf("xorg: %w", err)
+	}
+	return nil
+}
+
+// watch dispatches RandR CRTC changes to the displays driven by the CRTCs, until
+// the connection to the X server is closed.
+func (x *Xorg) watch() {
+	for {
+		ev, xerr := x.conn.WaitForEvent()
+		if ev == nil && xerr == nil {
+			x.lock.Lock()
+			for _, d := range x.displays {
+				d.events.send(Event{Type: EventError, Err: fmt.Errorf("xorg: connection to X server closed")})
+			}
+			x.lock.Unlock()
+			return
+		}
+		// Errors are those of unchecked requests, which are best effort.
+		if n, ok := ev.(randr.NotifyEvent); ok && n.SubCode == randr.NotifyCrtcChange {
+			x.crtcChanged(n.U.Cc)
+		}
+	}
+}
+
+// crtcChanged reports a CRTC change made by any client, including this one.
+// Changes made by Create and Resize are already reported and match the state
+// of the display.
+func (x *Xorg) crtcChanged(cc randr.CrtcChange) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	d := x.displays[cc.Crtc]
+	if d == nil {
+		return
+	}
+	if cc.Mode == 0 {
+		if d.connected {
+			d.connected = false
+			d.events.send(Event{Type: EventDisconnected})
+		}
+		return
+	}
+
+	mode, err := x.lookupMode(cc.Mode)
+	if err != nil {
+		log.Printf("[xorg] %s: %s", d.name, err)
+		return
+	}
+	d.update(mode, image.Rect(int(cc.X), int(cc.Y), int(cc.X)+int(cc.Width), int(cc.Y)+int(cc.Height)))
+}
+
+// update records the mode and geometry of the CRTC driving the display, and
+// sends an event if the display was connected or its mode changed. The lock
+// must be held.
+func (d *XorgDisplay) update(mode Mode, rect image.Rectangle) {
+	d.rect = rect
+	switch {
+	case !d.connected:
+		d.connected, d.mode = true, mode
+		d.events.send(Event{Type: EventConnected, Mode: mode})
+	case mode != d.mode:
+		d.mode = mode
+		d.events.send(Event{Type: EventModeChanged, Mode: mode})
+	}
+}
+
+// lookupMode returns the resolution and refresh rate of a RandR mode.
+func (x *Xorg) lookupMode(id randr.Mode) (Mode, error) {
+	res, err := randr.GetScreenResourcesCurrent(x.conn, x.root).Reply()
+	if err != nil {
+		return Mode{}, fmt.Errorf("GetScreenResourcesCurrent: %w", err)
+	}
+	for _, info := range res.Modes {
+		if randr.Mode(info.Id) != id || info.Htotal == 0 || info.Vtotal == 0 {
+			continue
+		}
+		return randrMode(info), nil
+	}
+	return Mode{}, fmt.Errorf("unknown mode %d", id)
+}
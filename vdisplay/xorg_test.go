@@ -0,0 +1,59 @@
+//go:build linux || freebsd || openbsd || dragonfly
+
+package vdisplay
+
+import (
+	"image"
+	"sync"
+	"testing"
+)
+
+func TestXorgModeInfo(t *testing.T) {
+	for _, tt := range []struct {
+		mode, want Mode
+	}{
+		{Mode{1920, 1080, 60}, Mode{1920, 1080, 60}},
+		// CVT rounds the width up to a multiple of 8.
+		{Mode{1366, 768, 60}, Mode{1368, 768, 60}},
+		{Mode{1366, 768, 75}, Mode{1368, 768, 75}},
+	} {
+		info, got, err := xorgModeInfo(tt.mode)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.mode, err)
+		}
+		if got != tt.want || uint32(info.Width) != tt.want.Width || uint32(info.Height) != tt.want.Height {
+			t.Errorf("%s: got %s from a %dx%d RandR mode, want %s", tt.mode, got, info.Width, info.Height, tt.want)
+		}
+	}
+}
+
+func TestXorgUpdate(t *testing.T) {
+	// The CRTC change that follows creating a 1366x768 display reports the
+	// 1368 pixel wide mode that was created.
+	info, mode, err := xorgModeInfo(Mode{1366, 768, 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rect := image.Rect(1920, 0, 1920+int(info.Width), int(info.Height))
+	d := &XorgDisplay{lock: &sync.Mutex{}, mode: mode, rect: rect, connected: true, events: newEventQueue()}
+	d.update(randrMode(info), rect)
+
+	// A mode changed by another client is reported.
+	info, want, err := xorgModeInfo(Mode{1024, 768, 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.update(randrMode(info), image.Rect(1920, 0, 2944, 768))
+	if d.rect != image.Rect(1920, 0, 2944, 768) {
+		t.Errorf("got rect %s", d.rect)
+	}
+	d.events.close()
+
+	var events []Event
+	for e := range d.Events() {
+		events = append(events, e)
+	}
+	if len(events) != 1 || events[0].Type != EventModeChanged || events[0].Mode != want {
+		t.Errorf("got events %v, want a single mode change to %s", events, want)
+	}
+}